@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
@@ -13,6 +14,7 @@ import (
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/protomaps/go-pmtiles/pmtiles"
+	"github.com/rs/cors"
 	"go.uber.org/zap"
 	_ "gocloud.dev/blob/azureblob"
 	_ "gocloud.dev/blob/fileblob"
@@ -26,12 +28,13 @@ func init() {
 }
 
 // Middleware creates a Z/X/Y tileserver backed by a local or remote bucket of PMTiles archives.
+// Its options are those of the pmtiles serve config file, which can also be read from ConfigFile.
 type Middleware struct {
-	Bucket    string `json:"bucket"`
-	CacheSize int    `json:"cache_size"`
-	PublicURL string `json:"public_url"`
-	logger    *zap.Logger
-	server    *pmtiles.Server
+	pmtiles.ServeConfig
+	ConfigFile string `json:"config_file,omitempty"`
+	logger     *zap.Logger
+	server     *pmtiles.Server
+	cors       *cors.Cors
 }
 
 // CaddyModule returns the Caddy module information.
@@ -44,14 +47,30 @@ func (Middleware) CaddyModule() caddy.ModuleInfo {
 
 func (m *Middleware) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
+	if m.ConfigFile != "" {
+		if err := pmtiles.LoadConfig(m.ConfigFile, &m.ServeConfig); err != nil {
+			return err
+		}
+	}
+	if err := m.ServeConfig.Validate(); err != nil {
+		return err
+	}
 	logger := log.New(io.Discard, "", log.Ldate)
 	prefix := "." // serve only the root of the bucket for now, at the root route of Caddyfile
-	server, err := pmtiles.NewServer(m.Bucket, prefix, logger, m.CacheSize, m.PublicURL)
+	server, err := pmtiles.NewServerFromConfig(m.ServeConfig, prefix, logger)
 	if err != nil {
 		return err
 	}
+	if len(m.Cors) > 0 {
+		m.cors = pmtiles.NewCors(strings.Join(m.Cors, ","))
+	}
 	m.server = server
 	server.Start()
+	if m.WatchInterval > 0 {
+		if err := server.Watch(ctx, time.Duration(m.WatchInterval), m.Prewarm); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -59,15 +78,20 @@ func (m *Middleware) Validate() error {
 	if m.Bucket == "" {
 		return fmt.Errorf("no bucket")
 	}
-	if m.CacheSize <= 0 {
-		m.CacheSize = 64
-	}
 	return nil
 }
 
 func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	start := time.Now()
-	statusCode := m.server.ServeHTTP(w, r)
+	statusCode := http.StatusNoContent // preflight requests are answered by the CORS handler
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		statusCode = m.server.ServeHTTP(w, r)
+	}
+	if m.cors != nil {
+		m.cors.ServeHTTP(w, r, serve)
+	} else {
+		serve(w, r)
+	}
 	m.logger.Info("response", zap.Int("status", statusCode), zap.String("path", r.URL.Path), zap.Duration("duration", time.Since(start)))
 
 	return next.ServeHTTP(w, r)
@@ -95,6 +119,112 @@ func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if !d.Args(&m.PublicURL) {
 					return d.ArgErr()
 				}
+			case "overzoom":
+				var overzoom string
+				if !d.Args(&overzoom) {
+					return d.ArgErr()
+				}
+				num, err := strconv.ParseUint(overzoom, 10, 8)
+				if err != nil {
+					return d.ArgErr()
+				}
+				m.Overzoom = uint8(num)
+			case "transcode_raster":
+				var entries string
+				if !d.Args(&entries) {
+					return d.ArgErr()
+				}
+				num, err := strconv.Atoi(entries)
+				if err != nil {
+					return d.ArgErr()
+				}
+				m.TranscodeRaster = num
+			case "tile_cache_size":
+				var tileCacheSize string
+				if !d.Args(&tileCacheSize) {
+					return d.ArgErr()
+				}
+				num, err := strconv.Atoi(tileCacheSize)
+				if err != nil {
+					return d.ArgErr()
+				}
+				m.TileCacheSize = num
+			case "tile_cache_dir":
+				if !d.Args(&m.TileCacheDir) {
+					return d.ArgErr()
+				}
+			case "viewer":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Viewer = true
+			case "watch_interval":
+				var interval string
+				if !d.Args(&interval) {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(interval)
+				if err != nil {
+					return d.Errf("invalid watch_interval: %v", err)
+				}
+				m.WatchInterval = pmtiles.Duration(dur)
+			case "prewarm":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				m.Prewarm = true
+			case "aliases_file":
+				if !d.Args(&m.AliasesFile) {
+					return d.ArgErr()
+				}
+			case "cors":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				m.Cors = append(m.Cors, args...)
+			case "cache_control":
+				args := d.RemainingArgs()
+				if m.CacheControl == nil {
+					m.CacheControl = make(pmtiles.CacheControlPolicy)
+				}
+				switch len(args) {
+				case 1:
+					m.CacheControl["default"] = args[0]
+				case 2:
+					m.CacheControl[args[0]] = args[1]
+				default:
+					return d.ArgErr()
+				}
+			case "signing_key":
+				if m.Auth == nil {
+					m.Auth = &pmtiles.AuthConfig{}
+				}
+				if !d.Args(&m.Auth.SigningKey) {
+					return d.ArgErr()
+				}
+			case "api_keys":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				if m.Auth == nil {
+					m.Auth = &pmtiles.AuthConfig{}
+				}
+				m.Auth.APIKeys = append(m.Auth.APIKeys, args...)
+			case "config":
+				if !d.Args(&m.ConfigFile) {
+					return d.ArgErr()
+				}
+			case "composite":
+				args := d.RemainingArgs()
+				if len(args) < 2 {
+					return d.ArgErr()
+				}
+				if m.Composites == nil {
+					m.Composites = make(map[string][]string)
+				}
+				m.Composites[args[0]] = args[1:]
 			}
 		}
 	}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -28,6 +32,15 @@ var (
 	date    = "unknown"
 )
 
+// serveConfig is the config file of the serve command, adding listener settings to pmtiles.ServeConfig.
+type serveConfig struct {
+	Path      string `json:"path,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Port      int    `json:"port,omitempty"`
+	AdminPort int    `json:"admin_port,omitempty"`
+	pmtiles.ServeConfig
+}
+
 var cli struct {
 	Quiet bool `help:"Silence logging and progress output" short:"q"`
 
@@ -49,10 +62,20 @@ var cli struct {
 	} `cmd:"" help:"Fetch one tile from a local or remote archive and output on stdout"`
 
 	Cluster struct {
-		Input           string `arg:"" help:"Input archive" type:"existingfile"`
-		NoDeduplication bool   `help:"Don't attempt to deduplicate tiles"`
+		Input               string `arg:"" help:"Input archive" type:"existingfile"`
+		NoDeduplication     bool   `help:"Don't attempt to deduplicate tiles"`
+		TileCompression     string `help:"Tile compression: none, gzip, br or zstd. Defaults to the input's"`
+		InternalCompression string `help:"Directory and metadata compression: none, gzip, br or zstd. Defaults to gzip"`
 	} `cmd:"" help:"Cluster an unclustered local archive, optimizing the size and layout"`
 
+	Recompress struct {
+		Input               string `arg:"" help:"Input archive" type:"existingfile"`
+		Output              string `arg:"" help:"Output archive" type:"path"`
+		TileCompression     string `help:"Tile compression: none, gzip, br or zstd. Defaults to the input's"`
+		InternalCompression string `help:"Directory and metadata compression: none, gzip, br or zstd. Defaults to the input's"`
+		Threads             int    `default:"4" help:"Number of threads for compressing tiles"`
+	} `cmd:"" help:"Rewrite a local archive with different tile and directory compression"`
+
 	Edit struct {
 		Input      string `arg:"" help:"Input archive" type:"existingfile"`
 		HeaderJson string `help:"Input header JSON file (written by show --header-json)" type:"existingfile"`
@@ -72,21 +95,49 @@ var cli struct {
 		Overfetch       float32 `default:"0.05" help:"What ratio of extra data to download to minimize # requests; 0.2 is 20%"`
 	} `cmd:"" help:"Create an archive from a larger archive for a subset of zoom levels or geographic region"`
 
+	Subtract struct {
+		Input   string `arg:"" help:"Input local or remote archive"`
+		Output  string `arg:"" help:"Output archive" type:"path"`
+		Bucket  string `help:"Remote bucket of input archive"`
+		Region  string `help:"local GeoJSON Polygon or MultiPolygon file for area to remove" type:"existingfile"`
+		Bbox    string `help:"bbox area to remove: min_lon,min_lat,max_lon,max_lat" type:"string"`
+		Minzoom int8   `default:"-1" help:"Minimum zoom level to remove, inclusive"`
+		Maxzoom int8   `default:"-1" help:"Maximum zoom level to remove, inclusive"`
+	} `cmd:"" help:"Create an archive without the tiles intersecting a geographic region"`
+
+	ExportDir struct {
+		Input     string `arg:"" help:"Input local or remote archive"`
+		Output    string `arg:"" help:"Output directory, or a .tar or .zip file" type:"path"`
+		Bucket    string `help:"Remote bucket of input archive"`
+		Region    string `help:"local GeoJSON Polygon or MultiPolygon file for area of interest" type:"existingfile"`
+		Bbox      string `help:"bbox area of interest: min_lon,min_lat,max_lon,max_lat" type:"string"`
+		Minzoom   int8   `default:"-1" help:"Minimum zoom level, inclusive"`
+		Maxzoom   int8   `default:"-1" help:"Maximum zoom level, inclusive"`
+		PublicURL string `help:"Public base URL of the exported tiles for tiles.json e.g. https://example.com/tiles"`
+	} `cmd:"" help:"Write the tiles of an archive to a {z}/{x}/{y} directory tree, tar or zip file"`
+
 	Merge struct {
-		Archives []string `arg:"" name:"inputs_then_output" help:"One or more disjoint input archives, followed by the output filename."`
-	} `cmd:"" help:"Merge multiple disjoint archives into one: INPUT1.pmtiles INPUT2.pmtiles OUTPUT.pmtiles"`
+		Archives []string `arg:"" name:"inputs_then_output" help:"One or more input archives, followed by the output filename."`
+		Overlap  string   `default:"disjoint" enum:"disjoint,first,last,concat" help:"How to resolve tiles present in more than one input: disjoint (fail), first, last, or concat (combine MVT layers)"`
+	} `cmd:"" help:"Merge multiple archives into one: INPUT1.pmtiles INPUT2.pmtiles OUTPUT.pmtiles"`
 
 	Convert struct {
-		Input           string `arg:"" help:"Input archive" type:"existingfile"`
-		Output          string `arg:"" help:"Output archive" type:"path"`
-		Force           bool   `help:"Force removal"`
-		NoDeduplication bool   `help:"Don't attempt to deduplicate tiles"`
-		Tmpdir          string `help:"An optional path to a folder for temporary files" type:"existingdir"`
-	} `cmd:"" help:"Convert an MBTiles database to PMTiles"`
+		Input               string `arg:"" help:"Input archive, or a directory of {z}/{x}/{y} tiles" type:"path"`
+		Output              string `arg:"" help:"Output archive" type:"path"`
+		Force               bool   `help:"Force removal"`
+		NoDeduplication     bool   `help:"Don't attempt to deduplicate tiles"`
+		Tmpdir              string `help:"An optional path to a folder for temporary files" type:"existingdir"`
+		TileCompression     string `help:"Compression of vector tiles: none, gzip, br or zstd. Defaults to gzip"`
+		InternalCompression string `help:"Directory and metadata compression: none, gzip, br or zstd. Defaults to gzip"`
+	} `cmd:"" help:"Convert an MBTiles database to PMTiles, or a PMTiles archive to MBTiles"`
 
 	Verify struct {
-		Input string `arg:"" help:"Input archive" type:"existingfile"`
-	} `cmd:"" help:"Verify the correctness of an archive structure, without verifying individual tile contents"`
+		Input  string `arg:"" help:"Input archive, a local file, URL or key in --bucket"`
+		Bucket string `help:"Remote bucket of input archive"`
+		Deep   bool   `help:"Also fetch and decode every tile content"`
+		Json   bool   `help:"Print a JSON report of every issue found"`
+		FailOn string `default:"error" enum:"error,warning,none" help:"Exit with a nonzero code if any issue of this severity or worse is found (error, warning or none)"`
+	} `cmd:"" help:"Verify the correctness of an archive structure, and optionally its tile contents"`
 
 	Makesync struct {
 		Input       string `arg:"" type:"existingfile"`
@@ -101,16 +152,33 @@ var cli struct {
 	} `cmd:"" help:"Sync a local file with a remote one by only downloading changed parts" hidden:""`
 
 	Serve struct {
-		Path      string `arg:"" help:"Local path or bucket prefix"`
-		Interface string `default:"0.0.0.0"`
-		Port      int    `default:"8080"`
-		AdminPort int    `default:"-1"`
-		Cors      string `help:"Comma-separated list of of allowed HTTP CORS origins"`
-		CacheSize int    `default:"64" help:"Size of cache in megabytes"`
-		Bucket    string `help:"Remote bucket"`
-		PublicURL string `help:"Public base URL of tile endpoint for TileJSON e.g. https://example.com/tiles/"`
+		Path            string            `arg:"" optional:"" help:"Local path or bucket prefix"`
+		Interface       string            `default:"0.0.0.0"`
+		Port            int               `default:"8080"`
+		AdminPort       int               `default:"-1"`
+		Cors            string            `help:"Comma-separated list of of allowed HTTP CORS origins"`
+		CacheSize       int               `default:"64" help:"Size of cache in megabytes"`
+		CacheControl    map[string]string `help:"Cache-Control header by handler, or default for all others e.g. tile=public, max-age=86400;tilejson=public, max-age=300"`
+		Bucket          string            `help:"Remote bucket"`
+		PublicURL       string            `help:"Public base URL of tile endpoint for TileJSON e.g. https://example.com/tiles/"`
+		Composite       map[string]string `help:"Serve a virtual vector archive stacking the layers of other archives e.g. basemap=base,buildings,pois"`
+		Overzoom        uint8             `help:"Serve MVT, PNG and JPEG tiles this many zoom levels beyond each archive's maxzoom, at most 12"`
+		TranscodeRaster int               `help:"Transcode raster tiles to the PNG or JPEG format of the requested extension, caching up to this many transcoded tiles; 0 disables"`
+		TileCacheSize   int               `default:"0" help:"Size of tile cache in megabytes; 0 disables"`
+		TileCacheDir    string            `help:"Store the tile cache in this directory instead of memory" type:"path"`
+		Viewer          bool              `help:"Serve a map viewer for each archive at /{name}/viewer; requires --public-url"`
+		Watch           time.Duration     `help:"Poll a local directory of archives for changes at this interval e.g. 5s; 0 disables"`
+		Prewarm         bool              `help:"Fetch the root directories of watched archives before they are requested"`
+		Aliases         string            `help:"JSON file mapping logical archive names to archive keys, reloaded on SIGHUP" type:"existingfile"`
+		Config          string            `help:"YAML, JSON or TOML file of serve settings and per-archive options, overriding flags" type:"existingfile"`
 	} `cmd:"" help:"Run an HTTP proxy server for Z/X/Y tiles"`
 
+	Sign struct {
+		Name   string        `arg:"" help:"Archive name, as in tile URLs"`
+		Config string        `required:"" help:"Serve config file with auth.signing_key" type:"existingfile"`
+		Expiry time.Duration `default:"24h" help:"How long the signed URL is valid for"`
+	} `cmd:"" help:"Print query parameters signing URLs of an archive for pmtiles serve"`
+
 	Upload struct {
 		InputPmtiles   string `arg:"" type:"existingfile" help:"The local PMTiles file"`
 		RemotePmtiles  string `arg:""  help:"The name for the remote PMTiles source"`
@@ -144,16 +212,80 @@ func main() {
 		if err != nil {
 			logger.Fatalf("Failed to show tile, %v", err)
 		}
-	case "serve <path>":
-		server, err := pmtiles.NewServer(cli.Serve.Bucket, cli.Serve.Path, logger, cli.Serve.CacheSize, cli.Serve.PublicURL)
+	case "serve <path>", "serve":
+		config := serveConfig{
+			Path:      cli.Serve.Path,
+			Interface: cli.Serve.Interface,
+			Port:      cli.Serve.Port,
+			AdminPort: cli.Serve.AdminPort,
+			ServeConfig: pmtiles.ServeConfig{
+				Bucket:          cli.Serve.Bucket,
+				CacheSize:       cli.Serve.CacheSize,
+				PublicURL:       cli.Serve.PublicURL,
+				Overzoom:        cli.Serve.Overzoom,
+				TranscodeRaster: cli.Serve.TranscodeRaster,
+				TileCacheSize:   cli.Serve.TileCacheSize,
+				TileCacheDir:    cli.Serve.TileCacheDir,
+				Viewer:          cli.Serve.Viewer,
+				WatchInterval:   pmtiles.Duration(cli.Serve.Watch),
+				Prewarm:         cli.Serve.Prewarm,
+				AliasesFile:     cli.Serve.Aliases,
+			},
+		}
+		if cli.Serve.Cors != "" {
+			config.Cors = strings.Split(cli.Serve.Cors, ",")
+		}
+		if len(cli.Serve.CacheControl) > 0 {
+			config.CacheControl = pmtiles.CacheControlPolicy(cli.Serve.CacheControl)
+		}
+		if len(cli.Serve.Composite) > 0 {
+			config.Composites = make(map[string][]string)
+			for name, archives := range cli.Serve.Composite {
+				config.Composites[name] = strings.Split(archives, ",")
+			}
+		}
+		if cli.Serve.Config != "" {
+			if err := pmtiles.LoadConfig(cli.Serve.Config, &config); err != nil {
+				logger.Fatalf("Failed to load config, %v", err)
+			}
+		}
+		if config.Path == "" && config.Bucket == "" {
+			logger.Fatalf("Failed to load config, a path or bucket is required")
+		}
+		if err := config.Validate(); err != nil {
+			logger.Fatalf("Invalid config, %v", err)
+		}
 
+		server, err := pmtiles.NewServerFromConfig(config.ServeConfig, config.Path, logger)
 		if err != nil {
 			logger.Fatalf("Failed to create new server, %v", err)
 		}
 
+		if config.AliasesFile != "" {
+			go func() {
+				hup := make(chan os.Signal, 1)
+				signal.Notify(hup, syscall.SIGHUP)
+				for range hup {
+					aliases, err := pmtiles.LoadAliases(config.AliasesFile)
+					if err != nil {
+						logger.Printf("Failed to reload aliases, keeping previous aliases, %v", err)
+						continue
+					}
+					server.SetAliases(aliases)
+					logger.Printf("Reloaded %d aliases from %s", len(aliases), config.AliasesFile)
+				}
+			}()
+		}
+
 		pmtiles.SetBuildInfo(version, commit, date)
 		server.Start()
 
+		if config.WatchInterval > 0 {
+			if err := server.Watch(context.Background(), time.Duration(config.WatchInterval), config.Prewarm); err != nil {
+				logger.Fatalf("Failed to watch for changes, %v", err)
+			}
+		}
+
 		mux := http.NewServeMux()
 
 		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -162,33 +294,57 @@ func main() {
 			logger.Printf("served %d %s in %s", statusCode, url.PathEscape(r.URL.Path), time.Since(start))
 		})
 
-		logger.Printf("Serving %s %s on port %d and interface %s with Access-Control-Allow-Origin: %s\n", cli.Serve.Bucket, cli.Serve.Path, cli.Serve.Port, cli.Serve.Interface, cli.Serve.Cors)
-		if cli.Serve.AdminPort > 0 {
+		logger.Printf("Serving %s %s on port %d and interface %s with Access-Control-Allow-Origin: %s\n", config.Bucket, config.Path, config.Port, config.Interface, strings.Join(config.Cors, ","))
+		if config.AdminPort > 0 {
 			go func() {
-				adminPort := strconv.Itoa(cli.Serve.AdminPort)
-				logger.Printf("Serving /metrics on port %s and interface %s\n", adminPort, cli.Serve.Interface)
+				adminPort := strconv.Itoa(config.AdminPort)
+				logger.Printf("Serving /metrics on port %s and interface %s\n", adminPort, config.Interface)
 				adminMux := http.NewServeMux()
 				adminMux.Handle("/metrics", promhttp.Handler())
-				logger.Fatal(startHTTPServer(cli.Serve.Interface+":"+adminPort, adminMux))
+				logger.Fatal(startHTTPServer(config.Interface+":"+adminPort, adminMux))
 			}()
 		}
 
-		if cli.Serve.Cors != "" {
-			muxWithCors := pmtiles.NewCors(cli.Serve.Cors).Handler(mux)
-			logger.Fatal(startHTTPServer(cli.Serve.Interface+":"+strconv.Itoa(cli.Serve.Port), muxWithCors))
+		if len(config.Cors) > 0 {
+			muxWithCors := pmtiles.NewCors(strings.Join(config.Cors, ",")).Handler(mux)
+			logger.Fatal(startHTTPServer(config.Interface+":"+strconv.Itoa(config.Port), muxWithCors))
 		} else {
-			logger.Fatal(startHTTPServer(cli.Serve.Interface+":"+strconv.Itoa(cli.Serve.Port), mux))
+			logger.Fatal(startHTTPServer(config.Interface+":"+strconv.Itoa(config.Port), mux))
+		}
+	case "sign <name>":
+		var config serveConfig
+		if err := pmtiles.LoadConfig(cli.Sign.Config, &config); err != nil {
+			logger.Fatalf("Failed to load config, %v", err)
 		}
+		if config.Auth == nil || config.Auth.SigningKey == "" {
+			logger.Fatalf("Failed to sign, config has no auth.signing_key")
+		}
+		fmt.Println(pmtiles.SignArchive(config.Auth.SigningKey, cli.Sign.Name, time.Now().Add(cli.Sign.Expiry)))
 	case "extract <input> <output>":
 		err := pmtiles.Extract(context.Background(), logger, cli.Extract.Bucket, cli.Extract.Input, cli.Extract.Minzoom, cli.Extract.Maxzoom, cli.Extract.Region, cli.Extract.Bbox, cli.Extract.Output, cli.Extract.DownloadThreads, cli.Extract.Overfetch, cli.Extract.DryRun)
 		if err != nil {
 			logger.Fatalf("Failed to extract, %v", err)
 		}
+	case "subtract <input> <output>":
+		err := pmtiles.Subtract(logger, cli.Subtract.Bucket, cli.Subtract.Input, cli.Subtract.Output, cli.Subtract.Minzoom, cli.Subtract.Maxzoom, cli.Subtract.Region, cli.Subtract.Bbox)
+		if err != nil {
+			logger.Fatalf("Failed to subtract, %v", err)
+		}
+	case "export-dir <input> <output>":
+		err := pmtiles.ExportDir(logger, cli.ExportDir.Bucket, cli.ExportDir.Input, cli.ExportDir.Output, cli.ExportDir.Minzoom, cli.ExportDir.Maxzoom, cli.ExportDir.Region, cli.ExportDir.Bbox, cli.ExportDir.PublicURL)
+		if err != nil {
+			logger.Fatalf("Failed to export, %v", err)
+		}
 	case "cluster <input>":
-		err := pmtiles.Cluster(logger, cli.Cluster.Input, !cli.Cluster.NoDeduplication)
+		err := pmtiles.ClusterWithCompression(logger, cli.Cluster.Input, !cli.Cluster.NoDeduplication, cli.Cluster.TileCompression, cli.Cluster.InternalCompression)
 		if err != nil {
 			logger.Fatalf("Failed to cluster, %v", err)
 		}
+	case "recompress <input> <output>":
+		err := pmtiles.Recompress(logger, cli.Recompress.Input, cli.Recompress.Output, cli.Recompress.TileCompression, cli.Recompress.InternalCompression, cli.Recompress.Threads)
+		if err != nil {
+			logger.Fatalf("Failed to recompress, %v", err)
+		}
 	case "convert <input> <output>":
 		path := cli.Convert.Input
 		output := cli.Convert.Output
@@ -217,13 +373,23 @@ func main() {
 		}
 
 		defer os.Remove(tmpfile.Name())
-		err := pmtiles.Convert(logger, path, output, !cli.Convert.NoDeduplication, tmpfile)
+		err := pmtiles.ConvertWithCompression(logger, path, output, !cli.Convert.NoDeduplication, tmpfile, cli.Convert.TileCompression, cli.Convert.InternalCompression)
 
 		if err != nil {
 			logger.Fatalf("Failed to convert %s, %v", path, err)
 		}
 	case "merge <inputs_then_output>":
-		err := pmtiles.Merge(logger, cli.Merge.Archives)
+		var err error
+		switch cli.Merge.Overlap {
+		case "first":
+			err = pmtiles.MergeWithStrategy(logger, cli.Merge.Archives, pmtiles.MergeFirstWins, nil)
+		case "last":
+			err = pmtiles.MergeWithStrategy(logger, cli.Merge.Archives, pmtiles.MergeLastWins, nil)
+		case "concat":
+			err = pmtiles.MergeWithStrategy(logger, cli.Merge.Archives, pmtiles.MergeConcatMvtLayers, nil)
+		default:
+			err = pmtiles.Merge(logger, cli.Merge.Archives)
+		}
 		if err != nil {
 			logger.Fatalf("Failed to merge, %v", err)
 		}
@@ -234,10 +400,26 @@ func main() {
 			logger.Fatalf("Failed to upload file, %v", err)
 		}
 	case "verify <input>":
-		err := pmtiles.Verify(logger, cli.Verify.Input)
+		if cli.Verify.Json && !cli.Quiet {
+			logger.SetOutput(os.Stderr)
+		}
+		report, err := pmtiles.VerifyArchive(logger, cli.Verify.Bucket, cli.Verify.Input, cli.Verify.Deep)
 		if err != nil {
 			logger.Fatalf("Failed to verify archive, %v", err)
 		}
+		if cli.Verify.Json {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(report); err != nil {
+				logger.Fatalf("Failed to write report, %v", err)
+			}
+		}
+		if report.Failed(cli.Verify.FailOn) {
+			if !cli.Verify.Json {
+				logger.Printf("Failed to verify archive, %d errors and %d warnings", report.Errors, report.Warnings)
+			}
+			os.Exit(1)
+		}
 	case "edit <input>":
 		err := pmtiles.Edit(logger, cli.Edit.Input, cli.Edit.HeaderJson, cli.Edit.Metadata)
 		if err != nil {
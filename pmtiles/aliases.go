@@ -0,0 +1,61 @@
+package pmtiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadAliases reads a JSON object mapping logical archive names to concrete archive keys from path,
+// e.g. {"basemap": "basemap-2026-10-08.pmtiles"}. The .pmtiles extension of keys is optional.
+func LoadAliases(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read aliases, %w", err)
+	}
+	var aliases map[string]string
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("Failed to parse aliases, %w", err)
+	}
+	if err := normalizeAliases(aliases); err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// normalizeAliases strips the .pmtiles extension of alias keys and rejects empty names.
+func normalizeAliases(aliases map[string]string) error {
+	for name, key := range aliases {
+		key = strings.TrimSuffix(key, ".pmtiles")
+		if name == "" || key == "" {
+			return fmt.Errorf("Invalid alias %q to %q", name, key)
+		}
+		aliases[name] = key
+	}
+	return nil
+}
+
+// SetAliases atomically replaces the logical archive names resolved to concrete archives,
+// and may be called while the server is running. TileJSON for an alias lists the URLs of the concrete archive.
+func (server *Server) SetAliases(aliases map[string]string) {
+	server.aliases.Store(&aliases)
+}
+
+func (server *Server) resolveAlias(name string) string {
+	if aliases := server.aliases.Load(); aliases != nil {
+		if key, ok := (*aliases)[name]; ok {
+			return key
+		}
+	}
+	return name
+}
+
+// resolveAliases resolves the alias of each name, such as the archives of a composite.
+func (server *Server) resolveAliases(names []string) []string {
+	resolved := make([]string, len(names))
+	for i, name := range names {
+		resolved[i] = server.resolveAlias(name)
+	}
+	return resolved
+}
@@ -0,0 +1,66 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"basemap": "basemap-2026-10-08.pmtiles", "terrain": "terrain-v2"}`), 0644))
+	aliases, err := LoadAliases(path)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"basemap": "basemap-2026-10-08", "terrain": "terrain-v2"}, aliases)
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"basemap": ""}`), 0644))
+	_, err = LoadAliases(path)
+	assert.NotNil(t, err)
+	assert.Nil(t, os.WriteFile(path, []byte(`["basemap"]`), 0644))
+	_, err = LoadAliases(path)
+	assert.NotNil(t, err)
+}
+
+func TestAliases(t *testing.T) {
+	mockBucket, server := newServer(t)
+	header := HeaderV3{TileType: Mvt}
+	mockBucket.items["basemap-1.pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {0, 1},
+	}, false, Gzip)
+	mockBucket.items["basemap-2.pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {2, 3},
+	}, false, Gzip)
+
+	statusCode, _, _ := server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, 404, statusCode)
+
+	server.SetAliases(map[string]string{"basemap": "basemap-1"})
+	statusCode, _, data := server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{0, 1}, data)
+	statusCode, _, data = server.Get(context.Background(), "/basemap.json")
+	assert.Equal(t, 200, statusCode)
+	var tilejson map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &tilejson))
+	assert.Equal(t, []interface{}{"tiles.example.com/basemap-1/{z}/{x}/{y}.mvt"}, tilejson["tiles"])
+
+	// roll forward
+	server.SetAliases(map[string]string{"basemap": "basemap-2"})
+	statusCode, _, data = server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{2, 3}, data)
+	_, headers, data := server.Get(context.Background(), "/basemap.json")
+	assert.Nil(t, json.Unmarshal(data, &tilejson))
+	assert.Equal(t, []interface{}{"tiles.example.com/basemap-2/{z}/{x}/{y}.mvt"}, tilejson["tiles"])
+	_, concreteHeaders, _ := server.Get(context.Background(), "/basemap-2.json")
+	assert.Equal(t, concreteHeaders["ETag"], headers["ETag"])
+
+	// concrete archives remain available
+	statusCode, _, data = server.Get(context.Background(), "/basemap-1/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{0, 1}, data)
+}
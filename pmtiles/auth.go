@@ -0,0 +1,178 @@
+package pmtiles
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetAuth requires requests for all archives to carry an API key, as the key query parameter or a bearer token,
+// or a URL signed with signingKey by SignArchive. Either may be empty. The tile URLs of TileJSON are signed to be
+// valid for at least signedURLTTL, or carry the API key of the request if there is no signing key.
+// Archives with API keys in their config require credentials even without SetAuth.
+func (server *Server) SetAuth(signingKey string, apiKeys []string, signedURLTTL time.Duration) {
+	server.auth = true
+	server.signingKey = []byte(signingKey)
+	server.apiKeys = apiKeys
+	server.signedURLTTL = signedURLTTL
+}
+
+// SignArchive returns the query parameters authorizing requests for the archive name until expires.
+func SignArchive(signingKey string, name string, expires time.Time) string {
+	return signArchive([]byte(signingKey), name, expires.Unix())
+}
+
+func signArchive(signingKey []byte, name string, expires int64) string {
+	return "expires=" + strconv.FormatInt(expires, 10) + "&signature=" + archiveSignature(signingKey, name, expires)
+}
+
+func archiveSignature(signingKey []byte, name string, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(name + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func containsKey(keys []string, key string) bool {
+	found := false
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// archiveKeys returns the API keys of each config protecting an archive: its own and, for a composite,
+// those of its archives. A key must be in all of them to access the archive.
+func (server *Server) archiveKeys(name string) [][]string {
+	var keys [][]string
+	for _, n := range append([]string{name}, server.resolveAliases(server.composites[name])...) {
+		if config, _ := server.archiveConfig(n); len(config.APIKeys) > 0 {
+			keys = append(keys, config.APIKeys)
+		}
+	}
+	return keys
+}
+
+func containsKeyForAll(keys [][]string, key string) bool {
+	for _, k := range keys {
+		if !containsKey(k, key) {
+			return false
+		}
+	}
+	return len(keys) > 0
+}
+
+// pathArchive returns the archive name in a request path as requested and after resolving aliases,
+// which are empty for paths listing all archives, and false for the root path and paths that are not clean,
+// which are not served.
+func (server *Server) pathArchive(path string) (string, string, bool) {
+	if !isCleanPath(path) {
+		return "", "", false
+	}
+	var key string
+	if ok, k, _, _, _, _ := parseTilePath(path); ok {
+		key = k
+	} else if ok, k := parseTilejsonPath(path); ok {
+		key = k
+	} else if ok, k := parseMetadataPath(path); ok {
+		key = k
+	} else if ok, k := parseViewerPath(path); ok {
+		key = k
+	} else if ok, k := parseWMTSCapabilitiesPath(path); ok {
+		key = k
+	} else if res := ogcCollectionPattern.FindStringSubmatch(path); res != nil {
+		key = res[1]
+	} else if path == "/" {
+		return "", "", false
+	}
+	if key == "" {
+		return "", "", true
+	}
+	return key, server.resolveAlias(key), true
+}
+
+// tileAuth is how URLs handed out in a response are authorized: signed for each archive
+// to be valid until expires, or carrying an API key. The zero value adds nothing.
+// Responses to requests that needed credentials are private, so shared caches do not store them.
+type tileAuth struct {
+	expires int64
+	key     string
+	private bool
+}
+
+// tileQuery returns the query to add to the URLs of the archive name, if any.
+func (server *Server) tileQuery(auth tileAuth, name string) string {
+	if auth.expires > 0 {
+		return signArchive(server.signingKey, name, auth.expires)
+	}
+	if auth.key != "" {
+		return "key=" + url.QueryEscape(auth.key)
+	}
+	return ""
+}
+
+// withQuery returns rawURL with query added, if any.
+func withQuery(rawURL string, query string) string {
+	if query == "" {
+		return rawURL
+	}
+	return rawURL + "?" + query
+}
+
+// authorize checks the credentials of a request before it is served, returning 401 without credentials
+// and 403 with invalid ones. It also returns how to authorize the tile URLs in TileJSON, OGC and WMTS responses.
+// Paths listing all archives accept only the API keys of SetAuth, and composites the keys of all their archives.
+func (server *Server) authorize(r *http.Request, now time.Time) (int, tileAuth) {
+	key, name, ok := server.pathArchive(r.URL.Path)
+	if !ok {
+		return 200, tileAuth{}
+	}
+	archiveKeys := server.archiveKeys(name)
+	if !server.auth && len(archiveKeys) == 0 {
+		return 200, tileAuth{}
+	}
+
+	query := r.URL.Query()
+	apiKey := query.Get("key")
+	keyInQuery := apiKey != ""
+	if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found && !keyInQuery {
+		apiKey = bearer
+	}
+	signature := query.Get("signature")
+	if apiKey == "" && signature == "" {
+		return 401, tileAuth{}
+	}
+
+	if apiKey != "" && (containsKey(server.apiKeys, apiKey) || containsKeyForAll(archiveKeys, apiKey)) {
+		if len(server.signingKey) > 0 {
+			ttl := server.signedURLTTL
+			if ttl <= 0 {
+				ttl = time.Hour
+			}
+			// round the expiry so TileJSON stays the same for a while
+			return 200, tileAuth{expires: now.Truncate(ttl).Add(2 * ttl).Unix(), private: true}
+		}
+		if keyInQuery {
+			return 200, tileAuth{key: apiKey, private: true}
+		}
+		return 200, tileAuth{private: true}
+	}
+
+	if signature != "" && len(server.signingKey) > 0 && name != "" {
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if err == nil && now.Unix() < expires {
+			if hmac.Equal([]byte(signature), []byte(archiveSignature(server.signingKey, key, expires))) ||
+				hmac.Equal([]byte(signature), []byte(archiveSignature(server.signingKey, name, expires))) {
+				return 200, tileAuth{expires: expires, private: true}
+			}
+		}
+	}
+	return 403, tileAuth{}
+}
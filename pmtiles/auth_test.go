@@ -0,0 +1,239 @@
+package pmtiles
+
+import (
+	"encoding/json"
+	"log"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAuthServer serves archives from a directory, whose bucket cleans the paths of archive names.
+func newAuthServer(t *testing.T) *Server {
+	dir := t.TempDir()
+	header := HeaderV3{TileType: Mvt}
+	for _, name := range []string{"basemap", "private"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name+".pmtiles"), fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+			{0, 0, 0}: {0, 1},
+		}, false, Gzip), 0644))
+	}
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	server, err := NewServerWithBucket(NewFileBucket(dir), "", log.Default(), 10, "tiles.example.com")
+	assert.Nil(t, err)
+	server.Start()
+	server.SetArchiveConfigs([]ArchiveConfig{{Name: "private", APIKeys: []string{"archive-key"}}})
+	return server
+}
+
+func authRequest(server *Server, target string, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	return res
+}
+
+func tilejsonTiles(t *testing.T, res *httptest.ResponseRecorder) string {
+	var tilejson struct {
+		Tiles []string `json:"tiles"`
+	}
+	assert.Nil(t, json.Unmarshal(res.Body.Bytes(), &tilejson))
+	assert.Equal(t, 1, len(tilejson.Tiles))
+	return tilejson.Tiles[0]
+}
+
+func TestArchiveAPIKeys(t *testing.T) {
+	server := newAuthServer(t)
+
+	assert.Equal(t, 200, authRequest(server, "/basemap/0/0/0.mvt", "").Code)
+	res := authRequest(server, "/private/0/0/0.mvt", "")
+	assert.Equal(t, 401, res.Code)
+	assert.Equal(t, "Bearer", res.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, 403, authRequest(server, "/private/0/0/0.mvt?key=wrong", "").Code)
+	assert.Equal(t, 200, authRequest(server, "/private/0/0/0.mvt?key=archive-key", "").Code)
+	assert.Equal(t, 200, authRequest(server, "/private/0/0/0.mvt", "Bearer archive-key").Code)
+	assert.Equal(t, 200, authRequest(server, "/catalog", "").Code)
+
+	// paths naming an archive in a roundabout way are not served
+	for _, path := range []string{"/./private/0/0/0.mvt", "//private/0/0/0.mvt", "/x/../private/0/0/0.mvt", "/./private/metadata", "/./private.json", "/private/./0/0/0.mvt"} {
+		assert.Equal(t, 404, authRequest(server, path, "").Code, path)
+		assert.Equal(t, 404, authRequest(server, path+"?key=archive-key", "").Code, path)
+	}
+
+	// aliases are checked against the keys of their archive
+	server.SetAliases(map[string]string{"current": "private"})
+	assert.Equal(t, 401, authRequest(server, "/current/0/0/0.mvt", "").Code)
+	assert.Equal(t, 200, authRequest(server, "/current/0/0/0.mvt?key=archive-key", "").Code)
+
+	res = authRequest(server, "/private.json?key=archive-key", "")
+	assert.Equal(t, 200, res.Code)
+	assert.Equal(t, "tiles.example.com/private/{z}/{x}/{y}.mvt?key=archive-key", tilejsonTiles(t, res))
+	res = authRequest(server, "/private.json", "Bearer archive-key")
+	assert.Equal(t, 200, res.Code)
+	assert.Equal(t, "tiles.example.com/private/{z}/{x}/{y}.mvt", tilejsonTiles(t, res))
+}
+
+func TestAuthorizedCacheControl(t *testing.T) {
+	server := newAuthServer(t)
+	server.SetCacheControl(CacheControlPolicy{"default": "public, max-age=86400"})
+
+	assert.Equal(t, "public, max-age=86400", authRequest(server, "/basemap/0/0/0.mvt", "").Header().Get("Cache-Control"))
+	res := authRequest(server, "/private/0/0/0.mvt?key=archive-key", "")
+	assert.Equal(t, 200, res.Code)
+	assert.Equal(t, "private", res.Header().Get("Cache-Control"))
+	res = authRequest(server, "/private/metadata", "Bearer archive-key")
+	assert.Equal(t, 200, res.Code)
+	assert.Equal(t, "private", res.Header().Get("Cache-Control"))
+
+	server.SetAuth("0123456789abcdef", nil, time.Hour)
+	expires := time.Now().Add(time.Hour)
+	res = authRequest(server, "/basemap/0/0/0.mvt?"+SignArchive("0123456789abcdef", "basemap", expires), "")
+	assert.Equal(t, 200, res.Code)
+	assert.Equal(t, "private", res.Header().Get("Cache-Control"))
+}
+
+func TestCompositeAndListingAPIKeys(t *testing.T) {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	bucket := mockBucket{make(map[string][]byte)}
+	for _, name := range []string{"basemap", "private"} {
+		bucket.items[name+".pmtiles"] = fakeArchive(HeaderV3{TileType: Mvt}, map[string]interface{}{}, map[Zxy][]byte{
+			{0, 0, 0}: {0, 1},
+		}, false, Gzip)
+	}
+	server, err := NewServerWithBucket(bucket, "", log.Default(), 10, "tiles.example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, server.SetComposite("combined", []string{"basemap", "private"}))
+	server.SetArchiveConfigs([]ArchiveConfig{
+		{Name: "private", APIKeys: []string{"archive-key"}},
+		{Name: "combined", APIKeys: []string{"combined-key", "archive-key"}},
+	})
+	server.Start()
+
+	// the keys of every archive in a composite are checked
+	assert.Equal(t, 401, authRequest(server, "/combined/metadata", "").Code)
+	assert.Equal(t, 403, authRequest(server, "/combined/metadata?key=combined-key", "").Code)
+	assert.Equal(t, 200, authRequest(server, "/combined/metadata?key=archive-key", "").Code)
+
+	// public listings leave out archives requiring keys
+	res := authRequest(server, "/catalog", "")
+	assert.Equal(t, 200, res.Code)
+	var catalog Catalog
+	assert.Nil(t, json.Unmarshal(res.Body.Bytes(), &catalog))
+	assert.Equal(t, 1, len(catalog.Archives))
+	assert.Equal(t, "basemap", catalog.Archives[0].Name)
+	res = authRequest(server, "/WMTSCapabilities.xml", "")
+	assert.Equal(t, 200, res.Code)
+	assert.NotContains(t, res.Body.String(), "private")
+	assert.NotContains(t, res.Body.String(), "combined")
+	res = authRequest(server, "/ogc/collections", "")
+	assert.Equal(t, 200, res.Code)
+	assert.NotContains(t, res.Body.String(), "private")
+
+	// listings requiring server keys include every archive
+	server.SetAuth("", []string{"server-key"}, time.Hour)
+	res = authRequest(server, "/catalog", "Bearer server-key")
+	assert.Equal(t, 200, res.Code)
+	assert.Nil(t, json.Unmarshal(res.Body.Bytes(), &catalog))
+	assert.Equal(t, 3, len(catalog.Archives))
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	server := newAuthServer(t)
+	server.SetAuth("", []string{"server-key"}, time.Hour)
+
+	assert.Equal(t, 204, authRequest(server, "/", "").Code)
+	assert.Equal(t, 401, authRequest(server, "/basemap/0/0/0.mvt", "").Code)
+	assert.Equal(t, 200, authRequest(server, "/basemap/0/0/0.mvt?key=server-key", "").Code)
+	assert.Equal(t, 200, authRequest(server, "/private/0/0/0.mvt?key=server-key", "").Code)
+	assert.Equal(t, 403, authRequest(server, "/basemap/0/0/0.mvt?key=archive-key", "").Code)
+	assert.Equal(t, 200, authRequest(server, "/private/0/0/0.mvt?key=archive-key", "").Code)
+
+	assert.Equal(t, 401, authRequest(server, "/catalog", "").Code)
+	assert.Equal(t, 403, authRequest(server, "/catalog?key=archive-key", "").Code)
+	assert.Equal(t, 200, authRequest(server, "/catalog", "Bearer server-key").Code)
+	assert.Equal(t, 401, authRequest(server, "/ogc/collections/basemap", "").Code)
+}
+
+func TestSignedURLs(t *testing.T) {
+	server := newAuthServer(t)
+	signingKey := "0123456789abcdef"
+	server.SetAuth(signingKey, []string{"server-key"}, time.Hour)
+	server.SetAliases(map[string]string{"current": "basemap"})
+	expires := time.Now().Add(time.Minute)
+
+	assert.Equal(t, 200, authRequest(server, "/basemap/0/0/0.mvt?"+SignArchive(signingKey, "basemap", expires), "").Code)
+	assert.Equal(t, 200, authRequest(server, "/current/0/0/0.mvt?"+SignArchive(signingKey, "current", expires), "").Code)
+	assert.Equal(t, 403, authRequest(server, "/private/0/0/0.mvt?"+SignArchive(signingKey, "basemap", expires), "").Code)
+	assert.Equal(t, 403, authRequest(server, "/basemap/0/0/0.mvt?"+SignArchive("fedcba9876543210", "basemap", expires), "").Code)
+	assert.Equal(t, 403, authRequest(server, "/basemap/0/0/0.mvt?"+SignArchive(signingKey, "basemap", time.Now().Add(-time.Minute)), "").Code)
+	tampered := strings.Replace(SignArchive(signingKey, "basemap", expires), "expires=", "expires=1", 1)
+	assert.Equal(t, 403, authRequest(server, "/basemap/0/0/0.mvt?"+tampered, "").Code)
+
+	// TileJSON of an alias is signed for the concrete archive with the same expiry
+	res := authRequest(server, "/current.json?"+SignArchive(signingKey, "current", expires), "")
+	assert.Equal(t, 200, res.Code)
+	tiles := tilejsonTiles(t, res)
+	assert.Equal(t, "tiles.example.com/basemap/{z}/{x}/{y}.mvt?"+SignArchive(signingKey, "basemap", expires), tiles)
+	tileURL, err := url.Parse(strings.Replace(tiles, "{z}/{x}/{y}", "0/0/0", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, authRequest(server, "/basemap/0/0/0.mvt?"+tileURL.RawQuery, "").Code)
+
+	// TileJSON requested with an API key is signed instead of carrying the key
+	res = authRequest(server, "/basemap.json?key=server-key", "")
+	assert.Equal(t, 200, res.Code)
+	tileURL, err = url.Parse(strings.Replace(tilejsonTiles(t, res), "{z}/{x}/{y}", "0/0/0", 1))
+	assert.Nil(t, err)
+	assert.Equal(t, "", tileURL.Query().Get("key"))
+	assert.Equal(t, 200, authRequest(server, "/basemap/0/0/0.mvt?"+tileURL.RawQuery, "").Code)
+	signedExpires, err := strconv.ParseInt(tileURL.Query().Get("expires"), 10, 64)
+	assert.Nil(t, err)
+	validFor := time.Until(time.Unix(signedExpires, 0))
+	assert.True(t, validFor > 59*time.Minute && validFor <= 2*time.Hour)
+}
+
+func TestOgcAndWMTSTileCredentials(t *testing.T) {
+	server := newAuthServer(t)
+	server.SetArchiveConfigs([]ArchiveConfig{{Name: "private", APIKeys: []string{"archive-key"}, PublicURL: "https://cdn.example.com/tiles"}})
+
+	res := authRequest(server, "/ogc/collections/private/tiles/WebMercatorQuad?key=archive-key", "")
+	assert.Equal(t, 200, res.Code)
+	var tileset struct {
+		Links []ogcLink `json:"links"`
+	}
+	assert.Nil(t, json.Unmarshal(res.Body.Bytes(), &tileset))
+	var item string
+	for _, link := range tileset.Links {
+		if link.Rel == "item" {
+			item = link.Href
+		}
+	}
+	assert.Equal(t, "https://cdn.example.com/tiles/ogc/collections/private/tiles/WebMercatorQuad/{tileMatrix}/{tileRow}/{tileCol}?key=archive-key", item)
+
+	res = authRequest(server, "/private/WMTSCapabilities.xml?key=archive-key", "")
+	assert.Equal(t, 200, res.Code)
+	assert.Contains(t, res.Body.String(), `template="https://cdn.example.com/tiles/private/{TileMatrix}/{TileCol}/{TileRow}.mvt?key=archive-key"`)
+
+	signingKey := "0123456789abcdef"
+	server.SetAuth(signingKey, []string{"server-key"}, time.Hour)
+	expires := time.Now().Add(time.Minute)
+	res = authRequest(server, "/private/WMTSCapabilities.xml?"+SignArchive(signingKey, "private", expires), "")
+	assert.Equal(t, 200, res.Code)
+	assert.Contains(t, res.Body.String(), ".mvt?"+strings.ReplaceAll(SignArchive(signingKey, "private", expires), "&", "&amp;")+`"`)
+
+	// listings sign the tiles of each archive
+	res = authRequest(server, "/WMTSCapabilities.xml", "Bearer server-key")
+	assert.Equal(t, 200, res.Code)
+	assert.Contains(t, res.Body.String(), "tiles.example.com/basemap/{TileMatrix}/{TileCol}/{TileRow}.mvt?expires=")
+	assert.Contains(t, res.Body.String(), "https://cdn.example.com/tiles/private/{TileMatrix}/{TileCol}/{TileRow}.mvt?expires=")
+}
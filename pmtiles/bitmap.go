@@ -39,6 +39,14 @@ func bitmapMultiPolygon(zoom uint8, multipolygon orb.MultiPolygon) (*roaring64.B
 	return boundarySet, interiorSet
 }
 
+// regionBitmap returns the set of tiles from minzoom to maxzoom covering the multipolygon.
+func regionBitmap(multipolygon orb.MultiPolygon, minzoom uint8, maxzoom uint8) *roaring64.Bitmap {
+	boundarySet, interiorSet := bitmapMultiPolygon(maxzoom, multipolygon)
+	boundarySet.Or(interiorSet)
+	generalizeOr(boundarySet, minzoom)
+	return boundarySet
+}
+
 func generalizeOr(r *roaring64.Bitmap, minzoom uint8) {
 	if r.GetCardinality() == 0 {
 		return
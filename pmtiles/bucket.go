@@ -8,13 +8,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
@@ -33,8 +36,37 @@ type Bucket interface {
 	Close() error
 	NewRangeReader(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
 	NewRangeReaderEtag(ctx context.Context, key string, offset int64, length int64, etag string) (io.ReadCloser, string, int, error)
+	// Size returns the total length in bytes of the object at key.
+	Size(ctx context.Context, key string) (int64, error)
+	// List returns the keys of all objects in the bucket, sorted.
+	List(ctx context.Context) ([]string, error)
 }
 
+// modTimeReader is implemented by the readers of buckets that know when the object being read was last modified,
+// including the readers of gocloud buckets.
+type modTimeReader interface {
+	ModTime() time.Time
+}
+
+// readerModTime returns when the object read by r was last modified, or the zero time if unknown.
+func readerModTime(r io.Reader) time.Time {
+	if m, ok := r.(modTimeReader); ok {
+		return m.ModTime()
+	}
+	return time.Time{}
+}
+
+type modTimeReadCloser struct {
+	io.ReadCloser
+	modTime time.Time
+}
+
+func (r modTimeReadCloser) ModTime() time.Time {
+	return r.modTime
+}
+
+var errListUnsupported = errors.New("listing is not supported for HTTP buckets")
+
 // RefreshRequiredError is an error that indicates the etag has chanced on the remote file
 type RefreshRequiredError struct {
 	StatusCode int
@@ -78,6 +110,23 @@ func (m mockBucket) NewRangeReaderEtag(_ context.Context, key string, offset int
 	return io.NopCloser(bytes.NewReader(bs[offset:end])), resultEtag, 206, nil
 }
 
+func (m mockBucket) Size(_ context.Context, key string) (int64, error) {
+	bs, ok := m.items[key]
+	if !ok {
+		return 0, fmt.Errorf("Not found %s", key)
+	}
+	return int64(len(bs)), nil
+}
+
+func (m mockBucket) List(_ context.Context) ([]string, error) {
+	keys := make([]string, 0, len(m.items))
+	for key := range m.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
 // FileBucket is a bucket backed by a directory on disk
 type FileBucket struct {
 	path string
@@ -118,6 +167,11 @@ func generateEtagFromInts(ns ...int64) string {
 	return hasherToEtag(hasher)
 }
 
+// fileEtag is the etag of a file in a FileBucket.
+func fileEtag(info fs.FileInfo) string {
+	return generateEtagFromInts(info.ModTime().UnixNano(), info.Size())
+}
+
 func (b FileBucket) NewRangeReaderEtag(_ context.Context, key string, offset, length int64, etag string) (io.ReadCloser, string, int, error) {
 	name := filepath.Join(b.path, key)
 	file, err := os.Open(name)
@@ -129,7 +183,7 @@ func (b FileBucket) NewRangeReaderEtag(_ context.Context, key string, offset, le
 	if err != nil {
 		return nil, "", 404, err
 	}
-	newEtag := generateEtagFromInts(info.ModTime().UnixNano(), info.Size())
+	newEtag := fileEtag(info)
 	if len(etag) > 0 && etag != newEtag {
 		return nil, "", 412, &RefreshRequiredError{}
 	}
@@ -138,7 +192,7 @@ func (b FileBucket) NewRangeReaderEtag(_ context.Context, key string, offset, le
 
 	if err == io.EOF {
 		part := result[0:read]
-		return io.NopCloser(bytes.NewReader(part)), newEtag, 206, nil
+		return modTimeReadCloser{io.NopCloser(bytes.NewReader(part)), info.ModTime()}, newEtag, 206, nil
 	}
 
 	if err != nil {
@@ -148,7 +202,31 @@ func (b FileBucket) NewRangeReaderEtag(_ context.Context, key string, offset, le
 		return nil, "", 416, fmt.Errorf("Expected to read %d bytes but only read %d", length, read)
 	}
 
-	return io.NopCloser(bytes.NewReader(result)), newEtag, 206, nil
+	return modTimeReadCloser{io.NopCloser(bytes.NewReader(result)), info.ModTime()}, newEtag, 206, nil
+}
+
+func (b FileBucket) Size(_ context.Context, key string) (int64, error) {
+	info, err := os.Stat(filepath.Join(b.path, key))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (b FileBucket) List(_ context.Context) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(b.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(b.path, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	return keys, err
 }
 
 func (b FileBucket) Close() error {
@@ -202,7 +280,34 @@ func (b HTTPBucket) NewRangeReaderEtag(ctx context.Context, key string, offset,
 		return nil, "", resp.StatusCode, err
 	}
 
-	return resp.Body, resp.Header.Get("ETag"), resp.StatusCode, nil
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return modTimeReadCloser{resp.Body, modTime}, resp.Header.Get("ETag"), resp.StatusCode, nil
+}
+
+// Size makes a HEAD request for the Content-Length of key.
+func (b HTTPBucket) Size(ctx context.Context, key string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", b.baseURL+"/"+key, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("HTTP response has no Content-Length")
+	}
+	return resp.ContentLength, nil
+}
+
+func (b HTTPBucket) List(_ context.Context) ([]string, error) {
+	return nil, errListUnsupported
 }
 
 func (b HTTPBucket) Close() error {
@@ -304,6 +409,33 @@ func (ba BucketAdapter) NewRangeReaderEtag(ctx context.Context, key string, offs
 	return reader, getProviderEtag(reader), status, nil
 }
 
+func (ba BucketAdapter) Size(ctx context.Context, key string) (int64, error) {
+	attrs, err := ba.Bucket.Attributes(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+func (ba BucketAdapter) List(ctx context.Context) ([]string, error) {
+	var keys []string
+	iter := ba.Bucket.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !obj.IsDir {
+			keys = append(keys, obj.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
 func (ba BucketAdapter) Close() error {
 	return ba.Bucket.Close()
 }
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
@@ -18,6 +19,7 @@ import (
 	smithyHttp "github.com/aws/smithy-go/transport/http"
 
 	"github.com/stretchr/testify/assert"
+	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/fileblob"
 	"google.golang.org/api/googleapi"
 )
@@ -112,6 +114,29 @@ func TestHttpBucketRequestRequestEtag(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestHttpBucketSize(t *testing.T) {
+	mock := ClientMock{}
+	bucket := HTTPBucket{"http://tiles.example.com/tiles", &mock}
+	mock.response = &http.Response{
+		StatusCode:    200,
+		Body:          io.NopCloser(strings.NewReader("")),
+		ContentLength: 1234,
+	}
+	size, err := bucket.Size(context.Background(), "a/b/c")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1234), size)
+	assert.Equal(t, "HEAD", mock.request.Method)
+	assert.Equal(t, "http://tiles.example.com/tiles/a/b/c", mock.request.URL.String())
+
+	mock.response = &http.Response{
+		StatusCode:    404,
+		Body:          io.NopCloser(strings.NewReader("")),
+		ContentLength: -1,
+	}
+	_, err = bucket.Size(context.Background(), "a/b/c")
+	assert.NotNil(t, err)
+}
+
 func TestHttpBucketRequestRequestEtagFailed(t *testing.T) {
 	mock := ClientMock{}
 	header := http.Header{}
@@ -223,6 +248,88 @@ func TestFileShorterThan16K(t *testing.T) {
 	assert.Equal(t, 3, len(data))
 }
 
+func TestHttpBucketReaderModTime(t *testing.T) {
+	mock := ClientMock{}
+	bucket := HTTPBucket{"http://tiles.example.com/tiles", &mock}
+	mock.response = &http.Response{
+		StatusCode: 206,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Header:     http.Header{"Last-Modified": []string{"Wed, 14 Oct 2026 07:28:00 GMT"}},
+	}
+	r, _, _, err := bucket.NewRangeReaderEtag(context.Background(), "a/b/c", 0, 1, "")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 10, 14, 7, 28, 0, 0, time.UTC), readerModTime(r))
+	assert.Equal(t, "GET", mock.request.Method)
+
+	mock.response = &http.Response{
+		StatusCode: 206,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Header:     http.Header{},
+	}
+	r, _, _, err = bucket.NewRangeReaderEtag(context.Background(), "a/b/c", 0, 1, "")
+	assert.Nil(t, err)
+	assert.True(t, readerModTime(r).IsZero())
+}
+
+func TestFileBucketReaderModTime(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "archive.pmtiles")
+	assert.Nil(t, os.WriteFile(path, []byte{1, 2, 3}, 0666))
+	assert.Nil(t, os.Chtimes(path, time.Unix(1000, 0), time.Unix(1000, 0)))
+	bucket := NewFileBucket(tmp)
+	r, _, _, err := bucket.NewRangeReaderEtag(context.Background(), "archive.pmtiles", 0, 16384, "")
+	assert.Nil(t, err)
+	assert.True(t, time.Unix(1000, 0).Equal(readerModTime(r)))
+}
+
+func TestFileBucketSize(t *testing.T) {
+	tmp := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(tmp, "archive.pmtiles"), []byte{1, 2, 3}, 0666))
+	bucket := NewFileBucket(tmp)
+	size, err := bucket.Size(context.Background(), "archive.pmtiles")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), size)
+	_, err = bucket.Size(context.Background(), "missing.pmtiles")
+	assert.NotNil(t, err)
+}
+
+func TestBucketAdapterSize(t *testing.T) {
+	tmp := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(tmp, "archive.pmtiles"), []byte{1, 2, 3, 4}, 0666))
+	blobBucket, err := blob.OpenBucket(context.Background(), "file://"+filepath.ToSlash(tmp)+"?metadata=skip")
+	assert.Nil(t, err)
+	bucket := BucketAdapter{blobBucket}
+	defer bucket.Close()
+	size, err := bucket.Size(context.Background(), "archive.pmtiles")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), size)
+}
+
+func TestFileBucketList(t *testing.T) {
+	tmp := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(tmp, "nested"), 0777))
+	assert.Nil(t, os.WriteFile(filepath.Join(tmp, "b.pmtiles"), []byte{1}, 0666))
+	assert.Nil(t, os.WriteFile(filepath.Join(tmp, "nested", "a.pmtiles"), []byte{1}, 0666))
+	bucket := NewFileBucket(tmp)
+	keys, err := bucket.List(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b.pmtiles", "nested/a.pmtiles"}, keys)
+}
+
+func TestBucketAdapterList(t *testing.T) {
+	tmp := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(tmp, "nested"), 0777))
+	assert.Nil(t, os.WriteFile(filepath.Join(tmp, "b.pmtiles"), []byte{1}, 0666))
+	assert.Nil(t, os.WriteFile(filepath.Join(tmp, "nested", "a.pmtiles"), []byte{1}, 0666))
+	blobBucket, err := blob.OpenBucket(context.Background(), "file://"+filepath.ToSlash(tmp)+"?metadata=skip")
+	assert.Nil(t, err)
+	bucket := BucketAdapter{blobBucket}
+	defer bucket.Close()
+	keys, err := bucket.List(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b.pmtiles", "nested/a.pmtiles"}, keys)
+}
+
 func TestSetProviderEtagAwsV2(t *testing.T) {
 	var awsV2Req s3.GetObjectInput
 	assert.Nil(t, awsV2Req.IfMatch)
@@ -0,0 +1,81 @@
+package pmtiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// cacheControlHandlers are the keys of a CacheControlPolicy.
+var cacheControlHandlers = map[string]bool{
+	"default":  true,
+	"tile":     true,
+	"tilejson": true,
+	"metadata": true,
+	"viewer":   true,
+	"wmts":     true,
+	"ogc":      true,
+	"catalog":  true,
+}
+
+// CacheControlPolicy maps handler names (tile, tilejson, metadata, viewer, wmts, ogc or catalog) to the
+// Cache-Control header of their successful responses. The "default" value applies to handlers without a value.
+// It can be read from a JSON string, which sets the default, or an object.
+type CacheControlPolicy map[string]string
+
+func (p *CacheControlPolicy) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err == nil {
+		*p = CacheControlPolicy{"default": value}
+		return nil
+	}
+	var values map[string]string
+	if err := json.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("cache_control must be a string or an object of handlers, %w", err)
+	}
+	*p = values
+	return nil
+}
+
+// Validate checks that the keys of a CacheControlPolicy are handler names.
+func (p CacheControlPolicy) Validate() error {
+	for handler := range p {
+		if !cacheControlHandlers[handler] {
+			return fmt.Errorf("unknown cache_control handler %s", handler)
+		}
+	}
+	return nil
+}
+
+func (p CacheControlPolicy) get(handler string) string {
+	if value, ok := p[handler]; ok {
+		return value
+	}
+	return p["default"]
+}
+
+// SetCacheControl sets the Cache-Control header of successful responses by handler,
+// which the CacheControl of an archive's config takes precedence over.
+// Responses to requests that needed credentials are sent with "private" instead.
+func (server *Server) SetCacheControl(policy CacheControlPolicy) {
+	server.cacheControl = policy
+}
+
+// getCacheControl returns the Cache-Control header of a response for an archive,
+// preferring the archive's policy over the server's.
+func (server *Server) getCacheControl(name string, handler string) string {
+	if config, ok := server.archiveConfig(name); ok {
+		if value := config.CacheControl.get(handler); value != "" {
+			return value
+		}
+	}
+	return server.cacheControl.get(handler)
+}
+
+// setLastModified sets the Last-Modified header of a response to modTime, unless it is unknown.
+func setLastModified(httpHeaders map[string]string, modTime time.Time) {
+	if !modTime.IsZero() {
+		httpHeaders["Last-Modified"] = modTime.UTC().Format(http.TimeFormat)
+	}
+}
@@ -0,0 +1,109 @@
+package pmtiles
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheControlPolicy(t *testing.T) {
+	mockBucket, server := newServer(t)
+	header := HeaderV3{TileType: Mvt, MaxZoom: 2}
+	for _, name := range []string{"basemap", "live"} {
+		mockBucket.items[name+".pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+			{0, 0, 0}: {0, 1},
+		}, false, Gzip)
+	}
+	server.SetCacheControl(CacheControlPolicy{"tile": "public, max-age=86400", "tilejson": "public, max-age=300", "default": "no-cache"})
+	server.SetArchiveConfigs([]ArchiveConfig{
+		{Name: "live", CacheControl: CacheControlPolicy{"tile": "no-store"}},
+	})
+
+	_, headers, _ := server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, "public, max-age=86400", headers["Cache-Control"])
+	_, headers, _ = server.Get(context.Background(), "/basemap.json")
+	assert.Equal(t, "public, max-age=300", headers["Cache-Control"])
+	_, headers, _ = server.Get(context.Background(), "/basemap/metadata")
+	assert.Equal(t, "no-cache", headers["Cache-Control"])
+	_, headers, _ = server.Get(context.Background(), "/live/0/0/0.mvt")
+	assert.Equal(t, "no-store", headers["Cache-Control"])
+	_, headers, _ = server.Get(context.Background(), "/live.json")
+	assert.Equal(t, "public, max-age=300", headers["Cache-Control"])
+	statusCode, headers, _ := server.Get(context.Background(), "/missing/0/0/0.mvt")
+	assert.Equal(t, 404, statusCode)
+	assert.Equal(t, "", headers["Cache-Control"])
+
+	// modification time is unknown for the mock bucket
+	_, headers, _ = server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, "", headers["Last-Modified"])
+}
+
+func TestLastModified(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2026, 10, 14, 7, 28, 0, 0, time.UTC)
+	writeWatchedArchive(t, dir, "a", []byte{0, 1}, modTime)
+	server := newWatchedServer(t, dir)
+
+	for _, path := range []string{"/a/0/0/0.mvt", "/a.json", "/a/metadata"} {
+		statusCode, headers, _ := server.Get(context.Background(), path)
+		assert.Equal(t, 200, statusCode, path)
+		assert.Equal(t, "Wed, 14 Oct 2026 07:28:00 GMT", headers["Last-Modified"], path)
+	}
+
+	req := httptest.NewRequest("GET", "/a/0/0/0.mvt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+	res := httptest.NewRecorder()
+	assert.Equal(t, 304, server.ServeHTTP(res, req))
+
+	req = httptest.NewRequest("GET", "/a/0/0/0.mvt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-Modified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat))
+	res = httptest.NewRecorder()
+	assert.Equal(t, 200, server.ServeHTTP(res, req))
+	assert.Equal(t, "Wed, 14 Oct 2026 07:28:00 GMT", res.Header().Get("Last-Modified"))
+	assert.Equal(t, []byte{0, 1}, res.Body.Bytes())
+
+	// a changed archive is modified later
+	newModTime := modTime.Add(time.Hour)
+	writeWatchedArchive(t, dir, "a", []byte{2, 3}, newModTime)
+	req = httptest.NewRequest("GET", "/a/0/0/0.mvt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-Modified-Since", modTime.Format(http.TimeFormat))
+	res = httptest.NewRecorder()
+	assert.Equal(t, 200, server.ServeHTTP(res, req))
+	assert.Equal(t, newModTime.Format(http.TimeFormat), res.Header().Get("Last-Modified"))
+}
+
+func TestLastModifiedCompositeAndSharedDirectoryCache(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2026, 10, 14, 7, 28, 0, 0, time.UTC)
+	writeWatchedArchive(t, dir, "a", []byte{0, 1}, modTime)
+	writeWatchedArchive(t, dir, "b", []byte{0, 1}, modTime.Add(time.Hour))
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	server, err := NewServerWithBucket(NewFileBucket(dir), "", log.Default(), 10, "tiles.example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, server.SetComposite("ab", []string{"a", "b"}))
+	cache := newMemorySharedDirectoryCache()
+	server.SetSharedDirectoryCache(cache)
+	server.Start()
+
+	// a composite is last modified with its latest archive
+	statusCode, headers, _ := server.Get(context.Background(), "/ab/metadata")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "Wed, 14 Oct 2026 08:28:00 GMT", headers["Last-Modified"])
+
+	// a server reading the header from the shared cache knows the modification time too,
+	// although its own bucket does not
+	mock := mockBucket{map[string][]byte{"a.pmtiles": {}}}
+	other := newSharedDirectoryServer(t, mock, cache)
+	statusCode, headers, _ = other.Get(context.Background(), "/a/WMTSCapabilities.xml")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "Wed, 14 Oct 2026 07:28:00 GMT", headers["Last-Modified"])
+}
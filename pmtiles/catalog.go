@@ -0,0 +1,154 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// CatalogArchive summarizes an archive served by a Server.
+type CatalogArchive struct {
+	Name     string    `json:"name"`
+	TileType string    `json:"tile_type"`
+	MinZoom  uint8     `json:"min_zoom"`
+	MaxZoom  uint8     `json:"max_zoom"`
+	Bounds   []float64 `json:"bounds"`
+	TileJSON string    `json:"tilejson,omitempty"`
+}
+
+// Catalog lists the archives served by a Server.
+type Catalog struct {
+	Archives []CatalogArchive `json:"archives"`
+}
+
+// listingTTL is how long a listing of the bucket is reused for catalogNames.
+// Purges of changed archives and archives added or removed as seen by Watch clear it sooner.
+const listingTTL = time.Minute
+
+type bucketListing struct {
+	keys    []string
+	expires time.Time
+}
+
+// listBucket returns the keys of the bucket, reusing the previous listing until it expires or is cleared.
+func (server *Server) listBucket(ctx context.Context) ([]string, error) {
+	listing := server.listing.Load()
+	if listing != nil && time.Now().Before(listing.expires) {
+		return listing.keys, nil
+	}
+	keys, err := server.bucket.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// a listing cleared in the meantime may already be out of date, so it is not kept
+	server.listing.CompareAndSwap(listing, &bucketListing{keys, time.Now().Add(listingTTL)})
+	return keys, nil
+}
+
+// clearListing makes the next listing of the bucket list it again.
+func (server *Server) clearListing() {
+	server.listing.Store(&bucketListing{})
+}
+
+// catalogNames returns the names of the archives in the bucket and the composite archives, sorted.
+// Without SetAuth the listings are public, so archives requiring API keys are left out.
+func (server *Server) catalogNames(ctx context.Context) ([]string, error) {
+	keys, err := server.listBucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range keys {
+		if name, ok := strings.CutSuffix(key, ".pmtiles"); ok {
+			names = append(names, name)
+		}
+	}
+	for name := range server.composites {
+		names = append(names, name)
+	}
+	if !server.auth {
+		names = slices.DeleteFunc(names, func(name string) bool {
+			return len(server.archiveKeys(name)) > 0
+		})
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// listingConcurrency is how many archive headers are looked up at once for a listing.
+const listingConcurrency = 8
+
+// listedHeaders looks up the served headers of archives for a listing, without their metadata.
+// Archives that are missing or fail to load are logged and left out; the rest keep their order.
+func (server *Server) listedHeaders(ctx context.Context, names []string, listing string) ([]string, []HeaderV3) {
+	found := make([]bool, len(names))
+	headers := make([]HeaderV3, len(names))
+	group, ctx := errgroup.WithContext(ctx)
+	group.SetLimit(listingConcurrency)
+	for i, name := range names {
+		group.Go(func() error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			ok, header, err := server.servedHeader(name)
+			if err != nil || !ok {
+				server.logger.Printf("skipping %s in %s, %v", name, listing, err)
+				return nil
+			}
+			found[i], headers[i] = true, header
+			return nil
+		})
+	}
+	group.Wait()
+
+	var listedNames []string
+	var listedHeaders []HeaderV3
+	for i, name := range names {
+		if found[i] {
+			listedNames = append(listedNames, name)
+			listedHeaders = append(listedHeaders, headers[i])
+		}
+	}
+	return listedNames, listedHeaders
+}
+
+func (server *Server) getCatalog(ctx context.Context, httpHeaders map[string]string, auth tileAuth) (int, map[string]string, []byte) {
+	names, err := server.catalogNames(ctx)
+	if err == errListUnsupported {
+		return 501, httpHeaders, []byte("Bucket does not support listing archives")
+	}
+	if err != nil {
+		server.logger.Printf("failed to list archives, %v", err)
+		return 500, httpHeaders, []byte("I/O Error")
+	}
+
+	catalog := Catalog{Archives: []CatalogArchive{}}
+	names, headers := server.listedHeaders(ctx, names, "catalog")
+	for i, name := range names {
+		header := headers[i]
+		archive := CatalogArchive{
+			Name:     name,
+			TileType: tileTypeToString(header.TileType),
+			MinZoom:  header.MinZoom,
+			MaxZoom:  header.MaxZoom,
+			Bounds:   ogcBounds(header),
+		}
+		if publicURL := server.archivePublicURL(name); publicURL != "" {
+			archive.TileJSON = withQuery(publicURL+"/"+name+".json", server.tileQuery(auth, name))
+		}
+		catalog.Archives = append(catalog.Archives, archive)
+	}
+
+	catalogBytes, err := json.MarshalIndent(catalog, "", "\t")
+	if err != nil {
+		return 500, httpHeaders, []byte("Error generating catalog")
+	}
+	httpHeaders["Content-Type"] = "application/json"
+	httpHeaders["ETag"] = generateEtag(catalogBytes)
+	return 200, httpHeaders, catalogBytes
+}
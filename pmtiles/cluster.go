@@ -7,7 +7,24 @@ import (
 	"os"
 )
 
+// Cluster rewrites an unclustered local archive in place, ordering tiles by TileID.
 func Cluster(logger *log.Logger, InputPMTiles string, deduplicate bool) error {
+	return ClusterWithCompression(logger, InputPMTiles, deduplicate, "", "")
+}
+
+// ClusterWithCompression is like Cluster, but also changes the tile and internal compressions.
+// Compressions are one of none, gzip, br or zstd; an empty string keeps the tile compression
+// and uses gzip for directories and metadata.
+func ClusterWithCompression(logger *log.Logger, InputPMTiles string, deduplicate bool, tileCompressionString string, internalCompressionString string) error {
+	tileCompression, err := parseCompressionOption(tileCompressionString)
+	if err != nil {
+		return err
+	}
+	internalCompression, err := parseCompressionOption(internalCompressionString)
+	if err != nil {
+		return err
+	}
+
 	file, err := os.OpenFile(InputPMTiles, os.O_RDONLY, 0666)
 	if err != nil {
 		return err
@@ -28,6 +45,16 @@ func Cluster(logger *log.Logger, InputPMTiles string, deduplicate bool) error {
 		return fmt.Errorf("archive is already clustered")
 	}
 
+	if tileCompression == UnknownCompression {
+		tileCompression = header.TileCompression
+	}
+	if internalCompression == UnknownCompression {
+		internalCompression = Gzip
+	}
+	if tileCompression != header.TileCompression && header.TileCompression == UnknownCompression {
+		return fmt.Errorf("cannot recompress tiles with unknown compression")
+	}
+
 	fmt.Println("total directory size", header.RootLength+header.LeafDirectoryLength)
 
 	metadataReader := io.NewSectionReader(file, int64(header.MetadataOffset), int64(header.MetadataLength))
@@ -42,12 +69,22 @@ func Cluster(logger *log.Logger, InputPMTiles string, deduplicate bool) error {
 
 	bar := defaultProgressbar(logger, int64(header.TileEntriesCount))
 
+	var iterErr error
 	err = IterateEntries(header,
 		func(offset uint64, length uint64) ([]byte, error) {
 			return io.ReadAll(io.NewSectionReader(file, int64(offset), int64(length)))
 		},
 		func(e EntryV3) {
+			if iterErr != nil {
+				return
+			}
 			data, _ := io.ReadAll(io.NewSectionReader(file, int64(header.TileDataOffset+e.Offset), int64(e.Length)))
+			if tileCompression != header.TileCompression {
+				data, iterErr = transcodeBytes(data, header.TileCompression, tileCompression)
+				if iterErr != nil {
+					return
+				}
+			}
 			if isNew, newData := resolver.AddTileIsNew(e.TileID, data, e.RunLength); isNew {
 				tmpfile.Write(newData)
 			}
@@ -57,10 +94,15 @@ func Cluster(logger *log.Logger, InputPMTiles string, deduplicate bool) error {
 	if err != nil {
 		return err
 	}
+	if iterErr != nil {
+		return fmt.Errorf("Failed to recompress tile, %w", iterErr)
+	}
 
 	file.Close()
 
 	header.Clustered = true
+	header.TileCompression = tileCompression
+	header.InternalCompression = internalCompression
 	newHeader, err := finalize(logger, resolver, header, tmpfile, InputPMTiles, metadata)
 	if err != nil {
 		return err
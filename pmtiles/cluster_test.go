@@ -1,6 +1,7 @@
 package pmtiles
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -21,3 +22,28 @@ func TestCluster(t *testing.T) {
 	header, _ := DeserializeHeader(buf)
 	assert.True(t, header.Clustered)
 }
+
+func TestClusterCompression(t *testing.T) {
+	fileToCluster := makeFixtureCopy(t, "unclustered", "cluster")
+	original := readerForFile(t, "fixtures/unclustered.pmtiles")
+
+	err := ClusterWithCompression(logger, fileToCluster, true, "br", "zstd")
+	assert.Nil(t, err)
+
+	clustered := readerForFile(t, fileToCluster)
+	assert.True(t, clustered.Header().Clustered)
+	assert.Equal(t, Compression(Brotli), clustered.Header().TileCompression)
+	assert.Equal(t, Compression(Zstd), clustered.Header().InternalCompression)
+
+	ctx := context.Background()
+	err = original.IterateEntries(ctx, func(e EntryV3) {
+		expected, _, _ := original.GetTileByID(ctx, e.TileID)
+		expected, _ = decompressBytes(expected, original.Header().TileCompression)
+		actual, ok, _ := clustered.GetTileByID(ctx, e.TileID)
+		assert.True(t, ok)
+		actual, err := decompressBytes(actual, Brotli)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+	assert.Nil(t, err)
+}
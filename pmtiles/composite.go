@@ -0,0 +1,230 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SetComposite defines a virtual vector archive whose tiles stack the layers of several archives.
+// Archives are stacked in the given order, and may be aliases resolved on each request; it must be called before Start.
+// Layers with the same name in several archives are merged.
+func (server *Server) SetComposite(name string, archives []string) error {
+	if len(archives) == 0 {
+		return fmt.Errorf("composite %s has no archives", name)
+	}
+	for _, archive := range archives {
+		if _, ok := server.composites[archive]; ok || archive == name {
+			return fmt.Errorf("composite %s cannot include composite %s", name, archive)
+		}
+	}
+	for other, members := range server.composites {
+		if slices.Contains(members, name) {
+			return fmt.Errorf("composite %s is already included in composite %s", name, other)
+		}
+	}
+	if server.composites == nil {
+		server.composites = make(map[string][]string)
+	}
+	server.composites[name] = archives
+	return nil
+}
+
+// getCompositeTile concatenates the decompressed layers of each archive's tile.
+// Archives that are missing or do not contain the tile are skipped.
+func (server *Server) getCompositeTile(ctx context.Context, httpHeaders map[string]string, archives []string, z uint8, x uint32, y uint32, ext string, acceptEncoding string) (int, map[string]string, []byte) {
+	if ext != "mvt" {
+		return 400, httpHeaders, []byte("path mismatch: composite archives are type MVT (.mvt)")
+	}
+
+	var layers [][]byte
+	var modTime time.Time
+	status := 404
+	for _, archive := range archives {
+		tileStatus, tileHeaders, data := server.getTile(ctx, make(map[string]string), archive, z, x, y, ext, "*")
+		switch tileStatus {
+		case 200:
+			if tileModTime, err := http.ParseTime(tileHeaders["Last-Modified"]); err == nil && tileModTime.After(modTime) {
+				modTime = tileModTime
+			}
+			compression := stringToCompression(tileHeaders["Content-Encoding"])
+			if compression == UnknownCompression {
+				compression = NoCompression
+			}
+			decompressed, err := decompressBytes(data, compression)
+			if err != nil {
+				server.logger.Printf("failed to decompress tile %s %d/%d/%d, %v", archive, z, x, y, err)
+				return 500, httpHeaders, []byte("I/O error")
+			}
+			layers = append(layers, decompressed)
+		case 204:
+			status = 204
+		case 404:
+		default:
+			return tileStatus, httpHeaders, data
+		}
+	}
+
+	if len(layers) == 0 {
+		if status == 404 {
+			return 404, httpHeaders, []byte("Tile not found")
+		}
+		return 204, httpHeaders, nil
+	}
+
+	b, err := ConcatenateMvtLayers(z, x, y, layers)
+	if err != nil {
+		return 500, httpHeaders, []byte("Failed to combine tiles")
+	}
+	encoding := negotiateEncoding(Gzip, acceptEncoding)
+	if encoding == Gzip {
+		b, err = compressBytes(b, Gzip)
+		if err != nil {
+			return 500, httpHeaders, []byte("Failed to compress tile")
+		}
+		httpHeaders["Content-Encoding"] = "gzip"
+	}
+
+	httpHeaders["ETag"] = generateEtag(b)
+	setLastModified(httpHeaders, modTime)
+	httpHeaders["Content-Type"] = "application/x-protobuf"
+	httpHeaders["Vary"] = "Accept-Encoding"
+	return 200, httpHeaders, b
+}
+
+// combineCompositeHeader extends the zoom levels and bounds of combined to cover the header
+// of the i-th archive of a composite.
+func combineCompositeHeader(combined *HeaderV3, i int, header HeaderV3) {
+	if i == 0 {
+		combined.MinZoom, combined.MaxZoom = header.MinZoom, header.MaxZoom
+		combined.MinLonE7, combined.MinLatE7 = header.MinLonE7, header.MinLatE7
+		combined.MaxLonE7, combined.MaxLatE7 = header.MaxLonE7, header.MaxLatE7
+		combined.CenterLonE7, combined.CenterLatE7, combined.CenterZoom = header.CenterLonE7, header.CenterLatE7, header.CenterZoom
+		return
+	}
+	combined.MinZoom = min(combined.MinZoom, header.MinZoom)
+	combined.MaxZoom = max(combined.MaxZoom, header.MaxZoom)
+	combined.MinLonE7 = min(combined.MinLonE7, header.MinLonE7)
+	combined.MinLatE7 = min(combined.MinLatE7, header.MinLatE7)
+	combined.MaxLonE7 = max(combined.MaxLonE7, header.MaxLonE7)
+	combined.MaxLatE7 = max(combined.MaxLatE7, header.MaxLatE7)
+}
+
+// appendVectorLayers adds the vector_layers entries of an archive to those of a composite.
+// A layer already present is extended with the fields and zoom levels of the new entry,
+// as the tiles merge layers with the same name.
+func appendVectorLayers(vectorLayers []interface{}, layers []interface{}) []interface{} {
+	for _, layer := range layers {
+		entry, ok := layer.(map[string]interface{})
+		if !ok {
+			vectorLayers = append(vectorLayers, layer)
+			continue
+		}
+		i := slices.IndexFunc(vectorLayers, func(existing interface{}) bool {
+			existingEntry, ok := existing.(map[string]interface{})
+			return ok && existingEntry["id"] == entry["id"]
+		})
+		if i < 0 {
+			vectorLayers = append(vectorLayers, entry)
+			continue
+		}
+		existing := vectorLayers[i].(map[string]interface{})
+		if fields, ok := entry["fields"].(map[string]interface{}); ok {
+			existingFields, ok := existing["fields"].(map[string]interface{})
+			if !ok {
+				existingFields = make(map[string]interface{})
+				existing["fields"] = existingFields
+			}
+			for field, description := range fields {
+				if _, ok := existingFields[field]; !ok {
+					existingFields[field] = description
+				}
+			}
+		}
+		if minzoom, ok := entry["minzoom"].(float64); ok {
+			if existingMinzoom, ok := existing["minzoom"].(float64); ok && minzoom < existingMinzoom {
+				existing["minzoom"] = minzoom
+			}
+		}
+		if maxzoom, ok := entry["maxzoom"].(float64); ok {
+			if existingMaxzoom, ok := existing["maxzoom"].(float64); ok && maxzoom > existingMaxzoom {
+				existing["maxzoom"] = maxzoom
+			}
+		}
+	}
+	return vectorLayers
+}
+
+// getCompositeHeader combines the headers of each archive like getCompositeHeaderMetadata,
+// without fetching their metadata.
+func (server *Server) getCompositeHeader(name string, archives []string) (bool, HeaderV3, time.Time, error) {
+	combined := HeaderV3{TileType: Mvt, TileCompression: Gzip}
+	var modTime time.Time
+	for i, archive := range archives {
+		found, header, archiveModTime := server.getHeader(archive)
+		if !found {
+			return false, HeaderV3{}, time.Time{}, nil
+		}
+		if header.TileType != Mvt {
+			return false, HeaderV3{}, time.Time{}, fmt.Errorf("archive %s in composite %s is not type MVT", archive, name)
+		}
+		combineCompositeHeader(&combined, i, header)
+		if archiveModTime.After(modTime) {
+			modTime = archiveModTime
+		}
+	}
+	return true, combined, modTime, nil
+}
+
+// getCompositeHeaderMetadata combines the headers and metadata of each archive,
+// with zoom levels and bounds covering all archives and every vector_layers entry.
+// The composite is last modified when its most recently modified archive was.
+func (server *Server) getCompositeHeaderMetadata(ctx context.Context, name string, archives []string) (bool, HeaderV3, []byte, time.Time, error) {
+	combined := HeaderV3{TileType: Mvt, TileCompression: Gzip}
+	vectorLayers := make([]interface{}, 0)
+	var attributions []string
+	var modTime time.Time
+
+	for i, archive := range archives {
+		found, header, metadataBytes, archiveModTime, err := server.getHeaderMetadata(ctx, archive)
+		if err != nil {
+			return false, HeaderV3{}, nil, time.Time{}, err
+		}
+		if !found {
+			return false, HeaderV3{}, nil, time.Time{}, nil
+		}
+		if header.TileType != Mvt {
+			return false, HeaderV3{}, nil, time.Time{}, fmt.Errorf("archive %s in composite %s is not type MVT", archive, name)
+		}
+		combineCompositeHeader(&combined, i, header)
+		if archiveModTime.After(modTime) {
+			modTime = archiveModTime
+		}
+
+		var metadataMap map[string]interface{}
+		json.Unmarshal(metadataBytes, &metadataMap)
+		if layers, ok := metadataMap["vector_layers"].([]interface{}); ok {
+			vectorLayers = appendVectorLayers(vectorLayers, layers)
+		}
+		if attribution, ok := metadataMap["attribution"].(string); ok && attribution != "" && !slices.Contains(attributions, attribution) {
+			attributions = append(attributions, attribution)
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"name":          name,
+		"vector_layers": vectorLayers,
+	}
+	if len(attributions) > 0 {
+		metadata["attribution"] = strings.Join(attributions, " ")
+	}
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return false, HeaderV3{}, nil, time.Time{}, err
+	}
+	return true, combined, metadataBytes, modTime, nil
+}
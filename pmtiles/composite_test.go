@@ -0,0 +1,122 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipTile(t *testing.T, data []byte) []byte {
+	compressed, err := compressBytes(data, Gzip)
+	assert.Nil(t, err)
+	return compressed
+}
+
+func TestCompositeTiles(t *testing.T) {
+	mockBucket, server := newServer(t)
+	header := HeaderV3{TileType: Mvt}
+	mockBucket.items["base.pmtiles"] = fakeArchive(header, map[string]interface{}{
+		"vector_layers": []interface{}{map[string]interface{}{"id": "water"}},
+		"attribution":   "base",
+	}, map[Zxy][]byte{
+		{0, 0, 0}: gzipTile(t, testMvtLayerTile("water", 4096, "kind", "lake")),
+		{1, 0, 0}: gzipTile(t, testMvtLayerTile("water", 4096, "kind", "ocean")),
+	}, false, Gzip)
+	mockBucket.items["pois.pmtiles"] = fakeArchive(header, map[string]interface{}{
+		"vector_layers": []interface{}{map[string]interface{}{"id": "pois"}},
+		"attribution":   "pois",
+	}, map[Zxy][]byte{
+		{0, 0, 0}: gzipTile(t, testMvtLayerTile("pois", 4096, "kind", "park")),
+	}, false, Gzip)
+	assert.Nil(t, server.SetComposite("basemap", []string{"base", "pois"}))
+
+	statusCode, headers, data := server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "gzip", headers["Content-Encoding"])
+	decompressed, err := decompressBytes(data, Gzip)
+	assert.Nil(t, err)
+	layers := testMvtLayers(t, decompressed)
+	assert.Equal(t, 2, len(layers))
+	assert.Equal(t, []string{"lake"}, layers["water"].values)
+	assert.Equal(t, []string{"park"}, layers["pois"].values)
+
+	statusCode, _, data = server.Get(context.Background(), "/basemap/1/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	decompressed, err = decompressBytes(data, Gzip)
+	assert.Nil(t, err)
+	assert.Equal(t, testMvtLayerTile("water", 4096, "kind", "ocean"), decompressed)
+
+	statusCode, _, _ = server.Get(context.Background(), "/basemap/1/1/1.mvt")
+	assert.Equal(t, 204, statusCode)
+
+	statusCode, _, _ = server.Get(context.Background(), "/basemap/0/0/0.png")
+	assert.Equal(t, 400, statusCode)
+
+	statusCode, _, data = server.Get(context.Background(), "/basemap.json")
+	assert.Equal(t, 200, statusCode)
+	var tilejson map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &tilejson))
+	assert.Equal(t, 2, len(tilejson["vector_layers"].([]interface{})))
+	assert.Equal(t, "base pois", tilejson["attribution"])
+	assert.Equal(t, float64(1), tilejson["maxzoom"])
+	assert.Equal(t, []interface{}{"tiles.example.com/basemap/{z}/{x}/{y}.mvt"}, tilejson["tiles"])
+}
+
+func TestCompositeAliasesAndDuplicateLayers(t *testing.T) {
+	mockBucket, server := newServer(t)
+	header := HeaderV3{TileType: Mvt}
+	mockBucket.items["base-1.pmtiles"] = fakeArchive(header, map[string]interface{}{
+		"vector_layers": []interface{}{map[string]interface{}{"id": "water", "fields": map[string]interface{}{"kind": "String"}}},
+	}, map[Zxy][]byte{
+		{0, 0, 0}: gzipTile(t, testMvtLayerTile("water", 4096, "kind", "lake")),
+	}, false, Gzip)
+	mockBucket.items["rivers.pmtiles"] = fakeArchive(header, map[string]interface{}{
+		"vector_layers": []interface{}{map[string]interface{}{"id": "water", "fields": map[string]interface{}{"name": "String"}}},
+	}, map[Zxy][]byte{
+		{0, 0, 0}: gzipTile(t, testMvtLayerTile("water", 4096, "kind", "river")),
+	}, false, Gzip)
+	server.SetAliases(map[string]string{"base": "base-1"})
+	assert.Nil(t, server.SetComposite("basemap", []string{"base", "rivers"}))
+
+	statusCode, _, data := server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	decompressed, err := decompressBytes(data, Gzip)
+	assert.Nil(t, err)
+	layers := testMvtLayers(t, decompressed)
+	assert.Equal(t, 1, len(layers))
+	assert.Equal(t, 2, layers["water"].features)
+	assert.Equal(t, []string{"lake", "river"}, layers["water"].values)
+
+	statusCode, _, data = server.Get(context.Background(), "/basemap.json")
+	assert.Equal(t, 200, statusCode)
+	var tilejson map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &tilejson))
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"id":     "water",
+		"fields": map[string]interface{}{"kind": "String", "name": "String"},
+	}}, tilejson["vector_layers"])
+}
+
+func TestCompositeMissingArchive(t *testing.T) {
+	mockBucket, server := newServer(t)
+	mockBucket.items["base.pmtiles"] = fakeArchive(HeaderV3{TileType: Mvt}, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: gzipTile(t, testMvtLayerTile("water", 4096, "kind", "lake")),
+	}, false, Gzip)
+	assert.Nil(t, server.SetComposite("basemap", []string{"base", "missing"}))
+
+	statusCode, _, _ := server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	statusCode, _, _ = server.Get(context.Background(), "/basemap.json")
+	assert.Equal(t, 404, statusCode)
+}
+
+func TestSetCompositeErrors(t *testing.T) {
+	_, server := newServer(t)
+	assert.NotNil(t, server.SetComposite("empty", nil))
+	assert.NotNil(t, server.SetComposite("self", []string{"self"}))
+	assert.Nil(t, server.SetComposite("basemap", []string{"base", "pois"}))
+	assert.NotNil(t, server.SetComposite("nested", []string{"basemap"}))
+	assert.NotNil(t, server.SetComposite("base", []string{"other"}))
+}
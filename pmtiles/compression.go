@@ -0,0 +1,146 @@
+package pmtiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+var errUnsupportedCompression = errors.New("compression not supported")
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (w nopCloser) Close() error { return nil }
+
+// newCompressionWriter returns a writer that compresses into w; Close must be called to flush.
+func newCompressionWriter(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case NoCompression:
+		return nopCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case Brotli:
+		return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	}
+	return nil, errUnsupportedCompression
+}
+
+// newDecompressionReader returns a reader of the decompressed contents of r.
+func newDecompressionReader(r io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case NoCompression:
+		return io.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Brotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case Zstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, errUnsupportedCompression
+}
+
+// compressBytes compresses data in memory with the given compression.
+func compressBytes(data []byte, compression Compression) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := newCompressionWriter(&b, compression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decompressBytes decompresses data in memory with the given compression.
+func decompressBytes(data []byte, compression Compression) ([]byte, error) {
+	r, err := newDecompressionReader(bytes.NewReader(data), compression)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// transcodeBytes converts data stored with one compression to another.
+func transcodeBytes(data []byte, from Compression, to Compression) ([]byte, error) {
+	decompressed, err := decompressBytes(data, from)
+	if err != nil {
+		return nil, err
+	}
+	return compressBytes(decompressed, to)
+}
+
+// negotiateEncoding picks the compression to serve a tile stored with stored compression,
+// given a request's Accept-Encoding header.
+// The stored compression is preferred, then gzip, then no compression.
+// Without an Accept-Encoding header the tile is served uncompressed.
+func negotiateEncoding(stored Compression, acceptEncoding string) Compression {
+	storedName, ok := compressionToString(stored)
+	if !ok {
+		return stored
+	}
+	if acceptEncoding == "" {
+		return NoCompression
+	}
+
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				q, _ = strconv.ParseFloat(value, 64)
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+
+	accepts := func(name string) bool {
+		if ok, present := accepted[name]; present {
+			return ok
+		}
+		return accepted["*"]
+	}
+
+	if accepts(storedName) {
+		return stored
+	}
+	if accepts("gzip") {
+		return Gzip
+	}
+	return NoCompression
+}
+
+// encodeTile compresses an uncompressed or gzipped tile with the given compression.
+func encodeTile(data []byte, compression Compression) ([]byte, error) {
+	if isGzip(data) {
+		if compression == Gzip {
+			return data, nil
+		}
+		decompressed, err := decompressBytes(data, Gzip)
+		if err != nil {
+			return nil, err
+		}
+		data = decompressed
+	}
+	return compressBytes(data, compression)
+}
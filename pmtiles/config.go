@@ -0,0 +1,344 @@
+package pmtiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration read from a string such as "5s" or a number of nanoseconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
+
+// ArchiveConfig sets options for the archive called Name, or for every archive whose name starts with Prefix.
+// Names are matched after resolving aliases; an exact Name takes precedence over the longest matching Prefix.
+type ArchiveConfig struct {
+	Name   string `json:"name,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	// PublicURL replaces the server public URL in TileJSON and capabilities documents for the archive.
+	PublicURL string `json:"public_url,omitempty"`
+	// Cors lists origins allowed to request the archive in addition to the server CORS origins.
+	// Preflight requests are answered for these origins unless server CORS origins are set,
+	// in which case the server CORS handler answers them.
+	Cors []string `json:"cors,omitempty"`
+	// CacheControl is the Cache-Control header of successful responses for the archive, overriding the server policy.
+	CacheControl CacheControlPolicy `json:"cache_control,omitempty"`
+	// APIKeys are accepted for the archive in addition to those of the server, and require credentials for it
+	// and the composites including it. Such archives are left out of listings unless the server requires auth.
+	APIKeys []string `json:"api_keys,omitempty"`
+}
+
+// AuthConfig requires requests for archives to carry an API key or a signed URL.
+type AuthConfig struct {
+	// SigningKey is the secret of HMAC-signed URLs, which TileJSON tile URLs are signed with.
+	SigningKey string `json:"signing_key,omitempty"`
+	// APIKeys are accepted for every archive, as the key query parameter or a bearer token.
+	APIKeys []string `json:"api_keys,omitempty"`
+	// SignedURLTTL is how long signed TileJSON tile URLs are valid for at least, an hour by default.
+	SignedURLTTL Duration `json:"signed_url_ttl,omitempty"`
+}
+
+// ServeConfig holds the options of a Server, as read from a configuration file or a Caddy module config.
+type ServeConfig struct {
+	Bucket          string              `json:"bucket,omitempty"`
+	CacheSize       int                 `json:"cache_size,omitempty"`
+	PublicURL       string              `json:"public_url,omitempty"`
+	Cors            []string            `json:"cors,omitempty"`
+	CacheControl    CacheControlPolicy  `json:"cache_control,omitempty"`
+	Composites      map[string][]string `json:"composites,omitempty"`
+	Overzoom        uint8               `json:"overzoom,omitempty"`
+	TranscodeRaster int                 `json:"transcode_raster,omitempty"`
+	TileCacheSize   int                 `json:"tile_cache_size,omitempty"`
+	TileCacheDir    string              `json:"tile_cache_dir,omitempty"`
+	Viewer          bool                `json:"viewer,omitempty"`
+	WatchInterval   Duration            `json:"watch_interval,omitempty"`
+	Prewarm         bool                `json:"prewarm,omitempty"`
+	Aliases         map[string]string   `json:"aliases,omitempty"`
+	AliasesFile     string              `json:"aliases_file,omitempty"`
+	Archives        []ArchiveConfig     `json:"archives,omitempty"`
+	Auth            *AuthConfig         `json:"auth,omitempty"`
+}
+
+// LoadConfig decodes a YAML, JSON or TOML file, chosen by its extension, into config using its JSON field names.
+// Fields already set in config are kept unless the file sets them. Unknown fields are an error.
+func LoadConfig(path string, config interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read config, %w", err)
+	}
+
+	var jsonData []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		jsonData = data
+	case ".yaml", ".yml":
+		var v map[string]interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("Failed to parse config, %w", err)
+		}
+		if jsonData, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("Failed to parse config, %w", err)
+		}
+	case ".toml":
+		var v map[string]interface{}
+		if err := toml.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("Failed to parse config, %w", err)
+		}
+		if jsonData, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("Failed to parse config, %w", err)
+		}
+	default:
+		return fmt.Errorf("Unsupported config format %s, use .yaml, .json or .toml", filepath.Ext(path))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("Failed to parse config, %w", err)
+	}
+	return nil
+}
+
+// Validate checks a ServeConfig and sets defaults.
+func (c *ServeConfig) Validate() error {
+	if c.CacheSize <= 0 {
+		c.CacheSize = 64
+	}
+	if c.TileCacheDir != "" && c.TileCacheSize <= 0 {
+		return errors.New("tile_cache_size must be set with tile_cache_dir")
+	}
+	if c.WatchInterval < 0 {
+		return errors.New("watch_interval must not be negative")
+	}
+	if c.PublicURL != "" {
+		if err := validatePublicURL(c.PublicURL); err != nil {
+			return err
+		}
+	}
+	if err := c.CacheControl.Validate(); err != nil {
+		return err
+	}
+	if len(c.Aliases) > 0 && c.AliasesFile != "" {
+		return errors.New("aliases and aliases_file cannot both be set")
+	}
+	if err := normalizeAliases(c.Aliases); err != nil {
+		return err
+	}
+	if c.Overzoom > MaxOverzoomLevels {
+		return fmt.Errorf("overzoom must be at most %d", MaxOverzoomLevels)
+	}
+	for name, archives := range c.Composites {
+		if len(archives) == 0 {
+			return fmt.Errorf("composite %s has no archives", name)
+		}
+	}
+
+	names := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for i, archive := range c.Archives {
+		if (archive.Name == "") == (archive.Prefix == "") {
+			return fmt.Errorf("archives[%d] must set exactly one of name or prefix", i)
+		}
+		if archive.Name != "" {
+			if names[archive.Name] {
+				return fmt.Errorf("archives[%d] duplicates name %s", i, archive.Name)
+			}
+			names[archive.Name] = true
+		} else {
+			if prefixes[archive.Prefix] {
+				return fmt.Errorf("archives[%d] duplicates prefix %s", i, archive.Prefix)
+			}
+			prefixes[archive.Prefix] = true
+		}
+		if archive.PublicURL != "" {
+			if err := validatePublicURL(archive.PublicURL); err != nil {
+				return fmt.Errorf("archives[%d]: %w", i, err)
+			}
+		}
+		if err := archive.CacheControl.Validate(); err != nil {
+			return fmt.Errorf("archives[%d]: %w", i, err)
+		}
+		for _, origin := range archive.Cors {
+			if origin == "" {
+				return fmt.Errorf("archives[%d] has an empty CORS origin", i)
+			}
+		}
+		for _, key := range archive.APIKeys {
+			if key == "" {
+				return fmt.Errorf("archives[%d] has an empty API key", i)
+			}
+		}
+	}
+
+	if c.Auth != nil {
+		if c.Auth.SigningKey == "" && len(c.Auth.APIKeys) == 0 {
+			return errors.New("auth must set signing_key or api_keys")
+		}
+		if c.Auth.SigningKey != "" && len(c.Auth.SigningKey) < 16 {
+			return errors.New("auth signing_key must be at least 16 bytes")
+		}
+		for _, key := range c.Auth.APIKeys {
+			if key == "" {
+				return errors.New("auth has an empty API key")
+			}
+		}
+		if c.Auth.SignedURLTTL < 0 {
+			return errors.New("auth signed_url_ttl must not be negative")
+		}
+		if c.Auth.SignedURLTTL == 0 {
+			c.Auth.SignedURLTTL = Duration(time.Hour)
+		}
+	}
+	return nil
+}
+
+func validatePublicURL(publicURL string) error {
+	u, err := url.Parse(publicURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid public URL %s", publicURL)
+	}
+	return nil
+}
+
+// NewServerFromConfig creates a Server for a validated ServeConfig, serving the bucket at prefix.
+// The caller starts the server, and watches for changes when WatchInterval is set.
+func NewServerFromConfig(config ServeConfig, prefix string, logger *log.Logger) (*Server, error) {
+	server, err := NewServer(config.Bucket, prefix, logger, config.CacheSize, config.PublicURL)
+	if err != nil {
+		return nil, err
+	}
+	for name, archives := range config.Composites {
+		if err := server.SetComposite(name, archives); err != nil {
+			return nil, err
+		}
+	}
+	server.SetOverzoom(config.Overzoom)
+	server.SetViewer(config.Viewer)
+	if config.TranscodeRaster > 0 {
+		server.SetRasterTranscoding(config.TranscodeRaster)
+	}
+	if config.TileCacheDir != "" {
+		tileCache, err := NewDiskTileCache(config.TileCacheDir, int64(config.TileCacheSize)*1000*1000)
+		if err != nil {
+			return nil, err
+		}
+		server.SetTileCache(tileCache)
+	} else if config.TileCacheSize > 0 {
+		server.SetTileCache(NewMemoryTileCache(config.TileCacheSize * 1000 * 1000))
+	}
+	aliases := config.Aliases
+	if config.AliasesFile != "" {
+		if aliases, err = LoadAliases(config.AliasesFile); err != nil {
+			return nil, err
+		}
+	}
+	if aliases != nil {
+		server.SetAliases(aliases)
+	}
+	server.SetCacheControl(config.CacheControl)
+	server.SetArchiveConfigs(config.Archives)
+	if config.Auth != nil {
+		server.SetAuth(config.Auth.SigningKey, config.Auth.APIKeys, time.Duration(config.Auth.SignedURLTTL))
+	}
+	return server, nil
+}
+
+// SetArchiveConfigs sets the per-archive public URL, CORS origins, Cache-Control policy and API keys of a validated config.
+func (server *Server) SetArchiveConfigs(archives []ArchiveConfig) {
+	server.archives = archives
+}
+
+// archiveConfig returns the config of the archive with the exact name, or else the longest matching prefix.
+func (server *Server) archiveConfig(name string) (ArchiveConfig, bool) {
+	var match ArchiveConfig
+	found := false
+	if name == "" {
+		return match, false
+	}
+	for _, archive := range server.archives {
+		if archive.Name == name {
+			return archive, true
+		}
+		if archive.Prefix != "" && strings.HasPrefix(name, archive.Prefix) && len(archive.Prefix) > len(match.Prefix) {
+			match, found = archive, true
+		}
+	}
+	return match, found
+}
+
+func (server *Server) archivePublicURL(name string) string {
+	if config, ok := server.archiveConfig(name); ok && config.PublicURL != "" {
+		return config.PublicURL
+	}
+	return server.publicURL
+}
+
+// archivePreflight answers a CORS preflight request for an archive with CORS origins in its config,
+// returning false for other archives. Preflight requests carry no credentials, so they are not authorized.
+func (server *Server) archivePreflight(header http.Header, r *http.Request) bool {
+	_, name, ok := server.pathArchive(r.URL.Path)
+	if !ok || name == "" {
+		return false
+	}
+	if config, ok := server.archiveConfig(name); !ok || len(config.Cors) == 0 {
+		return false
+	}
+	server.setArchiveCors(header, name, r.Header.Get("Origin"))
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	if header.Get("Access-Control-Allow-Origin") != "" {
+		header.Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+	}
+	return true
+}
+
+// setArchiveCors allows a request from origin if it is listed in the archive's CORS origins.
+// Requests from other origins are left to the server-wide CORS handler.
+func (server *Server) setArchiveCors(header http.Header, name string, origin string) {
+	config, ok := server.archiveConfig(name)
+	if !ok || len(config.Cors) == 0 {
+		return
+	}
+	header.Add("Vary", "Origin")
+	for _, allowed := range config.Cors {
+		if allowed == "*" || allowed == origin {
+			header.Set("Access-Control-Allow-Origin", allowed)
+			return
+		}
+	}
+}
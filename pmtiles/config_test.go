@@ -0,0 +1,223 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigFormats(t *testing.T) {
+	files := map[string]string{
+		"serve.yaml": `
+bucket: file:///tiles
+public_url: https://example.com/tiles
+cors: [https://example.com]
+watch_interval: 5s
+cache_control:
+  tile: public, max-age=86400
+  default: no-cache
+composites:
+  basemap: [base, pois]
+archives:
+  - name: basemap
+    cache_control: public, max-age=3600
+  - prefix: private/
+    public_url: https://cdn.example.com/tiles
+`,
+		"serve.json": `{
+	"bucket": "file:///tiles",
+	"public_url": "https://example.com/tiles",
+	"cors": ["https://example.com"],
+	"watch_interval": "5s",
+	"cache_control": {"tile": "public, max-age=86400", "default": "no-cache"},
+	"composites": {"basemap": ["base", "pois"]},
+	"archives": [
+		{"name": "basemap", "cache_control": "public, max-age=3600"},
+		{"prefix": "private/", "public_url": "https://cdn.example.com/tiles"}
+	]
+}`,
+		"serve.toml": `
+bucket = "file:///tiles"
+public_url = "https://example.com/tiles"
+cors = ["https://example.com"]
+watch_interval = "5s"
+
+[cache_control]
+tile = "public, max-age=86400"
+default = "no-cache"
+
+[composites]
+basemap = ["base", "pois"]
+
+[[archives]]
+name = "basemap"
+cache_control = "public, max-age=3600"
+
+[[archives]]
+prefix = "private/"
+public_url = "https://cdn.example.com/tiles"
+`,
+	}
+	expected := ServeConfig{
+		Bucket:        "file:///tiles",
+		CacheSize:     32,
+		PublicURL:     "https://example.com/tiles",
+		Cors:          []string{"https://example.com"},
+		WatchInterval: Duration(5 * time.Second),
+		CacheControl:  CacheControlPolicy{"tile": "public, max-age=86400", "default": "no-cache"},
+		Composites:    map[string][]string{"basemap": {"base", "pois"}},
+		Archives: []ArchiveConfig{
+			{Name: "basemap", CacheControl: CacheControlPolicy{"default": "public, max-age=3600"}},
+			{Prefix: "private/", PublicURL: "https://cdn.example.com/tiles"},
+		},
+	}
+	for name, contents := range files {
+		path := filepath.Join(t.TempDir(), name)
+		assert.Nil(t, os.WriteFile(path, []byte(contents), 0644))
+		config := ServeConfig{CacheSize: 32}
+		assert.Nil(t, LoadConfig(path, &config), name)
+		assert.Equal(t, expected, config, name)
+		assert.Nil(t, config.Validate(), name)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	var config ServeConfig
+
+	path := filepath.Join(dir, "unknown.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("buckets: file:///tiles\n"), 0644))
+	assert.NotNil(t, LoadConfig(path, &config))
+
+	path = filepath.Join(dir, "serve.ini")
+	assert.Nil(t, os.WriteFile(path, []byte("bucket=file:///tiles\n"), 0644))
+	assert.NotNil(t, LoadConfig(path, &config))
+
+	path = filepath.Join(dir, "duration.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"watch_interval": "soon"}`), 0644))
+	assert.NotNil(t, LoadConfig(path, &config))
+
+	assert.NotNil(t, LoadConfig(filepath.Join(dir, "missing.json"), &config))
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := ServeConfig{Aliases: map[string]string{"basemap": "basemap-1.pmtiles"}}
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, 64, config.CacheSize)
+	assert.Equal(t, "basemap-1", config.Aliases["basemap"])
+	config = ServeConfig{Auth: &AuthConfig{SigningKey: "0123456789abcdef"}}
+	assert.Nil(t, config.Validate())
+	assert.Equal(t, Duration(time.Hour), config.Auth.SignedURLTTL)
+
+	for _, invalid := range []ServeConfig{
+		{PublicURL: "example.com/tiles"},
+		{TileCacheDir: "/tmp/tiles"},
+		{Aliases: map[string]string{"basemap": "basemap-1"}, AliasesFile: "aliases.json"},
+		{Aliases: map[string]string{"basemap": ""}},
+		{Overzoom: 13},
+		{Composites: map[string][]string{"basemap": {}}},
+		{CacheControl: CacheControlPolicy{"tiles": "no-cache"}},
+		{Archives: []ArchiveConfig{{CacheControl: CacheControlPolicy{"default": "no-cache"}}}},
+		{Archives: []ArchiveConfig{{Name: "a", Prefix: "a"}}},
+		{Archives: []ArchiveConfig{{Name: "a"}, {Name: "a"}}},
+		{Archives: []ArchiveConfig{{Prefix: "a"}, {Prefix: "a"}}},
+		{Archives: []ArchiveConfig{{Name: "a", PublicURL: "/tiles"}}},
+		{Archives: []ArchiveConfig{{Name: "a", Cors: []string{""}}}},
+		{Archives: []ArchiveConfig{{Name: "a", APIKeys: []string{""}}}},
+		{Auth: &AuthConfig{}},
+		{Auth: &AuthConfig{SigningKey: "short"}},
+		{Auth: &AuthConfig{APIKeys: []string{""}}},
+	} {
+		assert.NotNil(t, invalid.Validate(), "%+v", invalid)
+	}
+}
+
+func TestArchiveConfig(t *testing.T) {
+	mockBucket, server := newServer(t)
+	header := HeaderV3{TileType: Mvt, MaxZoom: 2}
+	for _, name := range []string{"basemap", "private/roads", "private/special/rail"} {
+		mockBucket.items[name+".pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+			{0, 0, 0}: {0, 1},
+		}, false, Gzip)
+	}
+	server.SetAliases(map[string]string{"current": "private/roads"})
+	server.SetArchiveConfigs([]ArchiveConfig{
+		{Name: "basemap", CacheControl: CacheControlPolicy{"default": "public, max-age=3600"}, Cors: []string{"https://maps.example.com"}},
+		{Prefix: "private/", PublicURL: "https://cdn.example.com"},
+		{Prefix: "private/special/", CacheControl: CacheControlPolicy{"default": "no-store"}},
+	})
+
+	statusCode, headers, _ := server.Get(context.Background(), "/basemap/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "public, max-age=3600", headers["Cache-Control"])
+	statusCode, headers, _ = server.Get(context.Background(), "/basemap/1/0/0.mvt")
+	assert.Equal(t, 204, statusCode)
+	assert.Equal(t, "public, max-age=3600", headers["Cache-Control"])
+	statusCode, headers, _ = server.Get(context.Background(), "/basemap/0/0/0.png")
+	assert.Equal(t, 400, statusCode)
+	assert.Equal(t, "", headers["Cache-Control"])
+
+	var tilejson map[string]interface{}
+	_, _, data := server.Get(context.Background(), "/current.json")
+	assert.Nil(t, json.Unmarshal(data, &tilejson))
+	assert.Equal(t, []interface{}{"https://cdn.example.com/private/roads/{z}/{x}/{y}.mvt"}, tilejson["tiles"])
+	_, headers, data = server.Get(context.Background(), "/private/special/rail.json")
+	assert.Nil(t, json.Unmarshal(data, &tilejson))
+	assert.Equal(t, []interface{}{"tiles.example.com/private/special/rail/{z}/{x}/{y}.mvt"}, tilejson["tiles"])
+	assert.Equal(t, "no-store", headers["Cache-Control"])
+	_, _, data = server.Get(context.Background(), "/basemap.json")
+	assert.Nil(t, json.Unmarshal(data, &tilejson))
+	assert.Equal(t, []interface{}{"tiles.example.com/basemap/{z}/{x}/{y}.mvt"}, tilejson["tiles"])
+
+	req := httptest.NewRequest("GET", "/basemap/0/0/0.mvt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Origin", "https://maps.example.com")
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	assert.Equal(t, 200, res.Code)
+	assert.Equal(t, "https://maps.example.com", res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, res.Header().Values("Vary"), "Origin")
+
+	req = httptest.NewRequest("GET", "/basemap/0/0/0.mvt", nil)
+	req.Header.Set("Origin", "https://other.example.com")
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	assert.Equal(t, "", res.Header().Get("Access-Control-Allow-Origin"))
+
+	req = httptest.NewRequest("GET", "/private/roads/0/0/0.mvt", nil)
+	req.Header.Set("Origin", "https://maps.example.com")
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	assert.Equal(t, "", res.Header().Get("Access-Control-Allow-Origin"))
+
+	// preflight requests are answered for archives with CORS origins, without global CORS
+	req = httptest.NewRequest("OPTIONS", "/basemap/0/0/0.mvt", nil)
+	req.Header.Set("Origin", "https://maps.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "authorization")
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	assert.Equal(t, 204, res.Code)
+	assert.Equal(t, "https://maps.example.com", res.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD", res.Header().Get("Access-Control-Allow-Methods"))
+	assert.Contains(t, res.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+
+	req.Header.Set("Origin", "https://other.example.com")
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	assert.Equal(t, 204, res.Code)
+	assert.Equal(t, "", res.Header().Get("Access-Control-Allow-Origin"))
+
+	req = httptest.NewRequest("OPTIONS", "/private/roads/0/0/0.mvt", nil)
+	req.Header.Set("Origin", "https://maps.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	assert.Equal(t, 405, res.Code)
+}
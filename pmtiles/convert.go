@@ -98,15 +98,62 @@ func newResolver(deduplicate bool, compress bool) *resolver {
 	return &r
 }
 
-// Convert an existing archive on disk to a new PMTiles specification version 3 archive.
+// Convert an existing archive on disk to a new PMTiles specification version 3 archive,
+// or a PMTiles archive to MBTiles if the output ends in .mbtiles.
+// The input may also be a directory of {z}/{x}/{y}.ext tiles.
 func Convert(logger *log.Logger, input string, output string, deduplicate bool, tmpfile *os.File) error {
-	return convertMbtiles(logger, input, output, deduplicate, tmpfile)
+	return ConvertWithCompression(logger, input, output, deduplicate, tmpfile, "", "")
+}
+
+// ConvertWithCompression is like Convert, but sets the tile and internal compressions of the output archive.
+// Compressions are one of none, gzip, br or zstd; an empty string uses gzip.
+// Tile compression only applies to vector tiles.
+func ConvertWithCompression(logger *log.Logger, input string, output string, deduplicate bool, tmpfile *os.File, tileCompressionString string, internalCompressionString string) error {
+	tileCompression, err := parseCompressionOption(tileCompressionString)
+	if err != nil {
+		return err
+	}
+	internalCompression, err := parseCompressionOption(internalCompressionString)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(input); err == nil && info.IsDir() {
+		return convertDir(logger, input, output, deduplicate, tmpfile, tileCompression, internalCompression)
+	}
+	if strings.HasSuffix(input, ".pmtiles") && strings.HasSuffix(output, ".mbtiles") {
+		if tileCompression != UnknownCompression || internalCompression != UnknownCompression {
+			return fmt.Errorf("compression options are not supported for MBTiles output")
+		}
+		return exportMbtiles(logger, input, output, deduplicate)
+	}
+	return convertMbtiles(logger, input, output, deduplicate, tmpfile, tileCompression, internalCompression)
+}
+
+// setOutputCompression applies compression options to the header of a converted archive.
+// Vector tiles default to gzip; other tile types are stored uncompressed.
+func setOutputCompression(header *HeaderV3, tileCompression Compression, internalCompression Compression) error {
+	if header.TileType == Mvt {
+		header.TileCompression = Gzip
+		if tileCompression != UnknownCompression {
+			header.TileCompression = tileCompression
+		}
+	} else if tileCompression != UnknownCompression && tileCompression != NoCompression {
+		return fmt.Errorf("tile compression is only supported for vector tiles")
+	}
+	header.InternalCompression = internalCompression
+	return nil
 }
 
 func setZoomCenterDefaults(header *HeaderV3, entries []EntryV3) {
 	minZ, _, _ := IDToZxy(entries[0].TileID)
 	header.MinZoom = minZ
-	maxZ, _, _ := IDToZxy(entries[len(entries)-1].TileID)
+	lastEntry := entries[len(entries)-1]
+	lastID := lastEntry.TileID
+	if lastEntry.RunLength > 1 {
+		lastID += uint64(lastEntry.RunLength) - 1
+	}
+	maxZ, _, _ := IDToZxy(lastID)
 	header.MaxZoom = maxZ
 
 	if header.CenterZoom == 0 && header.CenterLonE7 == 0 && header.CenterLatE7 == 0 {
@@ -116,7 +163,7 @@ func setZoomCenterDefaults(header *HeaderV3, entries []EntryV3) {
 	}
 }
 
-func convertMbtiles(logger *log.Logger, input string, output string, deduplicate bool, tmpfile *os.File) error {
+func convertMbtiles(logger *log.Logger, input string, output string, deduplicate bool, tmpfile *os.File, tileCompression Compression, internalCompression Compression) error {
 	start := time.Now()
 	conn, err := sqlite.OpenConn(input, sqlite.OpenReadOnly)
 	if err != nil {
@@ -154,6 +201,9 @@ func convertMbtiles(logger *log.Logger, input string, output string, deduplicate
 	if err != nil {
 		return fmt.Errorf("Failed to convert MBTiles to header JSON, %w", err)
 	}
+	if err := setOutputCompression(&header, tileCompression, internalCompression); err != nil {
+		return err
+	}
 
 	logger.Println("Pass 1: Assembling TileID set")
 	// assemble a sorted set of all TileIds
@@ -187,7 +237,9 @@ func convertMbtiles(logger *log.Logger, input string, output string, deduplicate
 	}
 
 	logger.Println("Pass 2: writing tiles")
-	resolve := newResolver(deduplicate, header.TileType == Mvt)
+	// gzip is applied by the resolver, other compressions per tile
+	recompress := header.TileType == Mvt && header.TileCompression != Gzip
+	resolve := newResolver(deduplicate, header.TileType == Mvt && !recompress)
 	{
 		bar := defaultProgressbar(logger, int64(tileset.GetCardinality()))
 		i := tileset.Iterator()
@@ -217,6 +269,13 @@ func convertMbtiles(logger *log.Logger, input string, output string, deduplicate
 			rawTileTmp.ReadFrom(reader)
 			data := rawTileTmp.Bytes()
 
+			if len(data) > 0 && recompress {
+				data, err = encodeTile(data, header.TileCompression)
+				if err != nil {
+					return fmt.Errorf("Failed to compress tile %d %d %d, %w", z, x, y, err)
+				}
+			}
+
 			if len(data) > 0 {
 				if isNew, newData := resolve.AddTileIsNew(id, data, 1); isNew {
 					_, err := tmpfile.Write(newData)
@@ -255,7 +314,14 @@ func finalize(logger *log.Logger, resolve *resolver, header HeaderV3, tmpfile *o
 	}
 	defer outfile.Close()
 
-	rootBytes, leavesBytes, numLeaves := BuildDirectories(resolve.Entries, 16384-HeaderV3LenBytes, Gzip)
+	if header.InternalCompression == UnknownCompression {
+		header.InternalCompression = Gzip
+	}
+	if header.TileType == Mvt && header.TileCompression == UnknownCompression {
+		header.TileCompression = Gzip
+	}
+
+	rootBytes, leavesBytes, numLeaves := BuildDirectories(resolve.Entries, 16384-HeaderV3LenBytes, header.InternalCompression)
 
 	if numLeaves > 0 {
 		logger.Println("Root dir bytes: ", len(rootBytes))
@@ -269,7 +335,7 @@ func finalize(logger *log.Logger, resolve *resolver, header HeaderV3, tmpfile *o
 		logger.Printf("Average bytes per addressed tile: %.2f\n", float64(len(rootBytes))/float64(resolve.AddressedTiles))
 	}
 
-	metadataBytes, err := SerializeMetadata(jsonMetadata, Gzip)
+	metadataBytes, err := SerializeMetadata(jsonMetadata, header.InternalCompression)
 
 	if err != nil {
 		return header, fmt.Errorf("Failed to marshal metadata, %w", err)
@@ -278,10 +344,6 @@ func finalize(logger *log.Logger, resolve *resolver, header HeaderV3, tmpfile *o
 	setZoomCenterDefaults(&header, resolve.Entries)
 
 	header.Clustered = true
-	header.InternalCompression = Gzip
-	if header.TileType == Mvt {
-		header.TileCompression = Gzip
-	}
 
 	header.RootOffset = HeaderV3LenBytes
 	header.RootLength = uint64(len(rootBytes))
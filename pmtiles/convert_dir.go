@@ -0,0 +1,272 @@
+package pmtiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type dirTile struct {
+	TileID uint64
+	Path   string
+}
+
+func extToTileType(ext string) TileType {
+	switch strings.ToLower(ext) {
+	case ".mvt", ".pbf":
+		return Mvt
+	case ".png":
+		return Png
+	case ".jpg", ".jpeg":
+		return Jpeg
+	case ".webp":
+		return Webp
+	case ".avif":
+		return Avif
+	case ".mlt":
+		return Mlt
+	}
+	return UnknownTileType
+}
+
+func isGzip(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// magicToTileType detects raster tile types by their leading bytes.
+// Gzip-compressed data is assumed to be vector tiles.
+func magicToTileType(data []byte) TileType {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x89, 'P', 'N', 'G'}):
+		return Png
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return Jpeg
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return Webp
+	case len(data) >= 12 && string(data[4:12]) == "ftypavif":
+		return Avif
+	case isGzip(data):
+		return Mvt
+	}
+	return UnknownTileType
+}
+
+// parseDirTilePath parses a path of the form z/x/y.ext relative to the root of a tile directory.
+func parseDirTilePath(rel string) (uint8, uint32, uint32, string, bool) {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 3 {
+		return 0, 0, 0, "", false
+	}
+	ext := filepath.Ext(parts[2])
+	z, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil || z > 31 {
+		return 0, 0, 0, "", false
+	}
+	x, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, 0, "", false
+	}
+	y, err := strconv.ParseUint(strings.TrimSuffix(parts[2], ext), 10, 32)
+	if err != nil {
+		return 0, 0, 0, "", false
+	}
+	if x >= 1<<z || y >= 1<<z {
+		return 0, 0, 0, "", false
+	}
+	return uint8(z), uint32(x), uint32(y), ext, true
+}
+
+// dirMetadataToMbtiles flattens a metadata.json, in either MBTiles (string values)
+// or TileJSON (array bounds and center) form, into MBTiles name, value pairs.
+func dirMetadataToMbtiles(metadata map[string]interface{}) ([]string, error) {
+	result := make([]string, 0)
+	nested := make(map[string]interface{})
+
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch v := metadata[k].(type) {
+		case string:
+			result = append(result, k, v)
+		case float64:
+			result = append(result, k, strconv.FormatFloat(v, 'f', -1, 64))
+		case []interface{}:
+			if k == "bounds" || k == "center" {
+				parts := make([]string, 0, len(v))
+				for _, n := range v {
+					parts = append(parts, fmt.Sprint(n))
+				}
+				result = append(result, k, strings.Join(parts, ","))
+			} else {
+				nested[k] = v
+			}
+		default:
+			nested[k] = v
+		}
+	}
+
+	if len(nested) > 0 {
+		jsonBytes, err := json.Marshal(nested)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, "json", string(jsonBytes))
+	}
+	return result, nil
+}
+
+func convertDir(logger *log.Logger, input string, output string, deduplicate bool, tmpfile *os.File, tileCompression Compression, internalCompression Compression) error {
+	start := time.Now()
+
+	mbtilesMetadata := make([]string, 0)
+	flipY := false
+	metadataBytes, err := os.ReadFile(filepath.Join(input, "metadata.json"))
+	if err == nil {
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
+			return fmt.Errorf("Failed to parse metadata.json, %w", err)
+		}
+		if scheme, ok := metadata["scheme"].(string); ok && scheme == "tms" {
+			flipY = true
+		}
+		mbtilesMetadata, err = dirMetadataToMbtiles(metadata)
+		if err != nil {
+			return fmt.Errorf("Failed to convert metadata.json, %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to read metadata.json, %w", err)
+	}
+
+	header, jsonMetadata, err := mbtilesToHeaderJSON(mbtilesMetadata)
+	if err != nil {
+		return fmt.Errorf("Failed to convert metadata to header JSON, %w", err)
+	}
+
+	logger.Println("Pass 1: Assembling TileID set")
+	tiles := make([]dirTile, 0)
+	tileType := TileType(UnknownTileType)
+	err = filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(input, path)
+		if err != nil {
+			return err
+		}
+		z, x, y, ext, ok := parseDirTilePath(rel)
+		if !ok {
+			return nil
+		}
+
+		detected := extToTileType(ext)
+		if detected == UnknownTileType || tileType == UnknownTileType {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if len(data) == 0 {
+				return nil
+			}
+			if detected == UnknownTileType {
+				detected = magicToTileType(data)
+			}
+		}
+		if detected == UnknownTileType {
+			return fmt.Errorf("could not detect tile type of %s", rel)
+		}
+		if tileType == UnknownTileType {
+			tileType = detected
+		} else if detected != tileType {
+			return fmt.Errorf("%s has tile type %s, expected %s", rel, tileTypeToString(detected), tileTypeToString(tileType))
+		}
+
+		if flipY {
+			y = (1 << z) - 1 - y
+		}
+		tiles = append(tiles, dirTile{ZxyToID(z, x, y), path})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to walk %s, %w", input, err)
+	}
+
+	if len(tiles) == 0 {
+		return fmt.Errorf("no tiles in directory %s", input)
+	}
+
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i].TileID < tiles[j].TileID
+	})
+	for i := 1; i < len(tiles); i++ {
+		if tiles[i].TileID == tiles[i-1].TileID {
+			return fmt.Errorf("%s and %s address the same tile", tiles[i-1].Path, tiles[i].Path)
+		}
+	}
+
+	if header.TileType != UnknownTileType && header.TileType != tileType {
+		logger.Printf("WARNING: metadata format is %s but tiles are %s", tileTypeToString(header.TileType), tileTypeToString(tileType))
+	}
+	header.TileType = tileType
+	if tileType != Mvt {
+		header.TileCompression = NoCompression
+	}
+	if err := setOutputCompression(&header, tileCompression, internalCompression); err != nil {
+		return err
+	}
+
+	logger.Println("Pass 2: writing tiles")
+	// gzip is applied by the resolver, other compressions per tile
+	recompress := header.TileType == Mvt && header.TileCompression != Gzip
+	resolve := newResolver(deduplicate, header.TileType == Mvt && !recompress)
+	{
+		bar := defaultProgressbar(logger, int64(len(tiles)))
+		for _, tile := range tiles {
+			data, err := os.ReadFile(tile.Path)
+			if err != nil {
+				return fmt.Errorf("Failed to read %s, %w", tile.Path, err)
+			}
+			if len(data) > 0 && recompress {
+				data, err = encodeTile(data, header.TileCompression)
+				if err != nil {
+					return fmt.Errorf("Failed to compress %s, %w", tile.Path, err)
+				}
+			}
+
+			if len(data) > 0 {
+				if isNew, newData := resolve.AddTileIsNew(tile.TileID, data, 1); isNew {
+					_, err := tmpfile.Write(newData)
+					if err != nil {
+						return fmt.Errorf("Failed to write to tempfile: %s", err)
+					}
+				}
+			}
+			bar.Add(1)
+		}
+	}
+
+	if len(resolve.Entries) == 0 {
+		return fmt.Errorf("no tiles in directory %s", input)
+	}
+
+	_, err = finalize(logger, resolve, header, tmpfile, output, jsonMetadata)
+	if err != nil {
+		return err
+	}
+	logger.Println("Finished in ", time.Since(start))
+	return nil
+}
@@ -0,0 +1,113 @@
+package pmtiles
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeDirTile(t *testing.T, root string, rel string, data []byte) {
+	path := filepath.Join(root, rel)
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+}
+
+func TestParseDirTilePath(t *testing.T) {
+	z, x, y, ext, ok := parseDirTilePath("3/2/1.png")
+	assert.True(t, ok)
+	assert.Equal(t, uint8(3), z)
+	assert.Equal(t, uint32(2), x)
+	assert.Equal(t, uint32(1), y)
+	assert.Equal(t, ".png", ext)
+
+	_, _, _, _, ok = parseDirTilePath("1/2/0.png")
+	assert.False(t, ok)
+	_, _, _, _, ok = parseDirTilePath("metadata.json")
+	assert.False(t, ok)
+	_, _, _, _, ok = parseDirTilePath("a/0/0.png")
+	assert.False(t, ok)
+}
+
+func TestMagicToTileType(t *testing.T) {
+	assert.Equal(t, TileType(Png), magicToTileType([]byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a}))
+	assert.Equal(t, TileType(Jpeg), magicToTileType([]byte{0xff, 0xd8, 0xff, 0xe0}))
+	assert.Equal(t, TileType(Webp), magicToTileType([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")))
+	assert.Equal(t, TileType(Mvt), magicToTileType([]byte{0x1f, 0x8b, 0x08}))
+	assert.Equal(t, TileType(UnknownTileType), magicToTileType([]byte{0x1a, 0x00}))
+}
+
+func TestConvertDir(t *testing.T) {
+	input := t.TempDir()
+	png := []byte{0x89, 'P', 'N', 'G', 0x1}
+	writeDirTile(t, input, "0/0/0.png", png)
+	writeDirTile(t, input, "1/0/0.png", png)
+	writeDirTile(t, input, "1/1/0.png", []byte{0x89, 'P', 'N', 'G', 0x2})
+	writeDirTile(t, input, "metadata.json", []byte(`{"name":"dir","scheme":"tms","bounds":[-10,-10,10,10],"center":[0,0,1]}`))
+
+	output := filepath.Join(t.TempDir(), "dir.pmtiles")
+	tmpfile, _ := os.CreateTemp(t.TempDir(), "pmtiles")
+	err := Convert(logger, input, output, true, tmpfile)
+	assert.Nil(t, err)
+
+	reader := readerForFile(t, output)
+	header := reader.Header()
+	assert.Equal(t, TileType(Png), header.TileType)
+	assert.Equal(t, Compression(NoCompression), header.TileCompression)
+	assert.Equal(t, uint64(3), header.AddressedTilesCount)
+	assert.Equal(t, uint64(2), header.TileContentsCount)
+	assert.Equal(t, int32(-10*10000000), header.MinLonE7)
+	assert.Equal(t, uint8(1), header.CenterZoom)
+
+	ctx := context.Background()
+	data, ok, err := reader.GetTile(ctx, 1, 1, 1)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0x89, 'P', 'N', 'G', 0x2}, data)
+
+	metadata, err := reader.Metadata(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "dir", metadata["name"])
+}
+
+func TestConvertDirMixedTypes(t *testing.T) {
+	input := t.TempDir()
+	writeDirTile(t, input, "0/0/0.png", []byte{0x89, 'P', 'N', 'G'})
+	writeDirTile(t, input, "1/0/0.jpg", []byte{0xff, 0xd8, 0xff})
+
+	output := filepath.Join(t.TempDir(), "dir.pmtiles")
+	tmpfile, _ := os.CreateTemp(t.TempDir(), "pmtiles")
+	err := Convert(logger, input, output, true, tmpfile)
+	assert.NotNil(t, err)
+}
+
+func TestConvertDirCompression(t *testing.T) {
+	input := t.TempDir()
+	raw := []byte{0x1a, 0x2, 0x3}
+	gzipped, _ := compressBytes([]byte{0x1a, 0x4}, Gzip)
+	writeDirTile(t, input, "0/0/0.mvt", raw)
+	writeDirTile(t, input, "1/0/0.mvt", gzipped)
+
+	output := filepath.Join(t.TempDir(), "dir.pmtiles")
+	tmpfile, _ := os.CreateTemp(t.TempDir(), "pmtiles")
+	err := ConvertWithCompression(logger, input, output, true, tmpfile, "zstd", "br")
+	assert.Nil(t, err)
+
+	reader := readerForFile(t, output)
+	assert.Equal(t, Compression(Zstd), reader.Header().TileCompression)
+	assert.Equal(t, Compression(Brotli), reader.Header().InternalCompression)
+
+	ctx := context.Background()
+	data, _, _ := reader.GetTile(ctx, 0, 0, 0)
+	decompressed, err := decompressBytes(data, Zstd)
+	assert.Nil(t, err)
+	assert.Equal(t, raw, decompressed)
+	data, _, _ = reader.GetTile(ctx, 1, 0, 0)
+	decompressed, err = decompressBytes(data, Zstd)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x1a, 0x4}, decompressed)
+
+	err = ConvertWithCompression(logger, input, filepath.Join(t.TempDir(), "x.pmtiles"), true, tmpfile, "lzma", "")
+	assert.NotNil(t, err)
+}
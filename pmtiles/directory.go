@@ -3,7 +3,6 @@ package pmtiles
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -206,12 +205,6 @@ type EntryV3 struct {
 	RunLength uint32
 }
 
-type nopWriteCloser struct {
-	*bytes.Buffer
-}
-
-func (w *nopWriteCloser) Close() error { return nil }
-
 func SerializeMetadata(metadata map[string]interface{}, compression Compression) ([]byte, error) {
 	jsonBytes, err := json.Marshal(metadata)
 	if err != nil {
@@ -220,44 +213,17 @@ func SerializeMetadata(metadata map[string]interface{}, compression Compression)
 
 	if compression == NoCompression {
 		return jsonBytes, nil
-	} else if compression == Gzip {
-		var b bytes.Buffer
-		w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
-		if err != nil {
-			return nil, err
-		}
-		w.Write(jsonBytes)
-		w.Close()
-		return b.Bytes(), nil
-	} else {
-		return nil, errors.New("compression not supported")
 	}
+	return compressBytes(jsonBytes, compression)
 }
 
 func DeserializeMetadataBytes(reader io.Reader, compression Compression) ([]byte, error) {
-	var jsonBytes []byte
-	var err error
-
-	if compression == NoCompression {
-		jsonBytes, err = io.ReadAll(reader)
-		if err != nil {
-			return nil, err
-		}
-	} else if compression == Gzip {
-		gzipReader, err := gzip.NewReader(reader)
-		if err != nil {
-			return nil, err
-		}
-		jsonBytes, err = io.ReadAll(gzipReader)
-		if err != nil {
-			return nil, err
-		}
-		gzipReader.Close()
-	} else {
-		return nil, errors.New("compression not supported")
+	decompressed, err := newDecompressionReader(reader, compression)
+	if err != nil {
+		return nil, err
 	}
-
-	return jsonBytes, nil
+	defer decompressed.Close()
+	return io.ReadAll(decompressed)
 }
 
 func DeserializeMetadata(reader io.Reader, compression Compression) (map[string]interface{}, error) {
@@ -274,14 +240,10 @@ func DeserializeMetadata(reader io.Reader, compression Compression) (map[string]
 
 func SerializeEntries(entries []EntryV3, compression Compression) []byte {
 	var b bytes.Buffer
-	var w io.WriteCloser
 
 	tmp := make([]byte, binary.MaxVarintLen64)
-	if compression == NoCompression {
-		w = &nopWriteCloser{&b}
-	} else if compression == Gzip {
-		w, _ = gzip.NewWriterLevel(&b, gzip.BestCompression)
-	} else {
+	w, err := newCompressionWriter(&b, compression)
+	if err != nil {
 		panic("Compression not supported")
 	}
 
@@ -325,15 +287,13 @@ func SerializeEntries(entries []EntryV3, compression Compression) []byte {
 func DeserializeEntries(data *bytes.Buffer, compression Compression) []EntryV3 {
 	entries := make([]EntryV3, 0)
 
-	var reader io.Reader
-
-	if compression == NoCompression {
-		reader = data
-	} else if compression == Gzip {
-		reader, _ = gzip.NewReader(data)
-	} else {
+	reader, err := newDecompressionReader(data, compression)
+	if errors.Is(err, errUnsupportedCompression) {
 		panic("Compression not supported")
+	} else if err != nil {
+		return entries
 	}
+	defer reader.Close()
 	byteReader := bufio.NewReader(reader)
 
 	numEntries, _ := binary.ReadUvarint(byteReader)
@@ -534,7 +494,10 @@ func IterateEntries(header HeaderV3, fetch func(uint64, uint64) ([]byte, error),
 			if entry.RunLength > 0 {
 				operation(entry)
 			} else {
-				CollectEntries(header.LeafDirectoryOffset+entry.Offset, uint64(entry.Length))
+				err := CollectEntries(header.LeafDirectoryOffset+entry.Offset, uint64(entry.Length))
+				if err != nil {
+					return err
+				}
 			}
 		}
 		return nil
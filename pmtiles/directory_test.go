@@ -322,3 +322,26 @@ func TestMetadataRoundtrip(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "bar", newData["foo"])
 }
+
+func TestDirectoryRoundtripBrotliZstd(t *testing.T) {
+	entries := []EntryV3{{0, 0, 1, 1}, {1, 1, 1, 1}, {5, 2, 2, 3}}
+
+	for _, compression := range []Compression{Brotli, Zstd} {
+		serialized := SerializeEntries(entries, compression)
+		result := DeserializeEntries(bytes.NewBuffer(serialized), compression)
+		assert.Equal(t, entries, result)
+	}
+}
+
+func TestMetadataRoundtripBrotliZstd(t *testing.T) {
+	data := map[string]interface{}{
+		"foo": "bar",
+	}
+	for _, compression := range []Compression{Brotli, Zstd} {
+		b, err := SerializeMetadata(data, compression)
+		assert.Nil(t, err)
+		newData, err := DeserializeMetadata(bytes.NewReader(b), compression)
+		assert.Nil(t, err)
+		assert.Equal(t, "bar", newData["foo"])
+	}
+}
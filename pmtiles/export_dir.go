@@ -0,0 +1,261 @@
+package pmtiles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+)
+
+// tileSink receives the files of an exported tile tree.
+type tileSink interface {
+	Write(name string, data []byte) error
+	Close() error
+}
+
+type dirSink struct {
+	root string
+}
+
+func (s *dirSink) Write(name string, data []byte) error {
+	path := filepath.Join(s.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (s *dirSink) Close() error {
+	return nil
+}
+
+type tarSink struct {
+	file   *os.File
+	writer *tar.Writer
+}
+
+func (s *tarSink) Write(name string, data []byte) error {
+	err := s.writer.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write(data)
+	return err
+}
+
+func (s *tarSink) Close() error {
+	if err := s.writer.Close(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+type zipSink struct {
+	file   *os.File
+	writer *zip.Writer
+}
+
+func (s *zipSink) Write(name string, data []byte) error {
+	// tiles are usually compressed already, so store them as-is
+	w, err := s.writer.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *zipSink) Close() error {
+	if err := s.writer.Close(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// newTileSink picks a tar or zip bundle by the output extension, or a plain directory otherwise.
+func newTileSink(output string) (tileSink, error) {
+	if _, err := os.Stat(output); err == nil {
+		return nil, fmt.Errorf("%s already exists", output)
+	}
+
+	switch {
+	case strings.HasSuffix(output, ".tar"):
+		file, err := os.Create(output)
+		if err != nil {
+			return nil, err
+		}
+		return &tarSink{file, tar.NewWriter(file)}, nil
+	case strings.HasSuffix(output, ".zip"):
+		file, err := os.Create(output)
+		if err != nil {
+			return nil, err
+		}
+		return &zipSink{file, zip.NewWriter(file)}, nil
+	default:
+		if err := os.MkdirAll(output, 0755); err != nil {
+			return nil, err
+		}
+		return &dirSink{output}, nil
+	}
+}
+
+// ExportDir writes every tile of an archive to a {z}/{x}/{y}.ext tree in a directory, .tar or .zip file,
+// along with metadata.json and a TileJSON tiles.json.
+// Tiles are written as stored in the archive, so vector tiles are usually gzip-compressed.
+// The output is removed if the export fails.
+func ExportDir(logger *log.Logger, bucketURL string, key string, output string, minzoom int8, maxzoom int8, regionFile string, bbox string, publicURL string) (err error) {
+	start := time.Now()
+	ctx := context.Background()
+
+	bucketURL, key, err = NormalizeBucketKey(bucketURL, "", key)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := OpenBucket(ctx, bucketURL, "")
+	if err != nil {
+		return fmt.Errorf("Failed to open bucket for %s, %w", bucketURL, err)
+	}
+	defer bucket.Close()
+
+	reader, err := NewReader(ctx, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	header := reader.Header()
+
+	if minzoom == -1 || int8(header.MinZoom) > minzoom {
+		minzoom = int8(header.MinZoom)
+	}
+
+	if maxzoom == -1 || int8(header.MaxZoom) < maxzoom {
+		maxzoom = int8(header.MaxZoom)
+	}
+
+	if minzoom > maxzoom {
+		return fmt.Errorf("minzoom cannot be greater than maxzoom")
+	}
+
+	var relevantSet *roaring64.Bitmap
+	if regionFile != "" || bbox != "" {
+		multipolygon, err := loadRegion(regionFile, bbox)
+		if err != nil {
+			return err
+		}
+		relevantSet = regionBitmap(multipolygon, uint8(minzoom), uint8(maxzoom))
+
+		bound := multipolygon.Bound()
+		header.MinLonE7 = int32(bound.Left() * 10000000)
+		header.MinLatE7 = int32(bound.Bottom() * 10000000)
+		header.MaxLonE7 = int32(bound.Right() * 10000000)
+		header.MaxLatE7 = int32(bound.Top() * 10000000)
+		header.CenterLonE7 = int32(bound.Center().X() * 10000000)
+		header.CenterLatE7 = int32(bound.Center().Y() * 10000000)
+	} else {
+		relevantSet = roaring64.New()
+		relevantSet.AddRange(ZxyToID(uint8(minzoom), 0, 0), ZxyToID(uint8(maxzoom)+1, 0, 0))
+	}
+	header.MinZoom = uint8(minzoom)
+	header.MaxZoom = uint8(maxzoom)
+	if header.CenterZoom < header.MinZoom || header.CenterZoom > header.MaxZoom {
+		header.CenterZoom = header.MinZoom
+	}
+
+	metadataBytes, err := reader.MetadataBytes(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to read metadata, %w", err)
+	}
+
+	if publicURL == "" {
+		logger.Println("no --public-url specified; using placeholder tiles URL in tiles.json")
+	}
+	tilejsonBytes, err := CreateTileJSON(header, metadataBytes, publicURL)
+	if err != nil {
+		return fmt.Errorf("Failed to create tilejson, %w", err)
+	}
+
+	sink, err := newTileSink(output)
+	if err != nil {
+		return fmt.Errorf("Failed to create %s, %w", output, err)
+	}
+	defer func() {
+		if err != nil {
+			sink.Close()
+			os.RemoveAll(output)
+		}
+	}()
+
+	if err := sink.Write("metadata.json", metadataBytes); err != nil {
+		return fmt.Errorf("Failed to write metadata.json, %w", err)
+	}
+	if err := sink.Write("tiles.json", tilejsonBytes); err != nil {
+		return fmt.Errorf("Failed to write tiles.json, %w", err)
+	}
+
+	ext := headerExt(header)
+	bar := defaultProgressbar(logger, int64(relevantSet.GetCardinality()))
+	var written uint64
+	var writeErr error
+
+	err = reader.IterateEntries(ctx, func(e EntryV3) {
+		if writeErr != nil {
+			return
+		}
+
+		var data []byte
+		for id := e.TileID; id < e.TileID+uint64(e.RunLength); id++ {
+			if !relevantSet.Contains(id) {
+				continue
+			}
+			if data == nil {
+				data, writeErr = reader.readRange(ctx, header.TileDataOffset+e.Offset, uint64(e.Length))
+				if writeErr != nil {
+					return
+				}
+				if len(data) != int(e.Length) {
+					writeErr = fmt.Errorf("tile data is truncated, expected %d bytes, got %d", e.Length, len(data))
+					return
+				}
+			}
+			z, x, y := IDToZxy(id)
+			name := strconv.Itoa(int(z)) + "/" + strconv.FormatUint(uint64(x), 10) + "/" + strconv.FormatUint(uint64(y), 10) + ext
+			if writeErr = sink.Write(name, data); writeErr != nil {
+				return
+			}
+			written++
+			bar.Add(1)
+		}
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write tiles, %w", err)
+	}
+
+	if err := sink.Close(); err != nil {
+		return fmt.Errorf("Failed to finish %s, %w", output, err)
+	}
+
+	logger.Printf("Wrote %d tiles to %s in %v", written, output, time.Since(start))
+	return nil
+}
@@ -0,0 +1,76 @@
+package pmtiles
+
+import (
+	"archive/zip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportDirRoundtrip(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "tiles")
+	err := ExportDir(logger, "", "fixtures/test_fixture_1.pmtiles", output, -1, -1, "", "", "https://example.com/tiles")
+	assert.Nil(t, err)
+
+	_, err = os.Stat(filepath.Join(output, "0", "0", "0.mvt"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(output, "metadata.json"))
+	assert.Nil(t, err)
+	tilejson, err := os.ReadFile(filepath.Join(output, "tiles.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(tilejson), "https://example.com/tiles/{z}/{x}/{y}.mvt")
+
+	err = ExportDir(logger, "", "fixtures/test_fixture_1.pmtiles", output, -1, -1, "", "", "")
+	assert.NotNil(t, err)
+
+	roundtrip := filepath.Join(t.TempDir(), "roundtrip.pmtiles")
+	tmpfile, _ := os.CreateTemp(t.TempDir(), "pmtiles")
+	err = Convert(logger, output, roundtrip, true, tmpfile)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	original := readerForFile(t, "fixtures/test_fixture_1.pmtiles")
+	converted := readerForFile(t, roundtrip)
+	assert.Equal(t, original.Header().AddressedTilesCount, converted.Header().AddressedTilesCount)
+	assert.Equal(t, original.Header().TileType, converted.Header().TileType)
+
+	metadata, err := converted.Metadata(ctx)
+	assert.Nil(t, err)
+	assert.NotNil(t, metadata["vector_layers"])
+}
+
+func TestExportDirZipMaxzoom(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "tiles.zip")
+	err := ExportDir(logger, "", "fixtures/test_fixture_1.pmtiles", output, 0, 0, "", "", "")
+	assert.Nil(t, err)
+
+	archive, err := zip.OpenReader(output)
+	assert.Nil(t, err)
+	defer archive.Close()
+	names := make([]string, 0)
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"metadata.json", "tiles.json", "0/0/0.mvt"}, names)
+}
+
+func TestExportDirRemovesOutputOnError(t *testing.T) {
+	tmp := t.TempDir()
+	data, err := os.ReadFile("fixtures/test_fixture_1.pmtiles")
+	assert.Nil(t, err)
+	header, err := DeserializeHeader(data[0:HeaderV3LenBytes])
+	assert.Nil(t, err)
+	// cut off the tile data so reading tiles fails
+	truncatedPath := filepath.Join(tmp, "truncated.pmtiles")
+	assert.Nil(t, os.WriteFile(truncatedPath, data[:header.TileDataOffset], 0644))
+
+	for _, name := range []string{"tiles", "tiles.tar", "tiles.zip"} {
+		output := filepath.Join(tmp, name)
+		err = ExportDir(logger, "", truncatedPath, output, -1, -1, "", "", "")
+		assert.NotNil(t, err, name)
+		_, err = os.Stat(output)
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
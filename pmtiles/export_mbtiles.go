@@ -0,0 +1,247 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+const mbtilesSchema = `
+CREATE TABLE metadata (name TEXT, value TEXT);
+CREATE UNIQUE INDEX name ON metadata (name);
+CREATE TABLE tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB);
+CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row);
+`
+
+// the deduplicated schema stores each distinct tile once in images,
+// and exposes the standard tiles table as a view.
+const mbtilesDeduplicatedSchema = `
+CREATE TABLE metadata (name TEXT, value TEXT);
+CREATE UNIQUE INDEX name ON metadata (name);
+CREATE TABLE map (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_id TEXT);
+CREATE UNIQUE INDEX map_index ON map (zoom_level, tile_column, tile_row);
+CREATE TABLE images (tile_data BLOB, tile_id TEXT);
+CREATE UNIQUE INDEX images_id ON images (tile_id);
+CREATE VIEW tiles AS SELECT map.zoom_level AS zoom_level, map.tile_column AS tile_column, map.tile_row AS tile_row, images.tile_data AS tile_data FROM map JOIN images ON images.tile_id = map.tile_id;
+`
+
+func formatE7(v int32) string {
+	return strconv.FormatFloat(float64(v)/10000000, 'f', -1, 64)
+}
+
+// headerJSONToMbtiles is the inverse of mbtilesToHeaderJSON:
+// it returns name, value pairs for the MBTiles metadata table.
+func headerJSONToMbtiles(header HeaderV3, jsonMetadata map[string]interface{}) ([]string, error) {
+	result := make([]string, 0)
+
+	format := ""
+	switch header.TileType {
+	case Mvt:
+		format = "pbf"
+	case Png, Jpeg, Webp, Avif:
+		format = tileTypeToString(header.TileType)
+	default:
+		return nil, fmt.Errorf("tile type %s is not supported in MBTiles", tileTypeToString(header.TileType))
+	}
+	result = append(result, "format", format)
+	result = append(result, "bounds", formatE7(header.MinLonE7)+","+formatE7(header.MinLatE7)+","+formatE7(header.MaxLonE7)+","+formatE7(header.MaxLatE7))
+	result = append(result, "center", formatE7(header.CenterLonE7)+","+formatE7(header.CenterLatE7)+","+strconv.Itoa(int(header.CenterZoom)))
+	result = append(result, "minzoom", strconv.Itoa(int(header.MinZoom)))
+	result = append(result, "maxzoom", strconv.Itoa(int(header.MaxZoom)))
+
+	keys := make([]string, 0, len(jsonMetadata))
+	for k := range jsonMetadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	nested := make(map[string]interface{})
+	for _, k := range keys {
+		switch k {
+		case "format", "bounds", "center", "minzoom", "maxzoom", "scheme", "json":
+			// derived from the header
+			continue
+		}
+		if s, ok := jsonMetadata[k].(string); ok {
+			result = append(result, k, s)
+		} else {
+			nested[k] = jsonMetadata[k]
+		}
+	}
+
+	if len(nested) > 0 {
+		jsonBytes, err := json.Marshal(nested)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, "json", string(jsonBytes))
+	}
+
+	return result, nil
+}
+
+func exportMbtiles(logger *log.Logger, input string, output string, deduplicate bool) (err error) {
+	start := time.Now()
+	ctx := context.Background()
+
+	bucketURL, key, err := NormalizeBucketKey("", "", input)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := OpenBucket(ctx, bucketURL, "")
+	if err != nil {
+		return fmt.Errorf("Failed to open bucket for %s, %w", bucketURL, err)
+	}
+	defer bucket.Close()
+
+	reader, err := NewReader(ctx, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	header := reader.Header()
+
+	if header.TileCompression != NoCompression && header.TileCompression != Gzip {
+		compression, _ := compressionToString(header.TileCompression)
+		return fmt.Errorf("tile compression %s is not supported in MBTiles", compression)
+	}
+
+	jsonMetadata, err := reader.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to read metadata, %w", err)
+	}
+
+	mbtilesMetadata, err := headerJSONToMbtiles(header, jsonMetadata)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		return fmt.Errorf("%s already exists", output)
+	}
+
+	conn, err := sqlite.OpenConn(output, sqlite.OpenReadWrite, sqlite.OpenCreate)
+	if err != nil {
+		return fmt.Errorf("Failed to create database connection, %w", err)
+	}
+	// don't leave a partially written database behind
+	defer func() {
+		conn.Close()
+		if err != nil {
+			os.Remove(output)
+		}
+	}()
+
+	schema := mbtilesSchema
+	if deduplicate {
+		schema = mbtilesDeduplicatedSchema
+	}
+	if err := sqlitex.ExecuteScript(conn, schema, nil); err != nil {
+		return fmt.Errorf("Failed to create schema, %w", err)
+	}
+
+	if err := sqlitex.ExecuteTransient(conn, "BEGIN TRANSACTION", nil); err != nil {
+		return fmt.Errorf("Failed to begin transaction, %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			sqlitex.ExecuteTransient(conn, "ROLLBACK", nil)
+		}
+	}()
+
+	for i := 0; i < len(mbtilesMetadata); i += 2 {
+		err := sqlitex.Execute(conn, "INSERT INTO metadata (name, value) VALUES (?, ?)", &sqlitex.ExecOptions{
+			Args: []interface{}{mbtilesMetadata[i], mbtilesMetadata[i+1]},
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to insert metadata, %w", err)
+		}
+	}
+
+	var insertTile, insertMap, insertImage *sqlite.Stmt
+	if deduplicate {
+		insertMap = conn.Prep("INSERT INTO map (zoom_level, tile_column, tile_row, tile_id) VALUES (?, ?, ?, ?)")
+		insertImage = conn.Prep("INSERT INTO images (tile_data, tile_id) VALUES (?, ?)")
+	} else {
+		insertTile = conn.Prep("INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+	}
+
+	seenOffsets := roaring64.New()
+	bar := defaultProgressbar(logger, int64(header.AddressedTilesCount))
+
+	var iterErr error
+	err = reader.IterateEntries(ctx, func(e EntryV3) {
+		if iterErr != nil {
+			return
+		}
+
+		var data []byte
+		if !deduplicate || !seenOffsets.Contains(e.Offset) {
+			data, iterErr = reader.readRange(ctx, header.TileDataOffset+e.Offset, uint64(e.Length))
+			if iterErr != nil {
+				return
+			}
+			if len(data) != int(e.Length) {
+				iterErr = fmt.Errorf("tile data is truncated, expected %d bytes, got %d", e.Length, len(data))
+				return
+			}
+		}
+
+		tileID := strconv.FormatUint(e.Offset, 10)
+		if deduplicate && !seenOffsets.Contains(e.Offset) {
+			seenOffsets.Add(e.Offset)
+			insertImage.BindBytes(1, data)
+			insertImage.BindText(2, tileID)
+			if _, iterErr = insertImage.Step(); iterErr != nil {
+				return
+			}
+			insertImage.Reset()
+		}
+
+		for id := e.TileID; id < e.TileID+uint64(e.RunLength); id++ {
+			z, x, y := IDToZxy(id)
+			flippedY := (1 << z) - 1 - y
+			stmt := insertTile
+			if deduplicate {
+				stmt = insertMap
+			}
+			stmt.BindInt64(1, int64(z))
+			stmt.BindInt64(2, int64(x))
+			stmt.BindInt64(3, int64(flippedY))
+			if deduplicate {
+				stmt.BindText(4, tileID)
+			} else {
+				stmt.BindBytes(4, data)
+			}
+			if _, iterErr = stmt.Step(); iterErr != nil {
+				return
+			}
+			stmt.Reset()
+			bar.Add(1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if iterErr != nil {
+		return fmt.Errorf("Failed to write tiles, %w", iterErr)
+	}
+
+	if err := sqlitex.ExecuteTransient(conn, "COMMIT", nil); err != nil {
+		return fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+	committed = true
+
+	logger.Println("Finished in ", time.Since(start))
+	return nil
+}
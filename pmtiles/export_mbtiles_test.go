@@ -0,0 +1,109 @@
+package pmtiles
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHeaderJSONToMbtilesRoundtrip(t *testing.T) {
+	header, jsonMetadata, err := mbtilesToHeaderJSON([]string{
+		"name", "test_name",
+		"format", "pbf",
+		"bounds", "-180,-85,180,85",
+		"center", "-122.1906,37.7599,11",
+		"json", "{\"vector_layers\":[{\"id\":\"abc\"}]}",
+	})
+	assert.Nil(t, err)
+	header.MinZoom = 0
+	header.MaxZoom = 14
+
+	mbtilesMetadata, err := headerJSONToMbtiles(header, jsonMetadata)
+	assert.Nil(t, err)
+
+	result := make(map[string]string)
+	for i := 0; i < len(mbtilesMetadata); i += 2 {
+		result[mbtilesMetadata[i]] = mbtilesMetadata[i+1]
+	}
+	assert.Equal(t, "pbf", result["format"])
+	assert.Equal(t, "-180,-85,180,85", result["bounds"])
+	assert.Equal(t, "-122.1906,37.7599,11", result["center"])
+	assert.Equal(t, "0", result["minzoom"])
+	assert.Equal(t, "14", result["maxzoom"])
+	assert.Equal(t, "test_name", result["name"])
+	assert.JSONEq(t, `{"vector_layers":[{"id":"abc"}]}`, result["json"])
+
+	roundtripHeader, roundtripJSON, err := mbtilesToHeaderJSON(mbtilesMetadata)
+	assert.Nil(t, err)
+	assert.Equal(t, header.TileType, roundtripHeader.TileType)
+	assert.Equal(t, header.CenterLonE7, roundtripHeader.CenterLonE7)
+	assert.Equal(t, header.MaxLatE7, roundtripHeader.MaxLatE7)
+	assert.Equal(t, jsonMetadata["vector_layers"], roundtripJSON["vector_layers"])
+}
+
+func TestHeaderJSONToMbtilesUnsupported(t *testing.T) {
+	_, err := headerJSONToMbtiles(HeaderV3{TileType: Mlt}, map[string]interface{}{})
+	assert.NotNil(t, err)
+}
+
+func TestExportMbtilesRoundtrip(t *testing.T) {
+	for _, deduplicate := range []bool{false, true} {
+		tmp := t.TempDir()
+		mbtilesPath := filepath.Join(tmp, "exported.mbtiles")
+		err := Convert(logger, "fixtures/test_fixture_1.pmtiles", mbtilesPath, deduplicate, nil)
+		assert.Nil(t, err)
+
+		err = Convert(logger, "fixtures/test_fixture_1.pmtiles", mbtilesPath, deduplicate, nil)
+		assert.NotNil(t, err)
+
+		tmpfile, _ := os.CreateTemp(tmp, "pmtiles")
+		pmtilesPath := filepath.Join(tmp, "roundtrip.pmtiles")
+		err = Convert(logger, mbtilesPath, pmtilesPath, true, tmpfile)
+		assert.Nil(t, err)
+
+		ctx := context.Background()
+		original := readerForFile(t, "fixtures/test_fixture_1.pmtiles")
+		roundtrip := readerForFile(t, pmtilesPath)
+		assert.Equal(t, original.Header().AddressedTilesCount, roundtrip.Header().AddressedTilesCount)
+		assert.Equal(t, original.Header().MaxZoom, roundtrip.Header().MaxZoom)
+
+		err = original.IterateEntries(ctx, func(e EntryV3) {
+			z, x, y := IDToZxy(e.TileID)
+			expected, _, _ := original.GetTile(ctx, z, x, y)
+			actual, ok, _ := roundtrip.GetTile(ctx, z, x, y)
+			assert.True(t, ok)
+			assert.Equal(t, expected, actual)
+		})
+		assert.Nil(t, err)
+	}
+}
+
+func readerForFile(t *testing.T, path string) *Reader {
+	bucketURL, key, err := NormalizeBucketKey("", "", path)
+	assert.Nil(t, err)
+	bucket, err := OpenBucket(context.Background(), bucketURL, "")
+	assert.Nil(t, err)
+	t.Cleanup(func() { bucket.Close() })
+	reader, err := NewReader(context.Background(), bucket, key, nil)
+	assert.Nil(t, err)
+	return reader
+}
+
+func TestExportMbtilesRemovesOutputOnError(t *testing.T) {
+	tmp := t.TempDir()
+	data, err := os.ReadFile("fixtures/test_fixture_1.pmtiles")
+	assert.Nil(t, err)
+	header, err := DeserializeHeader(data[0:HeaderV3LenBytes])
+	assert.Nil(t, err)
+	// cut off the tile data so reading tiles fails
+	truncatedPath := filepath.Join(tmp, "truncated.pmtiles")
+	assert.Nil(t, os.WriteFile(truncatedPath, data[:header.TileDataOffset], 0644))
+
+	mbtilesPath := filepath.Join(tmp, "exported.mbtiles")
+	err = Convert(logger, truncatedPath, mbtilesPath, false, nil)
+	assert.NotNil(t, err)
+	_, err = os.Stat(mbtilesPath)
+	assert.True(t, os.IsNotExist(err))
+}
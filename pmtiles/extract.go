@@ -7,10 +7,8 @@ import (
 	"fmt"
 	"github.com/RoaringBitmap/roaring/roaring64"
 	"github.com/dustin/go-humanize"
-	"github.com/paulmach/orb"
 	"golang.org/x/sync/errgroup"
 	"io"
-	"io/ioutil"
 	"log"
 	"math"
 	"os"
@@ -303,34 +301,15 @@ func Extract(ctx context.Context, logger *log.Logger, bucketURL string, key stri
 
 	var relevantSet *roaring64.Bitmap
 	if regionFile != "" || bbox != "" {
-		if regionFile != "" && bbox != "" {
-			return fmt.Errorf("only one of region and bbox can be specified")
-		}
-
-		var multipolygon orb.MultiPolygon
-
-		if regionFile != "" {
-			dat, _ := ioutil.ReadFile(regionFile)
-			multipolygon, err = UnmarshalRegion(dat)
-
-			if err != nil {
-				return err
-			}
-		} else {
-			multipolygon, err = BboxRegion(bbox)
-			if err != nil {
-				return err
-			}
+		multipolygon, err := loadRegion(regionFile, bbox)
+		if err != nil {
+			return err
 		}
 
 		// 2. construct a relevance bitmap
 
 		bound := multipolygon.Bound()
-
-		boundarySet, interiorSet := bitmapMultiPolygon(uint8(maxzoom), multipolygon)
-		relevantSet = boundarySet
-		relevantSet.Or(interiorSet)
-		generalizeOr(relevantSet, uint8(minzoom))
+		relevantSet = regionBitmap(multipolygon, uint8(minzoom), uint8(maxzoom))
 
 		header.MinLonE7 = int32(bound.Left() * 10000000)
 		header.MinLatE7 = int32(bound.Bottom() * 10000000)
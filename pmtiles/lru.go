@@ -0,0 +1,86 @@
+package pmtiles
+
+import (
+	"container/list"
+)
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+// lru is a least recently used map, evicting entries once their total size exceeds maxSize.
+// Without a sizeOf function every entry has size 1. It is not safe for concurrent use.
+type lru[K comparable, V any] struct {
+	maxSize   int64
+	size      int64
+	sizeOf    func(V) int64
+	onEvict   func(K, V)
+	items     map[K]*list.Element
+	evictList *list.List
+}
+
+func newLRU[K comparable, V any](maxSize int64, sizeOf func(V) int64) *lru[K, V] {
+	return &lru[K, V]{
+		maxSize:   maxSize,
+		sizeOf:    sizeOf,
+		items:     make(map[K]*list.Element),
+		evictList: list.New(),
+	}
+}
+
+func (c *lru[K, V]) get(key K) (V, bool) {
+	if elem, ok := c.items[key]; ok {
+		c.evictList.MoveToFront(elem)
+		return elem.Value.(*lruEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// add inserts or replaces the value for key and evicts the least recently used entries over the budget,
+// calling onEvict for each. Values larger than maxSize are evicted at once.
+func (c *lru[K, V]) add(key K, value V) {
+	size := int64(1)
+	if c.sizeOf != nil {
+		size = c.sizeOf(value)
+	}
+	c.remove(key)
+	if size > c.maxSize {
+		if c.onEvict != nil {
+			c.onEvict(key, value)
+		}
+		return
+	}
+	c.items[key] = c.evictList.PushFront(&lruEntry[K, V]{key, value, size})
+	c.size += size
+	for c.size > c.maxSize {
+		entry := c.removeElement(c.evictList.Back())
+		if c.onEvict != nil {
+			c.onEvict(entry.key, entry.value)
+		}
+	}
+}
+
+func (c *lru[K, V]) remove(key K) {
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// removeIf removes every entry whose key matches, without calling onEvict.
+func (c *lru[K, V]) removeIf(match func(K) bool) {
+	for key, elem := range c.items {
+		if match(key) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *lru[K, V]) removeElement(elem *list.Element) *lruEntry[K, V] {
+	entry := c.evictList.Remove(elem).(*lruEntry[K, V])
+	delete(c.items, entry.key)
+	c.size -= entry.size
+	return entry
+}
@@ -0,0 +1,38 @@
+package pmtiles
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUSize(t *testing.T) {
+	var evicted []string
+	c := newLRU[string, []byte](4, func(data []byte) int64 { return int64(len(data)) })
+	c.onEvict = func(key string, _ []byte) {
+		evicted = append(evicted, key)
+	}
+	c.add("a", []byte{1, 2})
+	c.add("b", []byte{3})
+	_, ok := c.get("a")
+	assert.True(t, ok)
+	c.add("c", []byte{4, 5})
+	assert.Equal(t, []string{"b"}, evicted)
+	assert.Equal(t, int64(4), c.size)
+
+	c.add("a", []byte{6})
+	data, _ := c.get("a")
+	assert.Equal(t, []byte{6}, data)
+	assert.Equal(t, int64(3), c.size)
+
+	c.add("d", []byte{1, 2, 3, 4, 5})
+	_, ok = c.get("d")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"b", "d"}, evicted)
+
+	c.removeIf(func(key string) bool { return key == "c" })
+	_, ok = c.get("c")
+	assert.False(t, ok)
+	assert.Equal(t, int64(1), c.size)
+	assert.Equal(t, []string{"b", "d"}, evicted)
+}
@@ -3,6 +3,7 @@ package pmtiles
 import (
 	"fmt"
 	"github.com/RoaringBitmap/roaring/roaring64"
+	"google.golang.org/protobuf/encoding/protowire"
 	"io"
 	"log"
 	"math"
@@ -27,11 +28,11 @@ type remapping struct {
 	DstOffset uint64
 }
 
-// load N archives, validating that they are mergeable and disjoint.
+// load N archives, validating that they are mergeable and disjoint, unless allowOverlap is set.
 // returns a formatted error and index of the mismatched archive if not.
 // if valid, returns a sorted list of mergeEntry records, each containing a directory Entry
 // but with offset values referring to the original input archive.
-func prepareInputs(inputs []io.ReadSeeker) ([]HeaderV3, []mergeEntry, error, int) {
+func prepareInputs(inputs []io.ReadSeeker, allowOverlap bool) ([]HeaderV3, []mergeEntry, error, int) {
 	var headers []HeaderV3
 	var mergedEntries []mergeEntry
 	union := roaring64.New()
@@ -83,7 +84,7 @@ func prepareInputs(inputs []io.ReadSeeker) ([]HeaderV3, []mergeEntry, error, int
 			return nil, nil, err, inputIdx
 		}
 
-		if union.Intersects(tileset) {
+		if !allowOverlap && union.Intersects(tileset) {
 			tmp := union.Clone()
 			tmp.And(tileset)
 			iz, ix, iy := IDToZxy(tmp.Minimum())
@@ -92,7 +93,7 @@ func prepareInputs(inputs []io.ReadSeeker) ([]HeaderV3, []mergeEntry, error, int
 		union.Or(tileset)
 	}
 
-	sort.Slice(mergedEntries, func(i, j int) bool {
+	sort.SliceStable(mergedEntries, func(i, j int) bool {
 		return mergedEntries[i].Entry.TileID < mergedEntries[j].Entry.TileID
 	})
 
@@ -212,7 +213,7 @@ func Merge(logger *log.Logger, inputs []string) error {
 		defer f.Close()
 	}
 
-	headers, mergedEntries, err, errIdx := prepareInputs(handles)
+	headers, mergedEntries, err, errIdx := prepareInputs(handles, false)
 	if err != nil {
 		return fmt.Errorf("%s: %w", inputs[errIdx], err)
 	}
@@ -305,3 +306,348 @@ func Merge(logger *log.Logger, inputs []string) error {
 
 	return nil
 }
+
+// MergeStrategy decides which input provides a tile addressed by more than one archive.
+type MergeStrategy int
+
+const (
+	// MergeDisjoint fails if any tile is addressed by more than one input.
+	MergeDisjoint MergeStrategy = iota
+	// MergeFirstWins keeps the tile from the earliest input.
+	MergeFirstWins
+	// MergeLastWins keeps the tile from the latest input.
+	MergeLastWins
+	// MergeConcatMvtLayers combines the layers of every input with ConcatenateMvtLayers.
+	// All inputs must be vector tiles.
+	MergeConcatMvtLayers
+)
+
+// MergeResolver combines the decompressed contents of a tile addressed by several inputs, in input order.
+// The result is compressed with the archive's TileCompression; an empty result drops the tile.
+// For a run of identical tiles, it is called once with the coordinates of the first tile.
+type MergeResolver func(z uint8, x uint32, y uint32, tiles [][]byte) ([]byte, error)
+
+// ConcatenateMvtLayers is a MergeResolver for vector tiles that keeps the layers of every input.
+// Layers with the same name are merged into one, since layer names must be unique within a tile.
+func ConcatenateMvtLayers(_ uint8, _ uint32, _ uint32, tiles [][]byte) ([]byte, error) {
+	var names []string
+	layers := make(map[string][][]byte)
+	for _, tile := range tiles {
+		err := consumeFields(tile, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			if num != 3 || typ != protowire.BytesType {
+				return nil
+			}
+			name, err := mvtLayerName(value)
+			if err != nil {
+				return err
+			}
+			if _, ok := layers[name]; !ok {
+				names = append(names, name)
+			}
+			layers[name] = append(layers[name], value)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse tile, %w", err)
+		}
+	}
+
+	var b []byte
+	for _, name := range names {
+		layer := layers[name][0]
+		if len(layers[name]) > 1 {
+			var err error
+			layer, err = mergeMvtLayers(name, layers[name])
+			if err != nil {
+				return nil, err
+			}
+		}
+		b = appendField(b, 3, protowire.BytesType, layer)
+	}
+	return b, nil
+}
+
+func mvtLayerName(layer []byte) (string, error) {
+	var name string
+	err := consumeFields(layer, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num == 1 && typ == protowire.BytesType {
+			name = string(value)
+		}
+		return nil
+	})
+	return name, err
+}
+
+// mergeMvtLayers combines the features of layers sharing a name into one layer.
+// Keys and values are deduplicated and feature tags rewritten to the merged indices.
+func mergeMvtLayers(name string, layers [][]byte) ([]byte, error) {
+	var b []byte
+	var features, keys, values [][]byte
+	keyIndex := make(map[string]uint64)
+	valueIndex := make(map[string]uint64)
+	index := func(lookup map[string]uint64, list *[][]byte, value []byte) uint64 {
+		i, ok := lookup[string(value)]
+		if !ok {
+			i = uint64(len(*list))
+			lookup[string(value)] = i
+			*list = append(*list, value)
+		}
+		return i
+	}
+
+	var extent uint64
+	for i, layer := range layers {
+		var layerFeatures [][]byte
+		var keyMap, valueMap []uint64
+		layerExtent := uint64(4096)
+		err := consumeFields(layer, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			switch {
+			case num == 2 && typ == protowire.BytesType:
+				layerFeatures = append(layerFeatures, value)
+			case num == 3 && typ == protowire.BytesType:
+				keyMap = append(keyMap, index(keyIndex, &keys, value))
+			case num == 4 && typ == protowire.BytesType:
+				valueMap = append(valueMap, index(valueIndex, &values, value))
+			default:
+				if num == 5 && typ == protowire.VarintType {
+					layerExtent, _ = protowire.ConsumeVarint(value)
+				}
+				if i == 0 {
+					b = appendField(b, num, typ, value)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse layer %s, %w", name, err)
+		}
+		if i == 0 {
+			extent = layerExtent
+		} else if layerExtent != extent {
+			return nil, fmt.Errorf("layer %s has extent %d and %d in different inputs", name, extent, layerExtent)
+		}
+		for _, feature := range layerFeatures {
+			remapped, err := remapMvtFeatureTags(feature, keyMap, valueMap)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to merge layer %s, %w", name, err)
+			}
+			features = append(features, remapped)
+		}
+	}
+
+	for _, feature := range features {
+		b = appendField(b, 2, protowire.BytesType, feature)
+	}
+	for _, key := range keys {
+		b = appendField(b, 3, protowire.BytesType, key)
+	}
+	for _, value := range values {
+		b = appendField(b, 4, protowire.BytesType, value)
+	}
+	return b, nil
+}
+
+func remapMvtFeatureTags(feature []byte, keyMap []uint64, valueMap []uint64) ([]byte, error) {
+	var b []byte
+	err := consumeFields(feature, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 2 || typ != protowire.BytesType {
+			b = appendField(b, num, typ, value)
+			return nil
+		}
+		var tags []byte
+		for i := 0; len(value) > 0; i++ {
+			v, n := protowire.ConsumeVarint(value)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value = value[n:]
+			mapping := keyMap
+			if i%2 == 1 {
+				mapping = valueMap
+			}
+			if v >= uint64(len(mapping)) {
+				return fmt.Errorf("tag index %d out of range", v)
+			}
+			tags = protowire.AppendVarint(tags, mapping[v])
+		}
+		b = appendField(b, 2, protowire.BytesType, tags)
+		return nil
+	})
+	return b, err
+}
+
+// MergeWithStrategy merges archives that may address the same tiles: INPUT1 ... INPUTN OUTPUT.
+// Overlapping tiles are resolved by resolve if non-nil, otherwise by strategy.
+// The header counts, zoom range and bounds are recomputed for the result.
+func MergeWithStrategy(logger *log.Logger, inputs []string, strategy MergeStrategy, resolve MergeResolver) error {
+	if strategy == MergeDisjoint && resolve == nil {
+		return Merge(logger, inputs)
+	}
+	if strategy == MergeConcatMvtLayers && resolve == nil {
+		resolve = ConcatenateMvtLayers
+	}
+
+	if len(inputs) < 2 {
+		return fmt.Errorf("Too few inputs")
+	}
+
+	var files []*os.File
+	var handles []io.ReadSeeker
+	for _, name := range inputs[:len(inputs)-1] {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		handles = append(handles, f)
+		defer f.Close()
+	}
+
+	headers, mergedEntries, err, errIdx := prepareInputs(handles, true)
+	if err != nil {
+		return fmt.Errorf("%s: %w", inputs[errIdx], err)
+	}
+
+	if strategy == MergeConcatMvtLayers && headers[0].TileType != Mvt {
+		return fmt.Errorf("cannot concatenate layers of %s tiles", tileTypeToString(headers[0].TileType))
+	}
+
+	if resolve != nil && headers[0].TileCompression == UnknownCompression {
+		return fmt.Errorf("cannot resolve tiles with unknown compression")
+	}
+
+	perInput := make([][]EntryV3, len(headers))
+	for _, me := range mergedEntries {
+		perInput[me.InputIdx] = append(perInput[me.InputIdx], me.Entry)
+	}
+
+	// consecutive segments often come from the same entry, so keep its data around
+	lastOffsets := make([]int64, len(headers))
+	lastData := make([][]byte, len(headers))
+	for i := range lastOffsets {
+		lastOffsets[i] = -1
+	}
+	read := func(inputIdx int, e EntryV3) ([]byte, error) {
+		if lastOffsets[inputIdx] == int64(e.Offset) {
+			return lastData[inputIdx], nil
+		}
+		data := make([]byte, e.Length)
+		_, err := files[inputIdx].ReadAt(data, int64(headers[inputIdx].TileDataOffset+e.Offset))
+		if err != nil {
+			return nil, err
+		}
+		lastOffsets[inputIdx] = int64(e.Offset)
+		lastData[inputIdx] = data
+		return data, nil
+	}
+
+	tmpfile, err := os.CreateTemp("", "pmtiles")
+	if err != nil {
+		return fmt.Errorf("Failed to create temp file, %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	resolver := newResolver(true, false)
+	cursors := make([]int, len(headers))
+	covering := make([]int, 0, len(headers))
+	pos := uint64(0)
+
+	// sweep over TileIDs in segments where the set of inputs covering each tile is constant
+	for {
+		covering = covering[:0]
+		next := uint64(math.MaxUint64)
+		for i, entries := range perInput {
+			for cursors[i] < len(entries) && entries[cursors[i]].TileID+uint64(entries[cursors[i]].RunLength) <= pos {
+				cursors[i]++
+			}
+			if cursors[i] == len(entries) {
+				continue
+			}
+			e := entries[cursors[i]]
+			if e.TileID <= pos {
+				covering = append(covering, i)
+				next = min(next, e.TileID+uint64(e.RunLength))
+			} else {
+				next = min(next, e.TileID)
+			}
+		}
+
+		if next == math.MaxUint64 {
+			break
+		}
+		if len(covering) == 0 {
+			pos = next
+			continue
+		}
+
+		var data []byte
+		if len(covering) == 1 || resolve == nil {
+			winner := covering[0]
+			if strategy == MergeLastWins {
+				winner = covering[len(covering)-1]
+			}
+			data, err = read(winner, perInput[winner][cursors[winner]])
+			if err != nil {
+				return err
+			}
+		} else {
+			tiles := make([][]byte, 0, len(covering))
+			for _, i := range covering {
+				stored, err := read(i, perInput[i][cursors[i]])
+				if err != nil {
+					return err
+				}
+				decompressed, err := decompressBytes(stored, headers[i].TileCompression)
+				if err != nil {
+					return fmt.Errorf("%s: failed to decompress tile, %w", inputs[i], err)
+				}
+				tiles = append(tiles, decompressed)
+			}
+			z, x, y := IDToZxy(pos)
+			combined, err := resolve(z, x, y, tiles)
+			if err != nil {
+				return fmt.Errorf("Failed to resolve tile %d %d %d, %w", z, x, y, err)
+			}
+			if len(combined) > 0 {
+				data, err = compressBytes(combined, headers[0].TileCompression)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(data) > 0 {
+			if isNew, newData := resolver.AddTileIsNew(pos, data, uint32(next-pos)); isNew {
+				_, err := tmpfile.Write(newData)
+				if err != nil {
+					return fmt.Errorf("Failed to write to tempfile, %w", err)
+				}
+			}
+		}
+		pos = next
+	}
+
+	if len(resolver.Entries) == 0 {
+		return fmt.Errorf("no tiles in merged archive")
+	}
+
+	logger.Printf("Copying center and JSON metadata from first input %s", inputs[0])
+	metadataReader := io.NewSectionReader(files[0], int64(headers[0].MetadataOffset), int64(headers[0].MetadataLength))
+	metadata, err := DeserializeMetadata(metadataReader, headers[0].InternalCompression)
+	if err != nil {
+		return fmt.Errorf("Failed to read metadata, %w", err)
+	}
+
+	var header HeaderV3
+	header.TileType = headers[0].TileType
+	header.InternalCompression = headers[0].InternalCompression
+	header.TileCompression = headers[0].TileCompression
+	header.MinLonE7, header.MinLatE7, header.MaxLonE7, header.MaxLatE7 = bounds(headers)
+	header.CenterLonE7 = headers[0].CenterLonE7
+	header.CenterLatE7 = headers[0].CenterLatE7
+	header.CenterZoom = headers[0].CenterZoom
+
+	_, err = finalize(logger, resolver, header, tmpfile, inputs[len(inputs)-1], metadata)
+	return err
+}
@@ -2,8 +2,13 @@ package pmtiles
 
 import (
 	"bytes"
+	"context"
+	"github.com/paulmach/orb"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -128,7 +133,7 @@ func TestValidateArchiveClustered(t *testing.T) {
 	archive2 := fakeArchive(h2, map[string]interface{}{}, map[Zxy][]byte{
 		{0, 0, 0}: {0, 1, 2, 3},
 		{4, 1, 2}: {1, 2, 3}}, false, Gzip)
-	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)})
+	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)}, false)
 	assert.ErrorContains(t, err, "must be clustered")
 	assert.Equal(t, 1, errIdx)
 }
@@ -144,7 +149,7 @@ func TestValidateArchiveTileType(t *testing.T) {
 	h2.TileType = Png
 	archive2 := fakeArchive(h2, map[string]interface{}{}, map[Zxy][]byte{
 		{4, 1, 2}: {1, 2, 3}}, false, Gzip)
-	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)})
+	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)}, false)
 	assert.ErrorContains(t, err, "png does not match jpg")
 	assert.Equal(t, 1, errIdx)
 }
@@ -162,7 +167,7 @@ func TestValidateArchiveTileCompression(t *testing.T) {
 	h2.TileCompression = Brotli
 	archive2 := fakeArchive(h2, map[string]interface{}{}, map[Zxy][]byte{
 		{4, 1, 2}: {1, 2, 3}}, false, Gzip)
-	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)})
+	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)}, false)
 	assert.ErrorContains(t, err, "br does not match gzip")
 	assert.Equal(t, 1, errIdx)
 }
@@ -178,7 +183,7 @@ func TestValidateArchiveInternalCompression(t *testing.T) {
 	h2.TileType = UnknownTileType
 	archive2 := fakeArchive(h2, map[string]interface{}{}, map[Zxy][]byte{
 		{4, 1, 2}: {1, 2, 3}}, false, Gzip)
-	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)})
+	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)}, false)
 	assert.ErrorContains(t, err, "gzip does not match none")
 	assert.Equal(t, 1, errIdx)
 }
@@ -192,7 +197,7 @@ func TestValidateSuccess(t *testing.T) {
 	h2.Clustered = true
 	archive2 := fakeArchive(h2, map[string]interface{}{}, map[Zxy][]byte{
 		{0, 0, 0}: {0, 1, 2, 3}}, false, Gzip)
-	_, mergeEntries, err, _ := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)})
+	_, mergeEntries, err, _ := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)}, false)
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(mergeEntries))
 	assert.Equal(t, uint64(0), mergeEntries[0].Entry.TileID)
@@ -208,7 +213,206 @@ func TestValidateDisjoint(t *testing.T) {
 	h2.Clustered = true
 	archive2 := fakeArchive(h2, map[string]interface{}{}, map[Zxy][]byte{
 		{0, 0, 0}: {0, 1, 2, 3}}, false, Gzip)
-	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)})
+	_, _, err, errIdx := prepareInputs([]io.ReadSeeker{bytes.NewReader(archive1), bytes.NewReader(archive2)}, false)
 	assert.ErrorContains(t, err, "1 overlapping tiles, starting with 0 0 0")
 	assert.Equal(t, 1, errIdx)
 }
+
+func writeFakeArchive(t *testing.T, name string, archive []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, archive, 0644))
+	return path
+}
+
+func TestMergeWithStrategy(t *testing.T) {
+	header := HeaderV3{Clustered: true, TileType: Png, TileCompression: NoCompression}
+	first := writeFakeArchive(t, "first.pmtiles", fakeArchive(header, map[string]interface{}{"name": "first"}, map[Zxy][]byte{
+		{0, 0, 0}: {0xa},
+	}, false, Gzip))
+	second := writeFakeArchive(t, "second.pmtiles", fakeArchive(header, map[string]interface{}{"name": "second"}, map[Zxy][]byte{
+		{0, 0, 0}: {0xb},
+		{1, 0, 0}: {0xc},
+	}, false, Gzip))
+	ctx := context.Background()
+
+	output := filepath.Join(t.TempDir(), "disjoint.pmtiles")
+	err := MergeWithStrategy(logger, []string{first, second, output}, MergeDisjoint, nil)
+	assert.ErrorContains(t, err, "overlapping")
+
+	output = filepath.Join(t.TempDir(), "first.pmtiles")
+	err = MergeWithStrategy(logger, []string{first, second, output}, MergeFirstWins, nil)
+	assert.Nil(t, err)
+	reader := readerForFile(t, output)
+	data, _, _ := reader.GetTile(ctx, 0, 0, 0)
+	assert.Equal(t, []byte{0xa}, data)
+	data, _, _ = reader.GetTile(ctx, 1, 0, 0)
+	assert.Equal(t, []byte{0xc}, data)
+	assert.Equal(t, uint64(2), reader.Header().AddressedTilesCount)
+	assert.Equal(t, uint8(1), reader.Header().MaxZoom)
+	metadata, _ := reader.Metadata(ctx)
+	assert.Equal(t, "first", metadata["name"])
+
+	output = filepath.Join(t.TempDir(), "last.pmtiles")
+	err = MergeWithStrategy(logger, []string{first, second, output}, MergeLastWins, nil)
+	assert.Nil(t, err)
+	reader = readerForFile(t, output)
+	data, _, _ = reader.GetTile(ctx, 0, 0, 0)
+	assert.Equal(t, []byte{0xb}, data)
+
+	output = filepath.Join(t.TempDir(), "concat.pmtiles")
+	err = MergeWithStrategy(logger, []string{first, second, output}, MergeConcatMvtLayers, nil)
+	assert.ErrorContains(t, err, "cannot concatenate layers of png tiles")
+}
+
+func testMvtLayerTile(name string, extent uint64, key string, value string) []byte {
+	var tags []byte
+	tags = protowire.AppendVarint(tags, 0)
+	tags = protowire.AppendVarint(tags, 0)
+
+	var feature []byte
+	feature = protowire.AppendTag(feature, 2, protowire.BytesType)
+	feature = protowire.AppendBytes(feature, tags)
+	feature = protowire.AppendTag(feature, 3, protowire.VarintType)
+	feature = protowire.AppendVarint(feature, 1)
+	feature = protowire.AppendTag(feature, 4, protowire.BytesType)
+	feature = protowire.AppendBytes(feature, encodeMvtPoints(orb.MultiPoint{{1, 1}}))
+
+	var stringValue []byte
+	stringValue = protowire.AppendTag(stringValue, 1, protowire.BytesType)
+	stringValue = protowire.AppendString(stringValue, value)
+
+	var layer []byte
+	layer = protowire.AppendTag(layer, 15, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, 2)
+	layer = protowire.AppendTag(layer, 1, protowire.BytesType)
+	layer = protowire.AppendString(layer, name)
+	layer = protowire.AppendTag(layer, 2, protowire.BytesType)
+	layer = protowire.AppendBytes(layer, feature)
+	layer = protowire.AppendTag(layer, 3, protowire.BytesType)
+	layer = protowire.AppendString(layer, key)
+	layer = protowire.AppendTag(layer, 4, protowire.BytesType)
+	layer = protowire.AppendBytes(layer, stringValue)
+	layer = protowire.AppendTag(layer, 5, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, extent)
+
+	var tile []byte
+	tile = protowire.AppendTag(tile, 3, protowire.BytesType)
+	return protowire.AppendBytes(tile, layer)
+}
+
+type testMvtLayer struct {
+	features int
+	keys     []string
+	values   []string
+	tags     [][]uint64
+}
+
+// testMvtLayers decodes the layers of a tile by name, failing on duplicate names.
+func testMvtLayers(t *testing.T, tile []byte) map[string]*testMvtLayer {
+	assert.Nil(t, verifyMvt(tile))
+	layers := make(map[string]*testMvtLayer)
+	consumeFields(tile, func(_ protowire.Number, _ protowire.Type, value []byte) error {
+		name, _ := mvtLayerName(value)
+		assert.NotContains(t, layers, name)
+		layer := &testMvtLayer{}
+		layers[name] = layer
+		return consumeFields(value, func(num protowire.Number, _ protowire.Type, value []byte) error {
+			switch num {
+			case 2:
+				layer.features++
+				consumeFields(value, func(num protowire.Number, _ protowire.Type, value []byte) error {
+					if num == 2 {
+						var tags []uint64
+						for len(value) > 0 {
+							v, n := protowire.ConsumeVarint(value)
+							tags = append(tags, v)
+							value = value[n:]
+						}
+						layer.tags = append(layer.tags, tags)
+					}
+					return nil
+				})
+			case 3:
+				layer.keys = append(layer.keys, string(value))
+			case 4:
+				consumeFields(value, func(_ protowire.Number, _ protowire.Type, value []byte) error {
+					layer.values = append(layer.values, string(value))
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	return layers
+}
+
+func TestConcatenateMvtLayersDuplicateNames(t *testing.T) {
+	tiles := [][]byte{
+		testMvtLayerTile("roads", 4096, "kind", "highway"),
+		testMvtLayerTile("water", 4096, "kind", "lake"),
+		append(testMvtLayerTile("roads", 4096, "kind", "path"), testMvtLayerTile("roads", 4096, "kind", "highway")...),
+	}
+	combined, err := ConcatenateMvtLayers(0, 0, 0, tiles)
+	assert.Nil(t, err)
+	layers := testMvtLayers(t, combined)
+	assert.Equal(t, 2, len(layers))
+	assert.Equal(t, &testMvtLayer{1, []string{"kind"}, []string{"lake"}, [][]uint64{{0, 0}}}, layers["water"])
+	assert.Equal(t, &testMvtLayer{3, []string{"kind"}, []string{"highway", "path"}, [][]uint64{{0, 0}, {0, 1}, {0, 0}}}, layers["roads"])
+
+	_, err = ConcatenateMvtLayers(0, 0, 0, [][]byte{
+		testMvtLayerTile("roads", 4096, "kind", "highway"),
+		testMvtLayerTile("roads", 512, "kind", "path"),
+	})
+	assert.ErrorContains(t, err, "layer roads has extent 4096 and 512")
+}
+
+func TestMergeConcatMvtLayers(t *testing.T) {
+	header := HeaderV3{Clustered: true, TileType: Mvt, TileCompression: Gzip}
+	highway, _ := compressBytes(testMvtLayerTile("roads", 4096, "kind", "highway"), Gzip)
+	path, _ := compressBytes(testMvtLayerTile("roads", 4096, "kind", "path"), Gzip)
+	first := writeFakeArchive(t, "first.pmtiles", fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{{0, 0, 0}: highway}, false, Gzip))
+	second := writeFakeArchive(t, "second.pmtiles", fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{{0, 0, 0}: path}, false, Gzip))
+
+	output := filepath.Join(t.TempDir(), "concat.pmtiles")
+	err := MergeWithStrategy(logger, []string{first, second, output}, MergeConcatMvtLayers, nil)
+	assert.Nil(t, err)
+	reader := readerForFile(t, output)
+	data, ok, err := reader.GetTile(context.Background(), 0, 0, 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	data, err = decompressBytes(data, Gzip)
+	assert.Nil(t, err)
+	layers := testMvtLayers(t, data)
+	assert.Equal(t, &testMvtLayer{2, []string{"kind"}, []string{"highway", "path"}, [][]uint64{{0, 0}, {0, 1}}}, layers["roads"])
+}
+
+func TestMergeWithStrategyPartialRun(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "base.pmtiles")
+	w, err := NewWriter(logger, base, HeaderV3{TileType: Png, TileCompression: NoCompression}, true, t.TempDir())
+	assert.Nil(t, err)
+	for _, zxy := range []Zxy{{1, 0, 0}, {1, 0, 1}, {1, 1, 1}, {1, 1, 0}} {
+		assert.Nil(t, w.WriteTile(zxy.Z, zxy.X, zxy.Y, []byte{0x1}))
+	}
+	_, err = w.Finalize()
+	assert.Nil(t, err)
+
+	patch := writeFakeArchive(t, "patch.pmtiles", fakeArchive(HeaderV3{Clustered: true, TileType: Png, TileCompression: NoCompression}, map[string]interface{}{}, map[Zxy][]byte{
+		{1, 1, 1}: {0x2},
+	}, false, Gzip))
+
+	output := filepath.Join(t.TempDir(), "patched.pmtiles")
+	err = MergeWithStrategy(logger, []string{base, patch, output}, MergeLastWins, nil)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	reader := readerForFile(t, output)
+	header := reader.Header()
+	assert.Equal(t, uint64(4), header.AddressedTilesCount)
+	assert.Equal(t, uint64(3), header.TileEntriesCount)
+	assert.Equal(t, uint64(2), header.TileContentsCount)
+	data, _, _ := reader.GetTile(ctx, 1, 1, 1)
+	assert.Equal(t, []byte{0x2}, data)
+	data, _, _ = reader.GetTile(ctx, 1, 1, 0)
+	assert.Equal(t, []byte{0x1}, data)
+	assert.Nil(t, Verify(logger, output))
+}
@@ -0,0 +1,478 @@
+package pmtiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"regexp"
+	"strconv"
+	"text/template"
+)
+
+// Parameters of the WebMercatorQuad tile matrix set for 256 pixel tiles,
+// defined here up to the deepest zoom an archive can be served at.
+const (
+	webMercatorQuadMaxZoom          = maxOverzoom
+	webMercatorQuadOrigin           = 20037508.3427892
+	webMercatorQuadScaleDenominator = 559082264.0287178
+	webMercatorQuadCellSize         = 156543.03392804097
+	webMercatorQuadURI              = "http://www.opengis.net/def/tilematrixset/OGC/1.0/WebMercatorQuad"
+)
+
+var ogcConformance = []string{
+	"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-common-1/1.0/conf/json",
+	"http://www.opengis.net/spec/ogcapi-common-2/1.0/conf/collections",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/tileset",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/tilesets-list",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/geodata-tilesets",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/mvt",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/png",
+	"http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/jpeg",
+	"http://www.opengis.net/spec/tms/2.0/conf/json-tilematrixset",
+}
+
+var wmtsCapabilitiesPattern = regexp.MustCompile(`^\/([-A-Za-z0-9_\/!-_\.\*'\(\)']+)\/WMTSCapabilities\.xml$`)
+var ogcCollectionPattern = regexp.MustCompile(`^\/ogc\/collections\/([-A-Za-z0-9_\/!-_\.\*'\(\)']+?)(\/tiles(\/WebMercatorQuad(\/(\d+)\/(\d+)\/(\d+))?)?)?$`)
+
+type ogcLink struct {
+	Href      string `json:"href"`
+	Rel       string `json:"rel"`
+	Type      string `json:"type,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+type tileMatrixLimits struct {
+	Z              uint8
+	MinCol, MinRow uint32
+	MaxCol, MaxRow uint32
+}
+
+// tileMatrixSetLimits returns the range of tiles covering the bounds of an archive at each of its zoom levels.
+// A bound on a tile edge does not include the tile beyond it.
+func tileMatrixSetLimits(header HeaderV3) []tileMatrixLimits {
+	E7 := 10000000.0
+	var limits []tileMatrixLimits
+	for z := header.MinZoom; z <= header.MaxZoom; z++ {
+		n := math.Exp2(float64(z))
+		clamp := func(v float64) uint32 {
+			return uint32(math.Max(0, math.Min(n-1, v)))
+		}
+		minX, minY := lonLatToTile(z, float64(header.MinLonE7)/E7, float64(header.MaxLatE7)/E7)
+		maxX, maxY := lonLatToTile(z, float64(header.MaxLonE7)/E7, float64(header.MinLatE7)/E7)
+		l := tileMatrixLimits{z, clamp(math.Floor(minX)), clamp(math.Floor(minY)), clamp(math.Ceil(maxX) - 1), clamp(math.Ceil(maxY) - 1)}
+		l.MaxCol = max(l.MaxCol, l.MinCol)
+		l.MaxRow = max(l.MaxRow, l.MinRow)
+		limits = append(limits, l)
+	}
+	return limits
+}
+
+// lonLatToTile returns the fractional tile coordinates of a location at zoom z.
+func lonLatToTile(z uint8, lon float64, lat float64) (float64, float64) {
+	n := math.Exp2(float64(z))
+	lat = math.Max(-85.0511, math.Min(85.0511, lat))
+	sin := math.Sin(lat * math.Pi / 180)
+	return (lon + 180) / 360 * n, (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * n
+}
+
+func ogcDataType(header HeaderV3) string {
+	if header.TileType == Mvt || header.TileType == Mlt {
+		return "vector"
+	}
+	return "map"
+}
+
+// servedHeader returns the header of an archive as served, with maxzoom raised by overzooming.
+func (server *Server) servedHeader(name string) (bool, HeaderV3, error) {
+	found, header, _, err := server.getArchiveHeader(name)
+	if found && server.canOverzoom(header) {
+		header.MaxZoom = min(header.MaxZoom+server.overzoom, maxOverzoom)
+	}
+	return found, header, err
+}
+
+func jsonResponse(httpHeaders map[string]string, value interface{}) (int, map[string]string, []byte) {
+	body, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return 500, httpHeaders, []byte("Error generating response")
+	}
+	httpHeaders["Content-Type"] = "application/json"
+	httpHeaders["ETag"] = generateEtag(body)
+	return 200, httpHeaders, body
+}
+
+// ogcBase returns the URL of the OGC API of an archive, or of the whole server when name is empty.
+func (server *Server) ogcBase(name string) string {
+	publicURL := server.publicURL
+	if name != "" {
+		publicURL = server.archivePublicURL(name)
+	}
+	if publicURL == "" {
+		return ""
+	}
+	return publicURL + "/ogc"
+}
+
+func (server *Server) getOgc(ctx context.Context, httpHeaders map[string]string, path string, acceptEncoding string, auth tileAuth) (int, map[string]string, []byte) {
+	res := ogcCollectionPattern.FindStringSubmatch(path)
+	if res != nil {
+		return server.getOgcCollection(ctx, httpHeaders, res, acceptEncoding, auth)
+	}
+
+	base := server.ogcBase("")
+	if base == "" {
+		return 501, httpHeaders, []byte("PUBLIC_URL must be set for OGC API Tiles")
+	}
+	switch path {
+	case "/ogc", "/ogc/":
+		return jsonResponse(httpHeaders, map[string]interface{}{
+			"title": "PMTiles",
+			"links": []ogcLink{
+				{Href: base, Rel: "self", Type: "application/json"},
+				{Href: base + "/conformance", Rel: "http://www.opengis.net/def/rel/ogc/1.0/conformance", Type: "application/json"},
+				{Href: base + "/collections", Rel: "http://www.opengis.net/def/rel/ogc/1.0/data", Type: "application/json"},
+				{Href: base + "/tileMatrixSets", Rel: "http://www.opengis.net/def/rel/ogc/1.0/tiling-schemes", Type: "application/json"},
+			},
+		})
+	case "/ogc/conformance":
+		return jsonResponse(httpHeaders, map[string]interface{}{"conformsTo": ogcConformance})
+	case "/ogc/tileMatrixSets":
+		return jsonResponse(httpHeaders, map[string]interface{}{
+			"tileMatrixSets": []map[string]interface{}{{
+				"id":    "WebMercatorQuad",
+				"title": "Google Maps Compatible for the World",
+				"uri":   webMercatorQuadURI,
+				"links": []ogcLink{{Href: base + "/tileMatrixSets/WebMercatorQuad", Rel: "self", Type: "application/json"}},
+			}},
+		})
+	case "/ogc/tileMatrixSets/WebMercatorQuad":
+		return jsonResponse(httpHeaders, webMercatorQuad())
+	case "/ogc/collections":
+		names, err := server.catalogNames(ctx)
+		if err == errListUnsupported {
+			return 501, httpHeaders, []byte("Bucket does not support listing archives")
+		}
+		if err != nil {
+			server.logger.Printf("failed to list archives, %v", err)
+			return 500, httpHeaders, []byte("I/O Error")
+		}
+		collections := []map[string]interface{}{}
+		names, headers := server.listedHeaders(ctx, names, "collections")
+		for i, name := range names {
+			collections = append(collections, ogcCollection(server.ogcBase(name), name, headers[i], server.tileQuery(auth, name)))
+		}
+		return jsonResponse(httpHeaders, map[string]interface{}{
+			"links":       []ogcLink{{Href: base + "/collections", Rel: "self", Type: "application/json"}},
+			"collections": collections,
+		})
+	}
+	return 404, httpHeaders, []byte("Path not found")
+}
+
+// getOgcCollection serves the paths of a single archive, linking to it at its own public URL
+// with the query authorizing its tiles.
+func (server *Server) getOgcCollection(ctx context.Context, httpHeaders map[string]string, res []string, acceptEncoding string, auth tileAuth) (int, map[string]string, []byte) {
+	name := server.resolveAlias(res[1])
+	base := server.ogcBase(name)
+	if base == "" {
+		return 501, httpHeaders, []byte("PUBLIC_URL must be set for OGC API Tiles")
+	}
+	found, header, err := server.servedHeader(name)
+	if err != nil {
+		return 500, httpHeaders, []byte("I/O Error")
+	}
+	if !found {
+		return 404, httpHeaders, []byte("Archive not found")
+	}
+	collectionURL := base + "/collections/" + name
+	query := server.tileQuery(auth, name)
+
+	switch {
+	case res[2] == "":
+		return jsonResponse(httpHeaders, ogcCollection(base, name, header, query))
+	case res[3] == "":
+		return jsonResponse(httpHeaders, map[string]interface{}{
+			"links":    []ogcLink{{Href: withQuery(collectionURL+"/tiles", query), Rel: "self", Type: "application/json"}},
+			"tilesets": []map[string]interface{}{ogcTilesetSummary(base, collectionURL, header, query)},
+		})
+	case res[4] == "":
+		_, _, metadataBytes, _, err := server.getArchiveHeaderMetadata(ctx, name)
+		if err != nil {
+			return 500, httpHeaders, []byte("I/O Error")
+		}
+		return jsonResponse(httpHeaders, ogcTileset(base, collectionURL, header, metadataBytes, query))
+	}
+
+	z, _ := strconv.ParseUint(res[5], 10, 8)
+	y, _ := strconv.ParseUint(res[6], 10, 32)
+	x, _ := strconv.ParseUint(res[7], 10, 32)
+	return server.getArchiveTile(ctx, httpHeaders, name, uint8(z), uint32(x), uint32(y), tileTypeToString(header.TileType), acceptEncoding)
+}
+
+func webMercatorQuad() map[string]interface{} {
+	var tileMatrices []map[string]interface{}
+	for z := 0; z <= webMercatorQuadMaxZoom; z++ {
+		n := math.Exp2(float64(z))
+		tileMatrices = append(tileMatrices, map[string]interface{}{
+			"id":               strconv.Itoa(z),
+			"scaleDenominator": webMercatorQuadScaleDenominator / n,
+			"cellSize":         webMercatorQuadCellSize / n,
+			"cornerOfOrigin":   "topLeft",
+			"pointOfOrigin":    []float64{-webMercatorQuadOrigin, webMercatorQuadOrigin},
+			"tileWidth":        256,
+			"tileHeight":       256,
+			"matrixWidth":      int64(n),
+			"matrixHeight":     int64(n),
+		})
+	}
+	return map[string]interface{}{
+		"id":                "WebMercatorQuad",
+		"title":             "Google Maps Compatible for the World",
+		"uri":               webMercatorQuadURI,
+		"crs":               "http://www.opengis.net/def/crs/EPSG/0/3857",
+		"orderedAxes":       []string{"X", "Y"},
+		"wellKnownScaleSet": "http://www.opengis.net/def/wkss/OGC/1.0/GoogleMapsCompatible",
+		"tileMatrices":      tileMatrices,
+	}
+}
+
+func ogcBounds(header HeaderV3) []float64 {
+	E7 := 10000000.0
+	return []float64{float64(header.MinLonE7) / E7, float64(header.MinLatE7) / E7, float64(header.MaxLonE7) / E7, float64(header.MaxLatE7) / E7}
+}
+
+func ogcCollection(base string, name string, header HeaderV3, query string) map[string]interface{} {
+	collectionURL := base + "/collections/" + name
+	return map[string]interface{}{
+		"id":    name,
+		"title": name,
+		"extent": map[string]interface{}{
+			"spatial": map[string]interface{}{
+				"bbox": [][]float64{ogcBounds(header)},
+				"crs":  "http://www.opengis.net/def/crs/OGC/1.3/CRS84",
+			},
+		},
+		"dataType": ogcDataType(header),
+		"links": []ogcLink{
+			{Href: withQuery(collectionURL, query), Rel: "self", Type: "application/json"},
+			{Href: withQuery(collectionURL+"/tiles", query), Rel: "http://www.opengis.net/def/rel/ogc/1.0/tilesets-" + ogcDataType(header), Type: "application/json"},
+		},
+	}
+}
+
+func ogcTilesetSummary(base string, collectionURL string, header HeaderV3, query string) map[string]interface{} {
+	return map[string]interface{}{
+		"title":            "WebMercatorQuad",
+		"dataType":         ogcDataType(header),
+		"crs":              "http://www.opengis.net/def/crs/EPSG/0/3857",
+		"tileMatrixSetURI": webMercatorQuadURI,
+		"links": []ogcLink{
+			{Href: withQuery(collectionURL+"/tiles/WebMercatorQuad", query), Rel: "self", Type: "application/json"},
+			{Href: base + "/tileMatrixSets/WebMercatorQuad", Rel: "http://www.opengis.net/def/rel/ogc/1.0/tiling-scheme", Type: "application/json"},
+		},
+	}
+}
+
+func ogcTileset(base string, collectionURL string, header HeaderV3, metadataBytes []byte, query string) map[string]interface{} {
+	tileset := ogcTilesetSummary(base, collectionURL, header, query)
+	contentType, _ := headerContentType(header)
+	tileset["links"] = append(tileset["links"].([]ogcLink), ogcLink{
+		Href:      withQuery(collectionURL+"/tiles/WebMercatorQuad/{tileMatrix}/{tileRow}/{tileCol}", query),
+		Rel:       "item",
+		Type:      contentType,
+		Templated: true,
+	})
+
+	var limits []map[string]interface{}
+	for _, l := range tileMatrixSetLimits(header) {
+		limits = append(limits, map[string]interface{}{
+			"tileMatrix": strconv.Itoa(int(l.Z)),
+			"minTileRow": l.MinRow,
+			"maxTileRow": l.MaxRow,
+			"minTileCol": l.MinCol,
+			"maxTileCol": l.MaxCol,
+		})
+	}
+	tileset["tileMatrixSetLimits"] = limits
+	tileset["boundingBox"] = map[string]interface{}{
+		"lowerLeft":  ogcBounds(header)[0:2],
+		"upperRight": ogcBounds(header)[2:4],
+		"crs":        "http://www.opengis.net/def/crs/OGC/1.3/CRS84",
+	}
+
+	if header.TileType == Mvt {
+		var metadata struct {
+			VectorLayers []struct {
+				ID string `json:"id"`
+			} `json:"vector_layers"`
+		}
+		json.Unmarshal(metadataBytes, &metadata)
+		layers := []map[string]interface{}{}
+		for _, layer := range metadata.VectorLayers {
+			layers = append(layers, map[string]interface{}{"id": layer.ID, "dataType": "vector"})
+		}
+		tileset["layers"] = layers
+	}
+	return tileset
+}
+
+type wmtsLayer struct {
+	Name      string
+	PublicURL string
+	Query     string
+	Bounds    []float64
+	Format    string
+	Ext       string
+	Limits    []tileMatrixLimits
+}
+
+type wmtsTileMatrix struct {
+	Z                uint8
+	ScaleDenominator float64
+	Size             uint64
+}
+
+var wmtsTemplate = template.Must(template.New("wmts").Funcs(template.FuncMap{
+	"xml": func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<Capabilities xmlns="http://www.opengis.net/wmts/1.0" xmlns:ows="http://www.opengis.net/ows/1.1" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.0.0">
+  <ows:ServiceIdentification>
+    <ows:Title>PMTiles</ows:Title>
+    <ows:ServiceType>OGC WMTS</ows:ServiceType>
+    <ows:ServiceTypeVersion>1.0.0</ows:ServiceTypeVersion>
+  </ows:ServiceIdentification>
+  <Contents>
+{{- range .Layers}}
+    <Layer>
+      <ows:Title>{{xml .Name}}</ows:Title>
+      <ows:WGS84BoundingBox>
+        <ows:LowerCorner>{{index .Bounds 0}} {{index .Bounds 1}}</ows:LowerCorner>
+        <ows:UpperCorner>{{index .Bounds 2}} {{index .Bounds 3}}</ows:UpperCorner>
+      </ows:WGS84BoundingBox>
+      <ows:Identifier>{{xml .Name}}</ows:Identifier>
+      <Style isDefault="true">
+        <ows:Identifier>default</ows:Identifier>
+      </Style>
+      <Format>{{xml .Format}}</Format>
+      <TileMatrixSetLink>
+        <TileMatrixSet>WebMercatorQuad</TileMatrixSet>
+        <TileMatrixSetLimits>
+{{- range .Limits}}
+          <TileMatrixLimits>
+            <TileMatrix>{{.Z}}</TileMatrix>
+            <MinTileRow>{{.MinRow}}</MinTileRow>
+            <MaxTileRow>{{.MaxRow}}</MaxTileRow>
+            <MinTileCol>{{.MinCol}}</MinTileCol>
+            <MaxTileCol>{{.MaxCol}}</MaxTileCol>
+          </TileMatrixLimits>
+{{- end}}
+        </TileMatrixSetLimits>
+      </TileMatrixSetLink>
+      <ResourceURL format="{{xml .Format}}" resourceType="tile" template="{{xml .PublicURL}}/{{xml .Name}}/{TileMatrix}/{TileCol}/{TileRow}{{.Ext}}{{if .Query}}?{{xml .Query}}{{end}}"/>
+    </Layer>
+{{- end}}
+    <TileMatrixSet>
+      <ows:Identifier>WebMercatorQuad</ows:Identifier>
+      <ows:SupportedCRS>urn:ogc:def:crs:EPSG::3857</ows:SupportedCRS>
+      <WellKnownScaleSet>urn:ogc:def:wkss:OGC:1.0:GoogleMapsCompatible</WellKnownScaleSet>
+{{- range .TileMatrices}}
+      <TileMatrix>
+        <ows:Identifier>{{.Z}}</ows:Identifier>
+        <ScaleDenominator>{{.ScaleDenominator}}</ScaleDenominator>
+        <TopLeftCorner>{{$.Origin}}</TopLeftCorner>
+        <TileWidth>256</TileWidth>
+        <TileHeight>256</TileHeight>
+        <MatrixWidth>{{.Size}}</MatrixWidth>
+        <MatrixHeight>{{.Size}}</MatrixHeight>
+      </TileMatrix>
+{{- end}}
+    </TileMatrixSet>
+  </Contents>
+</Capabilities>
+`))
+
+// getWMTSCapabilities returns a RESTful WMTS GetCapabilities document with a layer per archive,
+// all sharing the WebMercatorQuad tile matrix set.
+func (server *Server) getWMTSCapabilities(ctx context.Context, httpHeaders map[string]string, names []string, auth tileAuth) (int, map[string]string, []byte) {
+	var layers []wmtsLayer
+	var maxZoom uint8
+	names, headers := server.listedHeaders(ctx, names, "WMTS capabilities")
+	for i, name := range names {
+		header := headers[i]
+		publicURL := server.archivePublicURL(name)
+		if publicURL == "" {
+			return 501, httpHeaders, []byte("PUBLIC_URL must be set for WMTS")
+		}
+		format, _ := headerContentType(header)
+		layers = append(layers, wmtsLayer{
+			Name:      name,
+			PublicURL: publicURL,
+			Query:     server.tileQuery(auth, name),
+			Bounds:    ogcBounds(header),
+			Format:    format,
+			Ext:       headerExt(header),
+			Limits:    tileMatrixSetLimits(header),
+		})
+		maxZoom = max(maxZoom, header.MaxZoom)
+	}
+
+	var tileMatrices []wmtsTileMatrix
+	for z := uint8(0); z <= maxZoom; z++ {
+		tileMatrices = append(tileMatrices, wmtsTileMatrix{z, webMercatorQuadScaleDenominator / math.Exp2(float64(z)), uint64(1) << z})
+	}
+
+	var b bytes.Buffer
+	err := wmtsTemplate.Execute(&b, map[string]interface{}{
+		"Layers":       layers,
+		"TileMatrices": tileMatrices,
+		"Origin":       strconv.FormatFloat(-webMercatorQuadOrigin, 'f', -1, 64) + " " + strconv.FormatFloat(webMercatorQuadOrigin, 'f', -1, 64),
+	})
+	if err != nil {
+		return 500, httpHeaders, []byte("Error generating WMTS capabilities")
+	}
+	httpHeaders["Content-Type"] = "application/xml"
+	httpHeaders["ETag"] = generateEtag(b.Bytes())
+	return 200, httpHeaders, b.Bytes()
+}
+
+func (server *Server) getArchiveWMTSCapabilities(ctx context.Context, httpHeaders map[string]string, name string, auth tileAuth) (int, map[string]string, []byte) {
+	found, _, modTime, err := server.getArchiveHeader(name)
+	if err != nil {
+		return 500, httpHeaders, []byte("I/O Error")
+	}
+	if !found {
+		return 404, httpHeaders, []byte("Archive not found")
+	}
+	status, httpHeaders, data := server.getWMTSCapabilities(ctx, httpHeaders, []string{name}, auth)
+	if status == 200 {
+		setLastModified(httpHeaders, modTime)
+	}
+	return status, httpHeaders, data
+}
+
+func (server *Server) getAllWMTSCapabilities(ctx context.Context, httpHeaders map[string]string, auth tileAuth) (int, map[string]string, []byte) {
+	names, err := server.catalogNames(ctx)
+	if err == errListUnsupported {
+		return 501, httpHeaders, []byte("Bucket does not support listing archives")
+	}
+	if err != nil {
+		server.logger.Printf("failed to list archives, %v", err)
+		return 500, httpHeaders, []byte("I/O Error")
+	}
+	return server.getWMTSCapabilities(ctx, httpHeaders, names, auth)
+}
+
+func parseWMTSCapabilitiesPath(path string) (bool, string) {
+	if res := wmtsCapabilitiesPattern.FindStringSubmatch(path); res != nil {
+		return true, res[1]
+	}
+	return false, ""
+}
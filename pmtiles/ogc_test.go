@@ -0,0 +1,134 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ogcTestServer(t *testing.T) *Server {
+	mockBucket, server := newServer(t)
+	mockBucket.items["archive.pmtiles"] = fakeArchive(HeaderV3{
+		TileType: Mvt,
+		MinZoom:  0,
+		MaxZoom:  2,
+		MinLonE7: 0,
+		MinLatE7: 0,
+		MaxLonE7: 1800000000,
+		MaxLatE7: 850000000,
+	}, map[string]interface{}{
+		"vector_layers": []map[string]interface{}{{"id": "roads"}},
+	}, map[Zxy][]byte{
+		{0, 0, 0}: {0, 1, 2, 3},
+		{2, 3, 1}: {4, 5},
+	}, false, Gzip)
+	return server
+}
+
+func getJSON(t *testing.T, server *Server, path string) map[string]interface{} {
+	statusCode, headers, data := server.Get(context.Background(), path)
+	assert.Equal(t, 200, statusCode, path)
+	assert.Equal(t, "application/json", headers["Content-Type"])
+	var result map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &result))
+	return result
+}
+
+func TestTileMatrixSetLimits(t *testing.T) {
+	limits := tileMatrixSetLimits(HeaderV3{MinZoom: 0, MaxZoom: 2, MinLonE7: -1800000000, MinLatE7: -850000000, MaxLonE7: 1800000000, MaxLatE7: 850000000})
+	assert.Equal(t, []tileMatrixLimits{{0, 0, 0, 0, 0}, {1, 0, 0, 1, 1}, {2, 0, 0, 3, 3}}, limits)
+	limits = tileMatrixSetLimits(HeaderV3{MinZoom: 3, MaxZoom: 3, MinLonE7: 10000000, MinLatE7: 10000000, MaxLonE7: 10000000, MaxLatE7: 10000000})
+	assert.Equal(t, []tileMatrixLimits{{3, 4, 3, 4, 3}}, limits)
+}
+
+func TestOgcLanding(t *testing.T) {
+	server := ogcTestServer(t)
+	landing := getJSON(t, server, "/ogc")
+	assert.Len(t, landing["links"], 4)
+	conformance := getJSON(t, server, "/ogc/conformance")
+	assert.Contains(t, conformance["conformsTo"], "http://www.opengis.net/spec/ogcapi-tiles-1/1.0/conf/core")
+	tms := getJSON(t, server, "/ogc/tileMatrixSets/WebMercatorQuad")
+	assert.Equal(t, "WebMercatorQuad", tms["id"])
+	assert.Len(t, tms["tileMatrices"], webMercatorQuadMaxZoom+1)
+}
+
+func TestOgcCollections(t *testing.T) {
+	server := ogcTestServer(t)
+	collections := getJSON(t, server, "/ogc/collections")
+	assert.Len(t, collections["collections"], 1)
+
+	collection := getJSON(t, server, "/ogc/collections/archive")
+	assert.Equal(t, "archive", collection["id"])
+	assert.Equal(t, "vector", collection["dataType"])
+
+	tilesets := getJSON(t, server, "/ogc/collections/archive/tiles")
+	assert.Len(t, tilesets["tilesets"], 1)
+
+	tileset := getJSON(t, server, "/ogc/collections/archive/tiles/WebMercatorQuad")
+	assert.Equal(t, webMercatorQuadURI, tileset["tileMatrixSetURI"])
+	limits := tileset["tileMatrixSetLimits"].([]interface{})
+	assert.Len(t, limits, 3)
+	assert.Equal(t, map[string]interface{}{
+		"tileMatrix": "2",
+		"minTileRow": 0.0,
+		"maxTileRow": 1.0,
+		"minTileCol": 2.0,
+		"maxTileCol": 3.0,
+	}, limits[2])
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": "roads", "dataType": "vector"}}, tileset["layers"])
+
+	statusCode, headers, data := server.Get(context.Background(), "/ogc/collections/archive/tiles/WebMercatorQuad/2/1/3")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "application/x-protobuf", headers["Content-Type"])
+	assert.Equal(t, []byte{4, 5}, data)
+
+	statusCode, _, _ = server.Get(context.Background(), "/ogc/collections/missing")
+	assert.Equal(t, 404, statusCode)
+	statusCode, _, _ = server.Get(context.Background(), "/ogc/other")
+	assert.Equal(t, 404, statusCode)
+}
+
+func TestOgcRequiresPublicURL(t *testing.T) {
+	server := ogcTestServer(t)
+	server.publicURL = ""
+	statusCode, _, _ := server.Get(context.Background(), "/ogc")
+	assert.Equal(t, 501, statusCode)
+	statusCode, _, _ = server.Get(context.Background(), "/archive/WMTSCapabilities.xml")
+	assert.Equal(t, 501, statusCode)
+}
+
+func TestWMTSCapabilities(t *testing.T) {
+	server := ogcTestServer(t)
+	for _, path := range []string{"/archive/WMTSCapabilities.xml", "/WMTSCapabilities.xml"} {
+		statusCode, headers, data := server.Get(context.Background(), path)
+		assert.Equal(t, 200, statusCode)
+		assert.Equal(t, "application/xml", headers["Content-Type"])
+
+		var capabilities struct {
+			Layers []struct {
+				Identifier  string `xml:"Identifier"`
+				Format      string `xml:"Format"`
+				ResourceURL struct {
+					Template string `xml:"template,attr"`
+				} `xml:"ResourceURL"`
+				Limits []struct {
+					TileMatrix string `xml:"TileMatrix"`
+				} `xml:"TileMatrixSetLink>TileMatrixSetLimits>TileMatrixLimits"`
+			} `xml:"Contents>Layer"`
+			TileMatrices []string `xml:"Contents>TileMatrixSet>TileMatrix>Identifier"`
+		}
+		assert.Nil(t, xml.Unmarshal(data, &capabilities))
+		assert.Len(t, capabilities.Layers, 1)
+		assert.Equal(t, "archive", capabilities.Layers[0].Identifier)
+		assert.Equal(t, "application/x-protobuf", capabilities.Layers[0].Format)
+		assert.Equal(t, "tiles.example.com/archive/{TileMatrix}/{TileCol}/{TileRow}.mvt", capabilities.Layers[0].ResourceURL.Template)
+		assert.Len(t, capabilities.Layers[0].Limits, 3)
+		assert.Equal(t, []string{"0", "1", "2"}, capabilities.TileMatrices)
+	}
+
+	statusCode, _, _ := server.Get(context.Background(), "/missing/WMTSCapabilities.xml")
+	assert.Equal(t, 404, statusCode)
+}
@@ -0,0 +1,331 @@
+package pmtiles
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+	"golang.org/x/image/draw"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var errOverzoomUnsupported = errors.New("overzoom not supported for tile type")
+
+// overzoomTile derives the tile at x, y from the uncompressed data of its ancestor dz levels above.
+func overzoomTile(tileType TileType, data []byte, dz uint8, x uint32, y uint32) ([]byte, error) {
+	mask := uint32(1)<<dz - 1
+	switch tileType {
+	case Mvt:
+		return overzoomMvt(data, dz, x&mask, y&mask)
+	case Png, Jpeg:
+		return overzoomRaster(tileType, data, dz, x&mask, y&mask)
+	}
+	return nil, errOverzoomUnsupported
+}
+
+// overzoomRaster crops the quadrant at dx, dy of an ancestor raster tile and upscales it to the full tile size.
+func overzoomRaster(tileType TileType, data []byte, dz uint8, dx uint32, dy uint32) ([]byte, error) {
+	img, err := decodeRaster(data)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, fmt.Errorf("cannot overzoom an empty tile")
+	}
+	scale := 1 << dz
+	w, h := bounds.Dx(), bounds.Dy()
+	// quadrants smaller than a pixel sample the pixel containing them
+	x0, y0 := int(dx)*w/scale, int(dy)*h/scale
+	src := image.Rect(
+		x0,
+		y0,
+		max((int(dx)+1)*w/scale, x0+1),
+		max((int(dy)+1)*h/scale, y0+1),
+	).Add(bounds.Min)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return encodeRaster(dst, tileType)
+}
+
+// overzoomMvt rescales the layers of an ancestor MVT tile to the quadrant at dx, dy dz levels below,
+// clipping geometry to the extent of the quadrant plus a buffer. Empty features and layers are dropped.
+func overzoomMvt(data []byte, dz uint8, dx uint32, dy uint32) ([]byte, error) {
+	var out []byte
+	err := consumeFields(data, func(num protowire.Number, typ protowire.Type, layer []byte) error {
+		if num != 3 || typ != protowire.BytesType {
+			return nil
+		}
+		newLayer, err := overzoomMvtLayer(layer, dz, dx, dy)
+		if err != nil {
+			return err
+		}
+		if newLayer != nil {
+			out = protowire.AppendTag(out, 3, protowire.BytesType)
+			out = protowire.AppendBytes(out, newLayer)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func overzoomMvtLayer(layer []byte, dz uint8, dx uint32, dy uint32) ([]byte, error) {
+	extent := uint64(4096)
+	err := consumeFields(layer, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num == 5 && typ == protowire.VarintType {
+			extent, _ = protowire.ConsumeVarint(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scale := float64(uint64(1) << dz)
+	transform := func(p orb.Point) orb.Point {
+		return orb.Point{p[0]*scale - float64(dx)*float64(extent), p[1]*scale - float64(dy)*float64(extent)}
+	}
+	buffer := float64(extent) / 64
+	bound := orb.Bound{Min: orb.Point{-buffer, -buffer}, Max: orb.Point{float64(extent) + buffer, float64(extent) + buffer}}
+
+	var out []byte
+	features := 0
+	err = consumeFields(layer, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 2 || typ != protowire.BytesType {
+			out = appendField(out, num, typ, value)
+			return nil
+		}
+		newFeature, err := overzoomMvtFeature(value, transform, bound)
+		if err != nil {
+			return err
+		}
+		if newFeature != nil {
+			features++
+			out = protowire.AppendTag(out, 2, protowire.BytesType)
+			out = protowire.AppendBytes(out, newFeature)
+		}
+		return nil
+	})
+	if err != nil || features == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+func overzoomMvtFeature(feature []byte, transform func(orb.Point) orb.Point, bound orb.Bound) ([]byte, error) {
+	var geomType uint64
+	var geometry []byte
+	err := consumeFields(feature, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch {
+		case num == 3 && typ == protowire.VarintType:
+			geomType, _ = protowire.ConsumeVarint(value)
+		case num == 4 && typ == protowire.BytesType:
+			geometry = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := decodeMvtGeometry(geometry)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		for i, p := range path {
+			path[i] = transform(p)
+		}
+	}
+
+	var newGeometry []byte
+	switch geomType {
+	case 1:
+		newGeometry = encodeMvtPoints(clip.MultiPoint(bound, pathPoints(paths)))
+	case 2:
+		var lines orb.MultiLineString
+		for _, path := range paths {
+			lines = append(lines, clip.LineString(bound, path)...)
+		}
+		newGeometry = encodeMvtPaths(lines, false)
+	case 3:
+		newGeometry = encodeMvtPaths(clipMvtRings(paths, bound), true)
+	default:
+		return feature, nil
+	}
+	if newGeometry == nil {
+		return nil, nil
+	}
+
+	var out []byte
+	err = consumeFields(feature, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num == 4 && typ == protowire.BytesType {
+			value = newGeometry
+		}
+		out = appendField(out, num, typ, value)
+		return nil
+	})
+	return out, err
+}
+
+// appendField appends a field with a value as passed to consumeFields.
+func appendField(b []byte, num protowire.Number, typ protowire.Type, value []byte) []byte {
+	b = protowire.AppendTag(b, num, typ)
+	if typ == protowire.BytesType {
+		return protowire.AppendBytes(b, value)
+	}
+	return append(b, value...)
+}
+
+func pathPoints(paths []orb.LineString) orb.MultiPoint {
+	var points orb.MultiPoint
+	for _, path := range paths {
+		points = append(points, path...)
+	}
+	return points
+}
+
+// clipMvtRings clips polygon rings, dropping interior rings whose exterior ring was clipped away.
+// Exterior rings have a positive area in tile coordinates.
+func clipMvtRings(paths []orb.LineString, bound orb.Bound) []orb.LineString {
+	var rings []orb.LineString
+	keepInterior := false
+	for _, path := range paths {
+		if len(path) < 3 {
+			continue
+		}
+		exterior := mvtRingArea(path) > 0
+		if !exterior && !keepInterior {
+			continue
+		}
+		ring := append(orb.Ring{}, path...)
+		ring = append(ring, path[0])
+		clipped := clip.Ring(bound, ring)
+		if exterior {
+			keepInterior = len(clipped) >= 4
+		}
+		if len(clipped) >= 4 {
+			rings = append(rings, orb.LineString(clipped[:len(clipped)-1]))
+		}
+	}
+	return rings
+}
+
+func mvtRingArea(ring orb.LineString) float64 {
+	area := 0.0
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		area += ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return area / 2
+}
+
+// decodeMvtGeometry returns the paths started by each MoveTo command of an MVT geometry.
+func decodeMvtGeometry(geometry []byte) ([]orb.LineString, error) {
+	var paths []orb.LineString
+	var x, y int64
+	for len(geometry) > 0 {
+		command, n := protowire.ConsumeVarint(geometry)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		geometry = geometry[n:]
+		id, count := command&0x7, command>>3
+		switch id {
+		case 1, 2:
+			for i := uint64(0); i < count; i++ {
+				px, n := protowire.ConsumeVarint(geometry)
+				if n < 0 {
+					return nil, protowire.ParseError(n)
+				}
+				geometry = geometry[n:]
+				py, n := protowire.ConsumeVarint(geometry)
+				if n < 0 {
+					return nil, protowire.ParseError(n)
+				}
+				geometry = geometry[n:]
+				x += protowire.DecodeZigZag(px)
+				y += protowire.DecodeZigZag(py)
+				if id == 1 {
+					paths = append(paths, nil)
+				} else if len(paths) == 0 {
+					return nil, errors.New("LineTo before MoveTo")
+				}
+				paths[len(paths)-1] = append(paths[len(paths)-1], orb.Point{float64(x), float64(y)})
+			}
+		case 7:
+		default:
+			return nil, fmt.Errorf("unknown geometry command %d", id)
+		}
+	}
+	return paths, nil
+}
+
+type mvtGeometryEncoder struct {
+	out  []byte
+	x, y int64
+}
+
+func (e *mvtGeometryEncoder) command(id uint64, count int) {
+	e.out = protowire.AppendVarint(e.out, id|uint64(count)<<3)
+}
+
+func (e *mvtGeometryEncoder) point(x, y int64) {
+	e.out = protowire.AppendVarint(e.out, protowire.EncodeZigZag(x-e.x))
+	e.out = protowire.AppendVarint(e.out, protowire.EncodeZigZag(y-e.y))
+	e.x, e.y = x, y
+}
+
+func roundPoint(p orb.Point) (int64, int64) {
+	return int64(math.Round(p[0])), int64(math.Round(p[1]))
+}
+
+func encodeMvtPoints(points orb.MultiPoint) []byte {
+	if len(points) == 0 {
+		return nil
+	}
+	var e mvtGeometryEncoder
+	e.command(1, len(points))
+	for _, p := range points {
+		e.point(roundPoint(p))
+	}
+	return e.out
+}
+
+// encodeMvtPaths encodes lines or polygon rings after rounding, skipping paths that become degenerate.
+func encodeMvtPaths(paths []orb.LineString, closed bool) []byte {
+	minPoints := 2
+	if closed {
+		minPoints = 3
+	}
+	var e mvtGeometryEncoder
+	for _, path := range paths {
+		var rounded [][2]int64
+		for _, p := range path {
+			x, y := roundPoint(p)
+			if len(rounded) > 0 && rounded[len(rounded)-1] == [2]int64{x, y} {
+				continue
+			}
+			rounded = append(rounded, [2]int64{x, y})
+		}
+		if closed && len(rounded) > 1 && rounded[0] == rounded[len(rounded)-1] {
+			rounded = rounded[:len(rounded)-1]
+		}
+		if len(rounded) < minPoints {
+			continue
+		}
+		e.command(1, 1)
+		e.point(rounded[0][0], rounded[0][1])
+		e.command(2, len(rounded)-1)
+		for _, p := range rounded[1:] {
+			e.point(p[0], p[1])
+		}
+		if closed {
+			e.command(7, 1)
+		}
+	}
+	return e.out
+}
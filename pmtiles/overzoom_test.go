@@ -0,0 +1,183 @@
+package pmtiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func testMvtTile(geomType uint64, paths []orb.LineString, closed bool) []byte {
+	var geometry []byte
+	if geomType == 1 {
+		geometry = encodeMvtPoints(pathPoints(paths))
+	} else {
+		geometry = encodeMvtPaths(paths, closed)
+	}
+
+	var feature []byte
+	feature = protowire.AppendTag(feature, 1, protowire.VarintType)
+	feature = protowire.AppendVarint(feature, 7)
+	feature = protowire.AppendTag(feature, 3, protowire.VarintType)
+	feature = protowire.AppendVarint(feature, geomType)
+	feature = protowire.AppendTag(feature, 4, protowire.BytesType)
+	feature = protowire.AppendBytes(feature, geometry)
+
+	var layer []byte
+	layer = protowire.AppendTag(layer, 15, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, 2)
+	layer = protowire.AppendTag(layer, 1, protowire.BytesType)
+	layer = protowire.AppendString(layer, "layer")
+	layer = protowire.AppendTag(layer, 2, protowire.BytesType)
+	layer = protowire.AppendBytes(layer, feature)
+	layer = protowire.AppendTag(layer, 5, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, 4096)
+
+	var tile []byte
+	tile = protowire.AppendTag(tile, 3, protowire.BytesType)
+	return protowire.AppendBytes(tile, layer)
+}
+
+// testMvtGeometry returns the decoded geometry of the only feature of a tile.
+func testMvtGeometry(t *testing.T, tile []byte) []orb.LineString {
+	var paths []orb.LineString
+	assert.Nil(t, verifyMvt(tile))
+	consumeFields(tile, func(_ protowire.Number, _ protowire.Type, layer []byte) error {
+		return consumeFields(layer, func(num protowire.Number, _ protowire.Type, feature []byte) error {
+			if num != 2 {
+				return nil
+			}
+			return consumeFields(feature, func(num protowire.Number, _ protowire.Type, geometry []byte) error {
+				if num == 4 {
+					var err error
+					paths, err = decodeMvtGeometry(geometry)
+					assert.Nil(t, err)
+				}
+				return nil
+			})
+		})
+	})
+	return paths
+}
+
+func TestMvtGeometryRoundtrip(t *testing.T) {
+	paths := []orb.LineString{{{1, 2}, {10, 2}, {10, 20}}, {{-5, -5}, {0, 0}}}
+	decoded, err := decodeMvtGeometry(encodeMvtPaths(paths, false))
+	assert.Nil(t, err)
+	assert.Equal(t, paths, decoded)
+
+	_, err = decodeMvtGeometry([]byte{0x0a})
+	assert.NotNil(t, err)
+}
+
+func TestOverzoomMvtLine(t *testing.T) {
+	tile := testMvtTile(2, []orb.LineString{{{0, 0}, {4096, 4096}}}, false)
+	child, err := overzoomTile(Mvt, tile, 1, 1, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []orb.LineString{{{-64, -64}, {4096, 4096}}}, testMvtGeometry(t, child))
+
+	child, err = overzoomTile(Mvt, tile, 2, 3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(child))
+}
+
+func TestOverzoomMvtPoints(t *testing.T) {
+	tile := testMvtTile(1, []orb.LineString{{{100, 100}, {3000, 3000}}}, false)
+	child, err := overzoomTile(Mvt, tile, 2, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []orb.LineString{{{400, 400}}}, testMvtGeometry(t, child))
+}
+
+func TestOverzoomMvtPolygon(t *testing.T) {
+	exterior := orb.LineString{{0, 0}, {4096, 0}, {4096, 4096}, {0, 4096}}
+	hole := orb.LineString{{100, 100}, {100, 200}, {200, 200}, {200, 100}}
+	assert.True(t, mvtRingArea(exterior) > 0)
+	assert.True(t, mvtRingArea(hole) < 0)
+
+	tile := testMvtTile(3, []orb.LineString{exterior, hole}, true)
+	child, err := overzoomTile(Mvt, tile, 1, 1, 1)
+	assert.Nil(t, err)
+	paths := testMvtGeometry(t, child)
+	assert.Equal(t, 1, len(paths))
+	assert.Equal(t, orb.Bound{Min: orb.Point{-64, -64}, Max: orb.Point{4096, 4096}}, paths[0].Bound())
+
+	child, err = overzoomTile(Mvt, tile, 1, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(testMvtGeometry(t, child)))
+}
+
+func TestOverzoomRaster(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if x >= 2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	var b bytes.Buffer
+	assert.Nil(t, png.Encode(&b, img))
+
+	child, err := overzoomTile(Png, b.Bytes(), 1, 1, 0)
+	assert.Nil(t, err)
+	decoded, err := png.Decode(bytes.NewReader(child))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 4, 4), decoded.Bounds())
+	r, g, bl, _ := decoded.At(3, 3).RGBA()
+	assert.Equal(t, []uint32{0xffff, 0, 0}, []uint32{r, g, bl})
+
+	child, err = overzoomTile(Png, b.Bytes(), 4, 15, 0)
+	assert.Nil(t, err)
+	decoded, err = png.Decode(bytes.NewReader(child))
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 4, 4), decoded.Bounds())
+	r, g, bl, _ = decoded.At(0, 0).RGBA()
+	assert.Equal(t, []uint32{0xffff, 0, 0}, []uint32{r, g, bl})
+
+	_, err = overzoomTile(Webp, b.Bytes(), 1, 1, 0)
+	assert.NotNil(t, err)
+}
+
+func TestServerOverzoom(t *testing.T) {
+	mockBucket, server := newServer(t)
+	tile := testMvtTile(2, []orb.LineString{{{0, 0}, {4096, 4096}}}, false)
+	mockBucket.items["archive.pmtiles"] = fakeArchive(HeaderV3{TileType: Mvt}, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: gzipTile(t, tile),
+	}, false, Gzip)
+
+	statusCode, _, _ := server.Get(context.Background(), "/archive/1/1/1.mvt")
+	assert.Equal(t, 404, statusCode)
+
+	server.SetOverzoom(2)
+	statusCode, headers, data := server.Get(context.Background(), "/archive/1/1/1.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "gzip", headers["Content-Encoding"])
+	decompressed, err := decompressBytes(data, Gzip)
+	assert.Nil(t, err)
+	assert.Equal(t, []orb.LineString{{{-64, -64}, {4096, 4096}}}, testMvtGeometry(t, decompressed))
+
+	statusCode, _, _ = server.Get(context.Background(), "/archive/2/3/3.mvt")
+	assert.Equal(t, 200, statusCode)
+	statusCode, _, _ = server.Get(context.Background(), "/archive/3/7/7.mvt")
+	assert.Equal(t, 404, statusCode)
+
+	statusCode, _, data = server.Get(context.Background(), "/archive.json")
+	assert.Equal(t, 200, statusCode)
+	var tilejson map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &tilejson))
+	assert.Equal(t, float64(2), tilejson["maxzoom"])
+
+	server.SetOverzoom(255)
+	assert.Equal(t, uint8(MaxOverzoomLevels), server.overzoom)
+}
@@ -0,0 +1,46 @@
+package pmtiles
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/webp"
+)
+
+const jpegQuality = 90
+
+var errUnsupportedRasterEncoding = errors.New("raster encoding not supported")
+
+// decodeRaster decodes a PNG, JPEG or WebP tile, detecting the format from its contents.
+func decodeRaster(data []byte) (image.Image, error) {
+	switch magicToTileType(data) {
+	case Png:
+		return png.Decode(bytes.NewReader(data))
+	case Jpeg:
+		return jpeg.Decode(bytes.NewReader(data))
+	case Webp:
+		return webp.Decode(bytes.NewReader(data))
+	}
+	return nil, errors.New("unsupported raster format")
+}
+
+// encodeRaster encodes an image as a PNG or JPEG tile; WebP encoding is not supported.
+func encodeRaster(img image.Image, tileType TileType) ([]byte, error) {
+	var b bytes.Buffer
+	var err error
+	switch tileType {
+	case Png:
+		err = png.Encode(&b, img)
+	case Jpeg:
+		err = jpeg.Encode(&b, img, &jpeg.Options{Quality: jpegQuality})
+	default:
+		return nil, errUnsupportedRasterEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
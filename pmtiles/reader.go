@@ -0,0 +1,185 @@
+package pmtiles
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DirectoryCache stores deserialized directories of a single archive,
+// keyed by their byte range within the archive.
+type DirectoryCache interface {
+	Get(offset uint64, length uint64) ([]EntryV3, bool)
+	Put(offset uint64, length uint64, directory []EntryV3)
+}
+
+type directoryRange struct {
+	offset uint64
+	length uint64
+}
+
+type lruDirectoryCache struct {
+	mu  sync.Mutex
+	lru *lru[directoryRange, []EntryV3]
+}
+
+// NewDirectoryCache returns an in-memory DirectoryCache holding at most maxDirectories directories.
+func NewDirectoryCache(maxDirectories int) DirectoryCache {
+	return &lruDirectoryCache{lru: newLRU[directoryRange, []EntryV3](int64(maxDirectories), nil)}
+}
+
+func (c *lruDirectoryCache) Get(offset uint64, length uint64) ([]EntryV3, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.get(directoryRange{offset, length})
+}
+
+func (c *lruDirectoryCache) Put(offset uint64, length uint64, directory []EntryV3) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.add(directoryRange{offset, length}, directory)
+}
+
+// Reader provides random access to the tiles and metadata of a single archive in a Bucket.
+type Reader struct {
+	bucket Bucket
+	key    string
+	header HeaderV3
+	etag   string
+	cache  DirectoryCache
+}
+
+// NewReader opens the archive at key in bucket, reading its header and root directory.
+// Directories are cached in cache, or a small in-memory cache if nil.
+func NewReader(ctx context.Context, bucket Bucket, key string, cache DirectoryCache) (*Reader, error) {
+	r, etag, _, err := bucket.NewRangeReaderEtag(ctx, key, 0, 16384, "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create range reader for %s, %w", key, err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", key, err)
+	}
+	if len(b) < HeaderV3LenBytes {
+		return nil, fmt.Errorf("Failed to read %s, archive is too short", key)
+	}
+
+	header, err := DeserializeHeader(b[0:HeaderV3LenBytes])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", key, err)
+	}
+
+	if cache == nil {
+		cache = NewDirectoryCache(64)
+	}
+
+	reader := &Reader{bucket: bucket, key: key, header: header, etag: etag, cache: cache}
+
+	if header.RootOffset+header.RootLength <= uint64(len(b)) {
+		rootEntries := DeserializeEntries(bytes.NewBuffer(b[header.RootOffset:header.RootOffset+header.RootLength]), header.InternalCompression)
+		cache.Put(header.RootOffset, header.RootLength, rootEntries)
+	}
+
+	return reader, nil
+}
+
+// Header returns the header of the archive.
+func (r *Reader) Header() HeaderV3 {
+	return r.header
+}
+
+func (r *Reader) readRange(ctx context.Context, offset uint64, length uint64) ([]byte, error) {
+	reader, _, _, err := r.bucket.NewRangeReaderEtag(ctx, r.key, int64(offset), int64(length), r.etag)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// MetadataBytes returns the decompressed JSON metadata of the archive.
+func (r *Reader) MetadataBytes(ctx context.Context) ([]byte, error) {
+	reader, _, _, err := r.bucket.NewRangeReaderEtag(ctx, r.key, int64(r.header.MetadataOffset), int64(r.header.MetadataLength), r.etag)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return DeserializeMetadataBytes(reader, r.header.InternalCompression)
+}
+
+// Metadata returns the parsed JSON metadata of the archive.
+func (r *Reader) Metadata(ctx context.Context) (map[string]interface{}, error) {
+	reader, _, _, err := r.bucket.NewRangeReaderEtag(ctx, r.key, int64(r.header.MetadataOffset), int64(r.header.MetadataLength), r.etag)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return DeserializeMetadata(reader, r.header.InternalCompression)
+}
+
+func (r *Reader) getDirectory(ctx context.Context, offset uint64, length uint64) ([]EntryV3, error) {
+	if directory, ok := r.cache.Get(offset, length); ok {
+		return directory, nil
+	}
+	b, err := r.readRange(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	directory := DeserializeEntries(bytes.NewBuffer(b), r.header.InternalCompression)
+	r.cache.Put(offset, length, directory)
+	return directory, nil
+}
+
+// FindEntry returns the directory entry addressing tileID, if any.
+func (r *Reader) FindEntry(ctx context.Context, tileID uint64) (EntryV3, bool, error) {
+	dirOffset, dirLength := r.header.RootOffset, r.header.RootLength
+
+	for depth := 0; depth <= 3; depth++ {
+		directory, err := r.getDirectory(ctx, dirOffset, dirLength)
+		if err != nil {
+			return EntryV3{}, false, err
+		}
+		entry, ok := FindTile(directory, tileID)
+		if !ok {
+			return EntryV3{}, false, nil
+		}
+		if entry.RunLength > 0 {
+			return entry, true, nil
+		}
+		dirOffset = r.header.LeafDirectoryOffset + entry.Offset
+		dirLength = uint64(entry.Length)
+	}
+	return EntryV3{}, false, nil
+}
+
+// GetTileByID returns the stored bytes of the tile with the given Hilbert TileID.
+// The second return value is false if the archive does not contain the tile.
+func (r *Reader) GetTileByID(ctx context.Context, tileID uint64) ([]byte, bool, error) {
+	entry, ok, err := r.FindEntry(ctx, tileID)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	data, err := r.readRange(ctx, r.header.TileDataOffset+entry.Offset, uint64(entry.Length))
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// GetTile returns the stored bytes of the tile at z, x, y.
+// The tile is returned as stored in the archive; see Header().TileCompression.
+func (r *Reader) GetTile(ctx context.Context, z uint8, x uint32, y uint32) ([]byte, bool, error) {
+	return r.GetTileByID(ctx, ZxyToID(z, x, y))
+}
+
+// IterateEntries calls operation for every tile entry in the archive, in TileID order.
+func (r *Reader) IterateEntries(ctx context.Context, operation func(EntryV3)) error {
+	return IterateEntries(r.header,
+		func(offset uint64, length uint64) ([]byte, error) {
+			return r.readRange(ctx, offset, length)
+		},
+		operation)
+}
@@ -0,0 +1,68 @@
+package pmtiles
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestReaderGetTile(t *testing.T) {
+	bucket := mockBucket{make(map[string][]byte)}
+	header := HeaderV3{
+		TileType: Mvt,
+	}
+	bucket.items["archive.pmtiles"] = fakeArchive(header, map[string]interface{}{"name": "test"}, map[Zxy][]byte{
+		{0, 0, 0}: {0, 1, 2, 3},
+		{4, 1, 2}: {1, 2, 3},
+	}, true, Gzip)
+
+	ctx := context.Background()
+	reader, err := NewReader(ctx, bucket, "archive.pmtiles", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, TileType(Mvt), reader.Header().TileType)
+
+	metadata, err := reader.Metadata(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", metadata["name"])
+
+	data, ok, err := reader.GetTile(ctx, 0, 0, 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0, 1, 2, 3}, data)
+
+	data, ok, err = reader.GetTile(ctx, 4, 1, 2)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1, 2, 3}, data)
+
+	_, ok, err = reader.GetTile(ctx, 3, 1, 2)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	tileIDs := make([]uint64, 0)
+	err = reader.IterateEntries(ctx, func(e EntryV3) {
+		tileIDs = append(tileIDs, e.TileID)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0, ZxyToID(4, 1, 2)}, tileIDs)
+}
+
+func TestReaderNotFound(t *testing.T) {
+	bucket := mockBucket{make(map[string][]byte)}
+	_, err := NewReader(context.Background(), bucket, "missing.pmtiles", nil)
+	assert.NotNil(t, err)
+}
+
+func TestDirectoryCacheEviction(t *testing.T) {
+	cache := NewDirectoryCache(2)
+	cache.Put(0, 10, []EntryV3{{TileID: 1}})
+	cache.Put(10, 10, []EntryV3{{TileID: 2}})
+	_, ok := cache.Get(0, 10)
+	assert.True(t, ok)
+	cache.Put(20, 10, []EntryV3{{TileID: 3}})
+	_, ok = cache.Get(10, 10)
+	assert.False(t, ok)
+	directory, ok := cache.Get(0, 10)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(1), directory[0].TileID)
+}
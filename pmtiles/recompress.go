@@ -0,0 +1,171 @@
+package pmtiles
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const recompressBatchSize = 4096
+
+func parseCompressionOption(s string) (Compression, error) {
+	if s == "" {
+		return UnknownCompression, nil
+	}
+	compression := stringToCompression(s)
+	if compression == UnknownCompression {
+		return compression, fmt.Errorf("unknown compression %s", s)
+	}
+	return compression, nil
+}
+
+// Recompress rewrites a local archive with new tile and internal compressions.
+// Compressions are one of none, gzip, br or zstd; an empty string keeps the existing compression.
+// Tile contents are decoded and re-encoded in parallel.
+func Recompress(logger *log.Logger, input string, output string, tileCompressionString string, internalCompressionString string, threads int) error {
+	start := time.Now()
+
+	tileCompression, err := parseCompressionOption(tileCompressionString)
+	if err != nil {
+		return err
+	}
+	internalCompression, err := parseCompressionOption(internalCompressionString)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := make([]byte, HeaderV3LenBytes)
+	_, err = io.ReadFull(file, buf)
+	if err != nil {
+		return err
+	}
+
+	header, err := DeserializeHeader(buf)
+	if err != nil {
+		return err
+	}
+
+	if tileCompression == UnknownCompression {
+		tileCompression = header.TileCompression
+	}
+	if internalCompression == UnknownCompression {
+		internalCompression = header.InternalCompression
+	}
+	if internalCompression == UnknownCompression {
+		return fmt.Errorf("archive has unknown internal compression")
+	}
+	if tileCompression != header.TileCompression && header.TileCompression == UnknownCompression {
+		return fmt.Errorf("cannot recompress tiles with unknown compression")
+	}
+
+	metadataReader := io.NewSectionReader(file, int64(header.MetadataOffset), int64(header.MetadataLength))
+	metadata, err := DeserializeMetadata(metadataReader, header.InternalCompression)
+	if err != nil {
+		return fmt.Errorf("Failed to read metadata, %w", err)
+	}
+
+	entries := make([]EntryV3, 0, header.TileEntriesCount)
+	err = IterateEntries(header,
+		func(offset uint64, length uint64) ([]byte, error) {
+			return io.ReadAll(io.NewSectionReader(file, int64(offset), int64(length)))
+		},
+		func(e EntryV3) {
+			entries = append(entries, e)
+		})
+	if err != nil {
+		return fmt.Errorf("Failed to read directories, %w", err)
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("no tiles in archive")
+	}
+
+	tmpfile, err := os.CreateTemp("", "pmtiles")
+	if err != nil {
+		return fmt.Errorf("Failed to create temp file, %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	if threads < 1 {
+		threads = 1
+	}
+
+	resolve := newResolver(true, false)
+	bar := defaultProgressbar(logger, int64(len(entries)))
+
+	for batchStart := 0; batchStart < len(entries); batchStart += recompressBatchSize {
+		batch := entries[batchStart:min(batchStart+recompressBatchSize, len(entries))]
+
+		// each distinct content in the batch is only re-encoded once
+		seen := make(map[uint64]bool)
+		recompressed := make(map[uint64][]byte)
+		var mu sync.Mutex
+
+		group := new(errgroup.Group)
+		group.SetLimit(threads)
+		for _, e := range batch {
+			if seen[e.Offset] {
+				continue
+			}
+			seen[e.Offset] = true
+			offset, length := e.Offset, e.Length
+			group.Go(func() error {
+				data := make([]byte, length)
+				_, err := file.ReadAt(data, int64(header.TileDataOffset+offset))
+				if err != nil {
+					return fmt.Errorf("Failed to read tile data, %w", err)
+				}
+				if tileCompression != header.TileCompression {
+					data, err = decompressBytes(data, header.TileCompression)
+					if err != nil {
+						return fmt.Errorf("Failed to decompress tile at offset %d, %w", offset, err)
+					}
+					data, err = compressBytes(data, tileCompression)
+					if err != nil {
+						return fmt.Errorf("Failed to compress tile at offset %d, %w", offset, err)
+					}
+				}
+				mu.Lock()
+				recompressed[offset] = data
+				mu.Unlock()
+				return nil
+			})
+		}
+		if err := group.Wait(); err != nil {
+			return err
+		}
+
+		for _, e := range batch {
+			if isNew, newData := resolve.AddTileIsNew(e.TileID, recompressed[e.Offset], e.RunLength); isNew {
+				_, err := tmpfile.Write(newData)
+				if err != nil {
+					return fmt.Errorf("Failed to write to tempfile, %w", err)
+				}
+			}
+			bar.Add(1)
+		}
+	}
+
+	header.Clustered = true
+	header.TileCompression = tileCompression
+	header.InternalCompression = internalCompression
+	_, err = finalize(logger, resolve, header, tmpfile, output, metadata)
+	if err != nil {
+		return err
+	}
+
+	logger.Println("Finished in ", time.Since(start))
+	return nil
+}
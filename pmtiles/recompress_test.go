@@ -0,0 +1,44 @@
+package pmtiles
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecompressZstd(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "zstd.pmtiles")
+	err := Recompress(logger, "fixtures/test_fixture_1.pmtiles", output, "zstd", "zstd", 2)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	original := readerForFile(t, "fixtures/test_fixture_1.pmtiles")
+	recompressed := readerForFile(t, output)
+	assert.Equal(t, Compression(Zstd), recompressed.Header().TileCompression)
+	assert.Equal(t, Compression(Zstd), recompressed.Header().InternalCompression)
+	assert.Equal(t, original.Header().AddressedTilesCount, recompressed.Header().AddressedTilesCount)
+	assert.Equal(t, original.Header().TileContentsCount, recompressed.Header().TileContentsCount)
+
+	metadata, err := recompressed.Metadata(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "tippecanoe v2.5.0", metadata["generator"])
+
+	err = original.IterateEntries(ctx, func(e EntryV3) {
+		z, x, y := IDToZxy(e.TileID)
+		expected, _, _ := original.GetTile(ctx, z, x, y)
+		expected, _ = decompressBytes(expected, Gzip)
+		actual, ok, _ := recompressed.GetTile(ctx, z, x, y)
+		assert.True(t, ok)
+		actual, err := decompressBytes(actual, Zstd)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, actual)
+	})
+	assert.Nil(t, err)
+}
+
+func TestRecompressUnknownCompression(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.pmtiles")
+	err := Recompress(logger, "fixtures/test_fixture_1.pmtiles", output, "lzma", "", 1)
+	assert.NotNil(t, err)
+}
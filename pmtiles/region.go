@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -75,3 +76,18 @@ func UnmarshalRegion(data []byte) (orb.MultiPolygon, error) {
 
 	return nil, fmt.Errorf("No geometry")
 }
+
+// loadRegion reads a region from either a GeoJSON file or a bbox string.
+func loadRegion(regionFile string, bbox string) (orb.MultiPolygon, error) {
+	if regionFile != "" && bbox != "" {
+		return nil, fmt.Errorf("only one of region and bbox can be specified")
+	}
+	if regionFile != "" {
+		dat, err := os.ReadFile(regionFile)
+		if err != nil {
+			return nil, err
+		}
+		return UnmarshalRegion(dat)
+	}
+	return BboxRegion(bbox)
+}
@@ -13,6 +13,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -34,6 +35,7 @@ type cachedValue struct {
 	header    HeaderV3
 	directory []EntryV3
 	etag      string
+	modTime   time.Time // of the archive, set for the header
 	ok        bool
 	badEtag   bool
 }
@@ -47,12 +49,27 @@ type response struct {
 
 // Server is an HTTP server for tiles and metadata.
 type Server struct {
-	reqs      chan request
-	bucket    Bucket
-	logger    *log.Logger
-	cacheSize int
-	publicURL string
-	metrics   *metrics
+	reqs       chan request
+	bucket     Bucket
+	logger     *log.Logger
+	cacheSize  int
+	publicURL  string
+	metrics    *metrics
+	composites map[string][]string
+	overzoom   uint8
+	transcoder *transcodeCache
+	tileCache  TileCache
+
+	sharedDirCache SharedDirectoryCache
+	viewer         bool
+	aliases        atomic.Pointer[map[string]string]
+	listing        atomic.Pointer[bucketListing]
+	archives       []ArchiveConfig
+	cacheControl   CacheControlPolicy
+	auth           bool
+	signingKey     []byte
+	apiKeys        []string
+	signedURLTTL   time.Duration
 }
 
 // NewServer creates a new pmtiles HTTP server.
@@ -92,6 +109,67 @@ func NewServerWithBucket(bucket Bucket, _ string, logger *log.Logger, cacheSize
 	return l, nil
 }
 
+// SetOverzoom serves tiles up to levels zoom levels beyond the maxzoom of each archive,
+// derived from the ancestor tile at maxzoom. Only MVT, PNG and JPEG archives are overzoomed.
+// Levels are limited to MaxOverzoomLevels.
+func (server *Server) SetOverzoom(levels uint8) {
+	server.overzoom = min(levels, MaxOverzoomLevels)
+}
+
+// SetRasterTranscoding serves PNG, JPEG and WebP archives as PNG or JPEG tiles by the requested extension,
+// keeping up to cacheEntries transcoded tiles in memory.
+// WebP and AVIF cannot be encoded, so requests for them from other formats are answered with 406 Not Acceptable.
+func (server *Server) SetRasterTranscoding(cacheEntries int) {
+	server.transcoder = newTranscodeCache(cacheEntries)
+}
+
+// SetTileCache caches the bytes of fetched tiles in cache, which is purged when an archive's etag changes.
+// Cached tiles are served without a bucket request, so a changed archive is detected by the next request that misses the cache.
+func (server *Server) SetTileCache(cache TileCache) {
+	server.tileCache = cache
+}
+
+// SetSharedDirectoryCache shares fetched headers and directories with other servers through cache.
+// Directories are still cached in memory by each server up to its cache size.
+func (server *Server) SetSharedDirectoryCache(cache SharedDirectoryCache) {
+	server.sharedDirCache = cache
+}
+
+// getSharedDirectory returns the bytes, etag and archive modification time of a header or directory
+// from the shared cache, ignoring a cached root whose etag is being purged.
+func (server *Server) getSharedDirectory(ctx context.Context, key cacheKey, kind string, purgeEtag string) ([]byte, string, time.Time, bool) {
+	if server.sharedDirCache == nil {
+		return nil, "", time.Time{}, false
+	}
+	value, ok, err := server.sharedDirCache.Get(ctx, key.sharedKey())
+	if err != nil {
+		server.logger.Printf("failed to get %s %d-%d from directory cache, %v", key.name, key.offset, key.length, err)
+		server.metrics.sharedDirCacheRequest(key.name, kind, "error")
+		return nil, "", time.Time{}, false
+	}
+	if !ok {
+		server.metrics.sharedDirCacheRequest(key.name, kind, "miss")
+		return nil, "", time.Time{}, false
+	}
+	etag, modTime, data, err := decodeSharedDirectory(value)
+	if err != nil || (len(purgeEtag) > 0 && etag == purgeEtag) {
+		server.metrics.sharedDirCacheRequest(key.name, kind, "miss")
+		return nil, "", time.Time{}, false
+	}
+	server.metrics.sharedDirCacheRequest(key.name, kind, "hit")
+	return data, etag, modTime, true
+}
+
+func (server *Server) setSharedDirectory(ctx context.Context, key cacheKey, kind string, etag string, modTime time.Time, data []byte) {
+	if server.sharedDirCache == nil {
+		return
+	}
+	if err := server.sharedDirCache.Set(ctx, key.sharedKey(), encodeSharedDirectory(etag, modTime, data)); err != nil {
+		server.logger.Printf("failed to set %s %d-%d in directory cache, %v", key.name, key.offset, key.length, err)
+		server.metrics.sharedDirCacheRequest(key.name, kind, "error")
+	}
+}
+
 // Start the server HTTP listener.
 func (server *Server) Start() {
 
@@ -121,6 +199,13 @@ func (server *Server) Start() {
 						}
 					}
 					server.metrics.updateCacheStats(totalSize, len(cache))
+					if server.tileCache != nil {
+						server.tileCache.Purge(req.key.name, req.purgeEtag)
+					}
+					server.clearListing()
+					if req.value == nil {
+						continue
+					}
 				}
 				key := req.key
 				isRoot := (key.offset == 0 && key.length == 0)
@@ -150,34 +235,49 @@ func (server *Server) Start() {
 						}
 
 						status := ""
-						tracker := server.metrics.startBucketRequest(key.name, kind)
-						defer func() { tracker.finish(ctx, status) }()
-
-						server.logger.Printf("fetching %s %d-%d", key.name, offset, length)
-						r, etag, statusCode, err := server.bucket.NewRangeReaderEtag(ctx, key.name+".pmtiles", offset, length, key.etag)
-						status = strconv.Itoa(statusCode)
-
-						if err != nil {
-							ok = false
-							result.badEtag = isRefreshRequiredError(err)
-							resps <- response{key: key, value: result}
-							server.logger.Printf("failed to fetch %s %d-%d, %v", key.name, key.offset, key.length, err)
-							return
-						}
-						defer r.Close()
-						b, err := io.ReadAll(r)
-						if err != nil {
-							ok = false
-							status = "error"
-							resps <- response{key: key, value: result}
-							server.logger.Printf("failed to fetch %s %d-%d, %v", key.name, key.offset, key.length, err)
-							return
+						var tracker *bucketRequestTracker
+						defer func() {
+							if tracker != nil {
+								tracker.finish(ctx, status)
+							}
+						}()
+
+						b, etag, modTime, shared := server.getSharedDirectory(ctx, key, kind, req.purgeEtag)
+						if !shared {
+							tracker = server.metrics.startBucketRequest(key.name, kind)
+							server.logger.Printf("fetching %s %d-%d", key.name, offset, length)
+							r, rangeEtag, statusCode, err := server.bucket.NewRangeReaderEtag(ctx, key.name+".pmtiles", offset, length, key.etag)
+							status = strconv.Itoa(statusCode)
+
+							if err != nil {
+								result.badEtag = isRefreshRequiredError(err)
+								resps <- response{key: key, value: result}
+								server.logger.Printf("failed to fetch %s %d-%d, %v", key.name, key.offset, key.length, err)
+								return
+							}
+							defer r.Close()
+							b, err = io.ReadAll(r)
+							if err != nil {
+								status = "error"
+								resps <- response{key: key, value: result}
+								server.logger.Printf("failed to fetch %s %d-%d, %v", key.name, key.offset, key.length, err)
+								return
+							}
+							etag, modTime = rangeEtag, readerModTime(r)
+							server.setSharedDirectory(ctx, key, kind, etag, modTime, b)
 						}
 
 						if isRoot {
+							if len(b) < HeaderV3LenBytes {
+								status = "error"
+								resps <- response{key: key, value: result}
+								server.logger.Printf("parsing header failed: file %s is too short", key.name)
+								return
+							}
 							header, err := DeserializeHeader(b[0:HeaderV3LenBytes])
 							if err != nil {
 								status = "error"
+								resps <- response{key: key, value: result}
 								server.logger.Printf("parsing header failed: %v", err)
 								return
 							}
@@ -186,10 +286,10 @@ func (server *Server) Start() {
 							rootEntries := DeserializeEntries(bytes.NewBuffer(b[header.RootOffset:header.RootOffset+header.RootLength]), header.InternalCompression)
 							result2 := cachedValue{directory: rootEntries, ok: true, etag: etag}
 
-							rootKey := cacheKey{name: key.name, offset: header.RootOffset, length: header.RootLength}
+							rootKey := cacheKey{name: key.name, offset: header.RootOffset, length: header.RootLength, etag: etag}
 							resps <- response{key: rootKey, value: result2, size: 24 * len(rootEntries), ok: true}
 
-							result = cachedValue{header: header, ok: true, etag: etag}
+							result = cachedValue{header: header, ok: true, etag: etag, modTime: modTime}
 							resps <- response{key: key, value: result, size: 127, ok: true}
 						} else {
 							directory := DeserializeEntries(bytes.NewBuffer(b), req.compression)
@@ -233,22 +333,23 @@ func (server *Server) Start() {
 	}()
 }
 
-func (server *Server) getHeaderMetadata(ctx context.Context, name string) (bool, HeaderV3, []byte, error) {
-	found, header, metadataBytes, purgeEtag, err := server.getHeaderMetadataAttempt(ctx, name, "")
+// getHeaderMetadata returns the header and metadata of an archive, and when it was last modified if known.
+func (server *Server) getHeaderMetadata(ctx context.Context, name string) (bool, HeaderV3, []byte, time.Time, error) {
+	found, header, metadataBytes, modTime, purgeEtag, err := server.getHeaderMetadataAttempt(ctx, name, "")
 	if len(purgeEtag) > 0 {
-		found, header, metadataBytes, _, err = server.getHeaderMetadataAttempt(ctx, name, purgeEtag)
+		found, header, metadataBytes, modTime, _, err = server.getHeaderMetadataAttempt(ctx, name, purgeEtag)
 	}
-	return found, header, metadataBytes, err
+	return found, header, metadataBytes, modTime, err
 }
 
-func (server *Server) getHeaderMetadataAttempt(ctx context.Context, name, purgeEtag string) (bool, HeaderV3, []byte, string, error) {
+func (server *Server) getHeaderMetadataAttempt(ctx context.Context, name, purgeEtag string) (bool, HeaderV3, []byte, time.Time, string, error) {
 	rootReq := request{key: cacheKey{name: name, offset: 0, length: 0}, value: make(chan cachedValue, 1), purgeEtag: purgeEtag, compression: UnknownCompression}
 	server.reqs <- rootReq
 	rootValue := <-rootReq.value
 	header := rootValue.header
 
 	if !rootValue.ok {
-		return false, HeaderV3{}, nil, "", nil
+		return false, HeaderV3{}, nil, time.Time{}, "", nil
 	}
 
 	status := ""
@@ -257,10 +358,10 @@ func (server *Server) getHeaderMetadataAttempt(ctx context.Context, name, purgeE
 	r, _, statusCode, err := server.bucket.NewRangeReaderEtag(ctx, name+".pmtiles", int64(header.MetadataOffset), int64(header.MetadataLength), rootValue.etag)
 	status = strconv.Itoa(statusCode)
 	if isRefreshRequiredError(err) {
-		return false, HeaderV3{}, nil, rootValue.etag, nil
+		return false, HeaderV3{}, nil, time.Time{}, rootValue.etag, nil
 	}
 	if err != nil {
-		return false, HeaderV3{}, nil, "", nil
+		return false, HeaderV3{}, nil, time.Time{}, "", nil
 	}
 	defer r.Close()
 
@@ -268,14 +369,38 @@ func (server *Server) getHeaderMetadataAttempt(ctx context.Context, name, purgeE
 
 	if err != nil {
 		status = "error"
-		return true, HeaderV3{}, nil, "", errors.New("unknown compression")
+		return true, HeaderV3{}, nil, time.Time{}, "", errors.New("unknown compression")
 	}
 
-	return true, header, metadataBytes, "", nil
+	return true, header, metadataBytes, rootValue.modTime, "", nil
 }
 
-func (server *Server) getTileJSON(ctx context.Context, httpHeaders map[string]string, name string) (int, map[string]string, []byte) {
-	found, header, metadataBytes, err := server.getHeaderMetadata(ctx, name)
+// getHeader returns the header of an archive from the root cache, without fetching its metadata,
+// and when the archive was last modified if known.
+func (server *Server) getHeader(name string) (bool, HeaderV3, time.Time) {
+	rootReq := request{key: cacheKey{name: name, offset: 0, length: 0}, value: make(chan cachedValue, 1), compression: UnknownCompression}
+	server.reqs <- rootReq
+	rootValue := <-rootReq.value
+	return rootValue.ok, rootValue.header, rootValue.modTime
+}
+
+func (server *Server) getArchiveHeader(name string) (bool, HeaderV3, time.Time, error) {
+	if archives, ok := server.composites[name]; ok {
+		return server.getCompositeHeader(name, server.resolveAliases(archives))
+	}
+	found, header, modTime := server.getHeader(name)
+	return found, header, modTime, nil
+}
+
+func (server *Server) getArchiveHeaderMetadata(ctx context.Context, name string) (bool, HeaderV3, []byte, time.Time, error) {
+	if archives, ok := server.composites[name]; ok {
+		return server.getCompositeHeaderMetadata(ctx, name, server.resolveAliases(archives))
+	}
+	return server.getHeaderMetadata(ctx, name)
+}
+
+func (server *Server) getTileJSON(ctx context.Context, httpHeaders map[string]string, name string, auth tileAuth) (int, map[string]string, []byte) {
+	found, header, metadataBytes, modTime, err := server.getArchiveHeaderMetadata(ctx, name)
 
 	if err != nil {
 		return 500, httpHeaders, []byte("I/O Error")
@@ -288,23 +413,29 @@ func (server *Server) getTileJSON(ctx context.Context, httpHeaders map[string]st
 	var metadataMap map[string]interface{}
 	json.Unmarshal(metadataBytes, &metadataMap)
 
-	if server.publicURL == "" {
+	publicURL := server.archivePublicURL(name)
+	if publicURL == "" {
 		return 501, httpHeaders, []byte("PUBLIC_URL must be set for TileJSON")
 	}
 
-	tilejsonBytes, err := CreateTileJSON(header, metadataBytes, server.publicURL+"/"+name)
+	if server.canOverzoom(header) {
+		header.MaxZoom = min(header.MaxZoom+server.overzoom, maxOverzoom)
+	}
+
+	tilejsonBytes, err := createTileJSON(header, metadataBytes, publicURL+"/"+name, server.tileQuery(auth, name))
 	if err != nil {
 		return 500, httpHeaders, []byte("Error generating tilejson")
 	}
 
 	httpHeaders["Content-Type"] = "application/json"
 	httpHeaders["ETag"] = generateEtag(tilejsonBytes)
+	setLastModified(httpHeaders, modTime)
 
 	return 200, httpHeaders, tilejsonBytes
 }
 
 func (server *Server) getMetadata(ctx context.Context, httpHeaders map[string]string, name string) (int, map[string]string, []byte) {
-	found, _, metadataBytes, err := server.getHeaderMetadata(ctx, name)
+	found, _, metadataBytes, modTime, err := server.getArchiveHeaderMetadata(ctx, name)
 
 	if err != nil {
 		return 500, httpHeaders, []byte("I/O Error")
@@ -316,18 +447,26 @@ func (server *Server) getMetadata(ctx context.Context, httpHeaders map[string]st
 
 	httpHeaders["Content-Type"] = "application/json"
 	httpHeaders["ETag"] = generateEtag(metadataBytes)
+	setLastModified(httpHeaders, modTime)
 	return 200, httpHeaders, metadataBytes
 }
-func (server *Server) getTile(ctx context.Context, httpHeaders map[string]string, name string, z uint8, x uint32, y uint32, ext string) (int, map[string]string, []byte) {
-	status, headers, data, purgeEtag := server.getTileAttempt(ctx, httpHeaders, name, z, x, y, ext, "")
+func (server *Server) getArchiveTile(ctx context.Context, httpHeaders map[string]string, name string, z uint8, x uint32, y uint32, ext string, acceptEncoding string) (int, map[string]string, []byte) {
+	if archives, ok := server.composites[name]; ok {
+		return server.getCompositeTile(ctx, httpHeaders, server.resolveAliases(archives), z, x, y, ext, acceptEncoding)
+	}
+	return server.getTile(ctx, httpHeaders, name, z, x, y, ext, acceptEncoding)
+}
+
+func (server *Server) getTile(ctx context.Context, httpHeaders map[string]string, name string, z uint8, x uint32, y uint32, ext string, acceptEncoding string) (int, map[string]string, []byte) {
+	status, headers, data, purgeEtag := server.getTileAttempt(ctx, httpHeaders, name, z, x, y, ext, acceptEncoding, "")
 	if len(purgeEtag) > 0 {
 		// file has new etag, retry once force-purging the etag that is no longer value
-		status, headers, data, _ = server.getTileAttempt(ctx, httpHeaders, name, z, x, y, ext, purgeEtag)
+		status, headers, data, _ = server.getTileAttempt(ctx, httpHeaders, name, z, x, y, ext, acceptEncoding, purgeEtag)
 	}
 	return status, headers, data
 }
 
-func (server *Server) getTileAttempt(ctx context.Context, httpHeaders map[string]string, name string, z uint8, x uint32, y uint32, ext string, purgeEtag string) (int, map[string]string, []byte, string) {
+func (server *Server) getTileAttempt(ctx context.Context, httpHeaders map[string]string, name string, z uint8, x uint32, y uint32, ext string, acceptEncoding string, purgeEtag string) (int, map[string]string, []byte, string) {
 	rootReq := request{key: cacheKey{name: name, offset: 0, length: 0}, value: make(chan cachedValue, 1), purgeEtag: purgeEtag, compression: UnknownCompression}
 	server.reqs <- rootReq
 
@@ -339,21 +478,37 @@ func (server *Server) getTileAttempt(ctx context.Context, httpHeaders map[string
 		return 404, httpHeaders, []byte("Archive not found"), ""
 	}
 
-	if z < header.MinZoom || z > header.MaxZoom {
+	var dz uint8
+	if z > header.MaxZoom && z <= maxOverzoom && z-header.MaxZoom <= server.overzoom && server.canOverzoom(header) {
+		dz = z - header.MaxZoom
+	} else if z < header.MinZoom || z > header.MaxZoom {
 		return 404, httpHeaders, []byte("Tile not found"), ""
 	}
 
-	switch header.TileType {
+	target := header.TileType
+	if server.transcoder != nil && (header.TileType == Png || header.TileType == Jpeg || header.TileType == Webp) {
+		switch t := extToTileType("." + ext); t {
+		case Png, Jpeg:
+			target = t
+		case Webp, Avif:
+			// decoding WebP is supported, but not encoding
+			if t != header.TileType {
+				return 406, httpHeaders, []byte("raster tiles can only be transcoded to PNG (.png) or JPEG (.jpg)"), ""
+			}
+		}
+	}
+
+	switch target {
 	case Mvt:
 		if ext != "mvt" {
 			return 400, httpHeaders, []byte("path mismatch: archive is type MVT (.mvt)"), ""
 		}
 	case Png:
-		if ext != "png" {
+		if ext != "png" && target == header.TileType {
 			return 400, httpHeaders, []byte("path mismatch: archive is type PNG (.png)"), ""
 		}
 	case Jpeg:
-		if ext != "jpg" {
+		if ext != "jpg" && target == header.TileType {
 			return 400, httpHeaders, []byte("path mismatch: archive is type JPEG (.jpg)"), ""
 		}
 	case Webp:
@@ -370,7 +525,7 @@ func (server *Server) getTileAttempt(ctx context.Context, httpHeaders map[string
 		}
 	}
 
-	tileID := ZxyToID(z, x, y)
+	tileID := ZxyToID(z-dz, x>>dz, y>>dz)
 	dirOffset, dirLen := header.RootOffset, header.RootLength
 
 	for depth := 0; depth <= 3; depth++ {
@@ -387,37 +542,90 @@ func (server *Server) getTileAttempt(ctx context.Context, httpHeaders map[string
 		}
 
 		if entry.RunLength > 0 {
-			status := ""
-			tracker := server.metrics.startBucketRequest(name, "tile")
-			defer func() { tracker.finish(ctx, status) }()
-			r, _, statusCode, err := server.bucket.NewRangeReaderEtag(ctx, name+".pmtiles", int64(header.TileDataOffset+entry.Offset), int64(entry.Length), rootValue.etag)
-			status = strconv.Itoa(statusCode)
-			if isRefreshRequiredError(err) {
-				return 500, httpHeaders, []byte("I/O Error"), rootValue.etag
+			var b []byte
+			var err error
+			cached := false
+			if server.tileCache != nil {
+				b, cached = server.tileCache.Get(name, rootValue.etag, entry.Offset, uint64(entry.Length))
+				server.metrics.tileCacheRequest(name, cached)
 			}
-			// possible we have the header/directory cached but the archive has disappeared
-			if err != nil {
-				if isCanceled(ctx) {
-					return 499, httpHeaders, []byte("Canceled"), ""
+
+			if !cached {
+				status := ""
+				tracker := server.metrics.startBucketRequest(name, "tile")
+				defer func() { tracker.finish(ctx, status) }()
+				r, _, statusCode, err := server.bucket.NewRangeReaderEtag(ctx, name+".pmtiles", int64(header.TileDataOffset+entry.Offset), int64(entry.Length), rootValue.etag)
+				status = strconv.Itoa(statusCode)
+				if isRefreshRequiredError(err) {
+					return 500, httpHeaders, []byte("I/O Error"), rootValue.etag
+				}
+				// possible we have the header/directory cached but the archive has disappeared
+				if err != nil {
+					if isCanceled(ctx) {
+						return 499, httpHeaders, []byte("Canceled"), ""
+					}
+					server.logger.Printf("failed to fetch tile %s %d-%d %v", name, entry.Offset, entry.Length, err)
+					return 404, httpHeaders, []byte("Tile not found"), ""
+				}
+				defer r.Close()
+				b, err = io.ReadAll(r)
+				if err != nil {
+					status = "error"
+					if isCanceled(ctx) {
+						return 499, httpHeaders, []byte("Canceled"), ""
+					}
+					return 500, httpHeaders, []byte("I/O error"), ""
+				}
+				if server.tileCache != nil {
+					server.tileCache.Put(name, rootValue.etag, entry.Offset, uint64(entry.Length), b)
 				}
-				server.logger.Printf("failed to fetch tile %s %d-%d %v", name, entry.Offset, entry.Length, err)
-				return 404, httpHeaders, []byte("Tile not found"), ""
 			}
-			defer r.Close()
-			b, err := io.ReadAll(r)
-			if err != nil {
-				status = "error"
-				if isCanceled(ctx) {
-					return 499, httpHeaders, []byte("Canceled"), ""
+
+			if dz > 0 {
+				b, err = transformStoredTile(header, b, func(data []byte) ([]byte, error) {
+					return overzoomTile(header.TileType, data, dz, x, y)
+				})
+				if err != nil {
+					server.logger.Printf("failed to overzoom tile %s %d/%d/%d, %v", name, z, x, y, err)
+					return 500, httpHeaders, []byte("Failed to overzoom tile"), ""
+				}
+			}
+
+			if target != header.TileType {
+				key := transcodeKey{generateEtag(b), target}
+				if cached, ok := server.transcoder.get(key); ok {
+					b = cached
+				} else {
+					b, err = transformStoredTile(header, b, func(data []byte) ([]byte, error) {
+						return transcodeRaster(data, target)
+					})
+					if err != nil {
+						server.logger.Printf("failed to transcode tile %s %d/%d/%d, %v", name, z, x, y, err)
+						return 500, httpHeaders, []byte("Failed to transcode tile"), ""
+					}
+					server.transcoder.put(key, b)
+				}
+				header.TileType = target
+			}
+
+			encoding := negotiateEncoding(header.TileCompression, acceptEncoding)
+			if encoding != header.TileCompression {
+				b, err = transcodeBytes(b, header.TileCompression, encoding)
+				if err != nil {
+					server.logger.Printf("failed to transcode tile %s %d/%d/%d, %v", name, z, x, y, err)
+					return 500, httpHeaders, []byte("Failed to transcode tile"), ""
 				}
-				return 500, httpHeaders, []byte("I/O error"), ""
 			}
 
 			httpHeaders["ETag"] = generateEtag(b)
+			setLastModified(httpHeaders, rootValue.modTime)
 			if headerVal, ok := headerContentType(header); ok {
 				httpHeaders["Content-Type"] = headerVal
 			}
-			if headerVal, ok := compressionToString(header.TileCompression); ok {
+			if _, ok := compressionToString(header.TileCompression); ok {
+				httpHeaders["Vary"] = "Accept-Encoding"
+			}
+			if headerVal, ok := compressionToString(encoding); ok {
 				httpHeaders["Content-Encoding"] = headerVal
 			}
 			return 200, httpHeaders, b, ""
@@ -428,6 +636,38 @@ func (server *Server) getTileAttempt(ctx context.Context, httpHeaders map[string
 	return 204, httpHeaders, nil, ""
 }
 
+// maxOverzoom is the highest zoom level served by overzooming.
+const maxOverzoom = 30
+
+// MaxOverzoomLevels is the most zoom levels served beyond the maxzoom of an archive.
+// Beyond it, a 256 pixel raster tile or an MVT tile with extent 4096 has less than one unit per tile.
+const MaxOverzoomLevels = 12
+
+func (server *Server) canOverzoom(header HeaderV3) bool {
+	return server.overzoom > 0 && (header.TileType == Mvt || header.TileType == Png || header.TileType == Jpeg)
+}
+
+// transformStoredTile decompresses a tile as stored in the archive, applies transform,
+// and compresses the result the same way.
+func transformStoredTile(header HeaderV3, data []byte, transform func([]byte) ([]byte, error)) ([]byte, error) {
+	compression := header.TileCompression
+	if compression == UnknownCompression {
+		compression = NoCompression
+		if isGzip(data) {
+			compression = Gzip
+		}
+	}
+	decompressed, err := decompressBytes(data, compression)
+	if err != nil {
+		return nil, err
+	}
+	transformed, err := transform(decompressed)
+	if err != nil {
+		return nil, err
+	}
+	return compressBytes(transformed, compression)
+}
+
 func isRefreshRequiredError(err error) bool {
 	_, ok := err.(*RefreshRequiredError)
 	return ok
@@ -441,6 +681,20 @@ var tilePattern = regexp.MustCompile(`^\/([-A-Za-z0-9_\/!-_\.\*'\(\)']+)\/(\d+)\
 var metadataPattern = regexp.MustCompile(`^\/([-A-Za-z0-9_\/!-_\.\*'\(\)']+)\/metadata$`)
 var tileJSONPattern = regexp.MustCompile(`^\/([-A-Za-z0-9_\/!-_\.\*'\(\)']+)\.json$`)
 
+// isCleanPath reports whether a request path has no empty, "." or ".." segments, apart from a trailing slash.
+// A bucket may clean those away, fetching a different archive than the name that was authorized.
+func isCleanPath(path string) bool {
+	if path == "/" {
+		return true
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
 func parseTilePath(path string) (bool, string, uint8, uint32, uint32, string) {
 	if res := tilePattern.FindStringSubmatch(path); res != nil {
 		name := res[1]
@@ -469,34 +723,60 @@ func parseMetadataPath(path string) (bool, string) {
 	return false, ""
 }
 
-func (server *Server) get(ctx context.Context, unsanitizedPath string) (archive, handler string, status int, headers map[string]string, data []byte) {
+func (server *Server) get(ctx context.Context, unsanitizedPath string, acceptEncoding string, auth tileAuth) (archive, handler string, status int, headers map[string]string, data []byte) {
 	handler = ""
 	archive = ""
 	headers = make(map[string]string)
 
-	if ok, key, z, x, y, ext := parseTilePath(unsanitizedPath); ok {
-		archive, handler = key, "tile"
-		status, headers, data = server.getTile(ctx, headers, key, z, x, y, ext)
+	if !isCleanPath(unsanitizedPath) {
+		handler, status, data = "404", 404, []byte("Path not found")
+	} else if ok, key, z, x, y, ext := parseTilePath(unsanitizedPath); ok {
+		archive, handler = server.resolveAlias(key), "tile"
+		status, headers, data = server.getArchiveTile(ctx, headers, archive, z, x, y, ext, acceptEncoding)
 	} else if ok, key := parseTilejsonPath(unsanitizedPath); ok {
-		archive, handler = key, "tilejson"
-		status, headers, data = server.getTileJSON(ctx, headers, key)
+		archive, handler = server.resolveAlias(key), "tilejson"
+		status, headers, data = server.getTileJSON(ctx, headers, archive, auth)
 	} else if ok, key := parseMetadataPath(unsanitizedPath); ok {
-		archive, handler = key, "metadata"
-		status, headers, data = server.getMetadata(ctx, headers, key)
+		archive, handler = server.resolveAlias(key), "metadata"
+		status, headers, data = server.getMetadata(ctx, headers, archive)
+	} else if ok, key := parseViewerPath(unsanitizedPath); ok && server.viewer {
+		archive, handler = server.resolveAlias(key), "viewer"
+		status, headers, data = server.getViewer(ctx, headers, archive)
+	} else if ok, key := parseWMTSCapabilitiesPath(unsanitizedPath); ok {
+		archive, handler = server.resolveAlias(key), "wmts"
+		status, headers, data = server.getArchiveWMTSCapabilities(ctx, headers, archive, auth)
+	} else if unsanitizedPath == "/WMTSCapabilities.xml" {
+		handler = "wmts"
+		status, headers, data = server.getAllWMTSCapabilities(ctx, headers, auth)
+	} else if unsanitizedPath == "/ogc" || strings.HasPrefix(unsanitizedPath, "/ogc/") {
+		handler = "ogc"
+		status, headers, data = server.getOgc(ctx, headers, unsanitizedPath, acceptEncoding, auth)
+	} else if unsanitizedPath == "/catalog" {
+		handler = "catalog"
+		status, headers, data = server.getCatalog(ctx, headers, auth)
 	} else if unsanitizedPath == "/" {
 		handler, status, data = "/", 204, []byte{}
 	} else {
 		handler, status, data = "404", 404, []byte("Path not found")
 	}
 
+	if status == 200 || status == 204 {
+		if auth.private {
+			headers["Cache-Control"] = "private"
+		} else if cacheControl := server.getCacheControl(archive, handler); cacheControl != "" {
+			headers["Cache-Control"] = cacheControl
+		}
+	}
 	return
 }
 
 // Get a response for the given path.
 // Return status code, HTTP headers, and body.
+// Tiles are returned with the compression stored in the archive.
+// Credentials required by SetAuth are only checked by ServeHTTP.
 func (server *Server) Get(ctx context.Context, path string) (int, map[string]string, []byte) {
 	tracker := server.metrics.startRequest()
-	archive, handler, status, headers, data := server.get(ctx, path)
+	archive, handler, status, headers, data := server.get(ctx, path, "*", tileAuth{})
 	tracker.finish(ctx, archive, handler, status, len(data), true)
 	return status, headers, data
 }
@@ -515,23 +795,45 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) int {
 	tracker := server.metrics.startRequest()
 
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" && server.archivePreflight(w.Header(), r) {
+		w.WriteHeader(204)
+		tracker.finish(r.Context(), "", "preflight", 204, 0, false)
+		return 204
+	}
+
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		w.WriteHeader(405)
 		tracker.finish(r.Context(), "", r.Method, 405, 0, false)
 		return 405
 	}
 
-	archive, handler, statusCode, headers, body := server.get(r.Context(), r.URL.Path)
+	authStatus, auth := server.authorize(r, time.Now())
+	if authStatus != 200 {
+		if authStatus == 401 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		w.WriteHeader(authStatus)
+		w.Write([]byte(http.StatusText(authStatus)))
+		tracker.finish(r.Context(), "", "auth", authStatus, 0, false)
+		return authStatus
+	}
+
+	archive, handler, statusCode, headers, body := server.get(r.Context(), r.URL.Path, r.Header.Get("Accept-Encoding"), auth)
 	for k, v := range headers {
 		w.Header().Set(k, v)
 	}
+	server.setArchiveCors(w.Header(), archive, r.Header.Get("Origin"))
 	if statusCode == 200 {
 		lrw := &loggingResponseWriter{w, 200}
-		// handle if-match, if-none-match request headers based on response etag
+		modTime := time.UnixMilli(0) // ignored by ServeContent when the archive modification time is unknown
+		if lastModified, err := http.ParseTime(headers["Last-Modified"]); err == nil {
+			modTime = lastModified
+		}
+		// handle if-match, if-none-match and if-modified-since request headers based on response etag and last-modified time
 		http.ServeContent(
 			lrw, r,
-			"",                // name used to infer content-type, but we've already set that
-			time.UnixMilli(0), // ignore setting last-modified time and handling if-modified-since headers
+			"", // name used to infer content-type, but we've already set that
+			modTime,
 			bytes.NewReader(body),
 		)
 		statusCode = lrw.statusCode
@@ -544,9 +846,13 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) int {
 	return statusCode
 }
 
+var corsAllowedMethods = []string{http.MethodGet, http.MethodHead}
+var corsAllowedHeaders = []string{"Accept", "Content-Type", "X-Requested-With", "Authorization"}
+
 func NewCors(corsOrigins string) *cors.Cors {
 	return cors.New(cors.Options{
-		AllowedMethods: []string{http.MethodGet, http.MethodHead},
+		AllowedMethods: corsAllowedMethods,
 		AllowedOrigins: strings.Split(corsOrigins, ","),
+		AllowedHeaders: corsAllowedHeaders,
 	})
 }
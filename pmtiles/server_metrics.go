@@ -52,6 +52,10 @@ type metrics struct {
 	dirCacheSizeBytes  prometheus.Gauge
 	dirCacheLimitBytes prometheus.Gauge
 	dirCacheRequests   *prometheus.CounterVec
+	// shared dir cache: # requests by hit/miss/error
+	sharedDirCacheRequests *prometheus.CounterVec
+	// tile cache: # requests by hit/miss
+	tileCacheRequests *prometheus.CounterVec
 	// requests to bucket: # total, response duration by archive/status code
 	bucketRequests        *prometheus.CounterVec
 	bucketRequestDuration *prometheus.HistogramVec
@@ -137,6 +141,18 @@ func (m *metrics) cacheRequest(archive, kind, status string) {
 	m.dirCacheRequests.WithLabelValues(archive, kind, status).Inc()
 }
 
+func (m *metrics) sharedDirCacheRequest(archive, kind, status string) {
+	m.sharedDirCacheRequests.WithLabelValues(archive, kind, status).Inc()
+}
+
+func (m *metrics) tileCacheRequest(archive string, hit bool) {
+	status := "miss"
+	if hit {
+		status = "hit"
+	}
+	m.tileCacheRequests.WithLabelValues(archive, status).Inc()
+}
+
 func register[K prometheus.Collector](logger *log.Logger, metric K) K {
 	if err := prometheus.Register(metric); err != nil {
 		logger.Println(err)
@@ -199,6 +215,20 @@ func createMetrics(scope string, logger *log.Logger) *metrics {
 			Name:      "dir_cache_requests",
 			Help:      "Requests to the directory cache by archive and status (hit/miss)",
 		}, []string{"archive", "kind", "status"})),
+		sharedDirCacheRequests: register(logger, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: scope,
+			Name:      "shared_dir_cache_requests",
+			Help:      "Requests to the shared directory cache by archive and status (hit/miss/error)",
+		}, []string{"archive", "kind", "status"})),
+
+		// tile cache
+		tileCacheRequests: register(logger, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: scope,
+			Name:      "tile_cache_requests",
+			Help:      "Requests to the tile cache by archive and status (hit/miss)",
+		}, []string{"archive", "status"})),
 
 		// requests to bucket
 		bucketRequests: register(logger, prometheus.NewCounterVec(prometheus.CounterOpts{
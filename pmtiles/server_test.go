@@ -2,6 +2,7 @@ package pmtiles
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"log"
@@ -438,3 +439,148 @@ func TestCorsOptions(t *testing.T) {
 	assert.Equal(t, 204, res.Code)
 	assert.Equal(t, "*", res.Header().Get("Access-Control-Allow-Origin"))
 }
+
+func TestNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, Compression(Brotli), negotiateEncoding(Brotli, "gzip, deflate, br"))
+	assert.Equal(t, Compression(Gzip), negotiateEncoding(Brotli, "gzip, deflate"))
+	assert.Equal(t, Compression(NoCompression), negotiateEncoding(Zstd, ""))
+	assert.Equal(t, Compression(NoCompression), negotiateEncoding(Gzip, ""))
+	assert.Equal(t, Compression(Gzip), negotiateEncoding(Zstd, "zstd;q=0, gzip"))
+	assert.Equal(t, Compression(Zstd), negotiateEncoding(Zstd, "*"))
+	assert.Equal(t, Compression(NoCompression), negotiateEncoding(Gzip, "identity"))
+	assert.Equal(t, Compression(NoCompression), negotiateEncoding(NoCompression, "gzip"))
+	assert.Equal(t, Compression(UnknownCompression), negotiateEncoding(UnknownCompression, "identity"))
+}
+
+func TestTranscodeTileForAcceptEncoding(t *testing.T) {
+	mockBucket, server := newServer(t)
+	raw := []byte("not really a png but compressible")
+	stored, err := compressBytes(raw, Brotli)
+	assert.Nil(t, err)
+	header := HeaderV3{
+		TileType:        Png,
+		TileCompression: Brotli,
+	}
+	mockBucket.items["archive.pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: stored,
+	}, false, Gzip)
+
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/archive/0/0/0.png", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		server.ServeHTTP(res, req)
+		assert.Equal(t, 200, res.Code)
+		assert.Equal(t, "Accept-Encoding", res.Header().Get("Vary"))
+		return res
+	}
+
+	res := get("gzip, br")
+	assert.Equal(t, "br", res.Header().Get("Content-Encoding"))
+	assert.Equal(t, stored, res.Body.Bytes())
+
+	res = get("gzip")
+	assert.Equal(t, "gzip", res.Header().Get("Content-Encoding"))
+	decompressed, err := decompressBytes(res.Body.Bytes(), Gzip)
+	assert.Nil(t, err)
+	assert.Equal(t, raw, decompressed)
+	gzipEtag := res.Header().Get("ETag")
+
+	res = get("")
+	assert.Equal(t, "", res.Header().Get("Content-Encoding"))
+	assert.Equal(t, raw, res.Body.Bytes())
+
+	res = get("identity")
+	assert.Equal(t, "", res.Header().Get("Content-Encoding"))
+	assert.Equal(t, raw, res.Body.Bytes())
+	assert.NotEqual(t, gzipEtag, res.Header().Get("ETag"))
+
+	statusCode, headers, data := server.Get(context.Background(), "/archive/0/0/0.png")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "br", headers["Content-Encoding"])
+	assert.Equal(t, stored, data)
+}
+
+func TestCatalog(t *testing.T) {
+	mockBucket, server := newServer(t)
+	mockBucket.items["b.pmtiles"] = fakeArchive(HeaderV3{
+		TileType: Mvt,
+		MinZoom:  0,
+		MaxZoom:  4,
+		MinLonE7: -1800000000,
+		MinLatE7: -850000000,
+		MaxLonE7: 1800000000,
+		MaxLatE7: 850000000,
+	}, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {0, 1, 2, 3},
+	}, false, Gzip)
+	mockBucket.items["nested/a.pmtiles"] = fakeArchive(HeaderV3{
+		TileType: Png,
+		MinZoom:  1,
+		MaxZoom:  2,
+	}, map[string]interface{}{}, map[Zxy][]byte{
+		{1, 0, 0}: {0, 1, 2, 3},
+	}, false, Gzip)
+	mockBucket.items["bad.pmtiles"] = []byte("not an archive")
+	mockBucket.items["readme.txt"] = []byte("hello")
+
+	statusCode, headers, data := server.Get(context.Background(), "/catalog")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "application/json", headers["Content-Type"])
+	var catalog Catalog
+	assert.Nil(t, json.Unmarshal(data, &catalog))
+	assert.Equal(t, []CatalogArchive{
+		{Name: "b", TileType: "mvt", MinZoom: 0, MaxZoom: 4, Bounds: []float64{-180, -85, 180, 85}, TileJSON: "tiles.example.com/b.json"},
+		{Name: "nested/a", TileType: "png", MinZoom: 1, MaxZoom: 2, Bounds: []float64{0, 0, 0, 0}, TileJSON: "tiles.example.com/nested/a.json"},
+	}, catalog.Archives)
+}
+
+func TestListingsFetchHeadersOnly(t *testing.T) {
+	mock := mockBucket{make(map[string][]byte)}
+	for _, name := range []string{"a", "b", "c"} {
+		mock.items[name+".pmtiles"] = fakeArchive(HeaderV3{TileType: Mvt}, map[string]interface{}{"name": name}, map[Zxy][]byte{
+			{0, 0, 0}: {0, 1},
+		}, false, Gzip)
+	}
+	bucket := &countingBucket{mockBucket: mock}
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	server, err := NewServerWithBucket(bucket, "", log.Default(), 10, "tiles.example.com")
+	assert.Nil(t, err)
+	server.Start()
+
+	for _, path := range []string{"/catalog", "/ogc/collections", "/WMTSCapabilities.xml", "/catalog"} {
+		statusCode, _, _ := server.Get(context.Background(), path)
+		assert.Equal(t, 200, statusCode, path)
+	}
+	// one header request per archive, and no metadata
+	assert.Equal(t, 3, bucket.requests)
+	// one listing of the bucket
+	assert.Equal(t, 1, bucket.lists)
+}
+
+func TestCatalogUnsupportedBucket(t *testing.T) {
+	server, err := NewServerWithBucket(HTTPBucket{"http://tiles.example.com", http.DefaultClient}, "", log.Default(), 10, "")
+	assert.Nil(t, err)
+	statusCode, _, _ := server.Get(context.Background(), "/catalog")
+	assert.Equal(t, 501, statusCode)
+}
+
+func TestViewer(t *testing.T) {
+	mockBucket, server := newServer(t)
+	mockBucket.items["archive.pmtiles"] = fakeArchive(HeaderV3{TileType: Mvt}, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {0, 1, 2, 3},
+	}, false, Gzip)
+
+	statusCode, _, _ := server.Get(context.Background(), "/archive/viewer")
+	assert.Equal(t, 404, statusCode)
+
+	server.SetViewer(true)
+	statusCode, headers, data := server.Get(context.Background(), "/archive/viewer")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "text/html; charset=utf-8", headers["Content-Type"])
+	assert.Contains(t, string(data), "<canvas")
+	statusCode, _, _ = server.Get(context.Background(), "/missing/viewer")
+	assert.Equal(t, 404, statusCode)
+}
@@ -0,0 +1,55 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SharedDirectoryCache is a cache of raw header and directory bytes shared between servers, such as Redis or memcached.
+// A Server consults it before fetching a header or directory from the bucket, and stores what it fetched.
+// Keys identify an archive name, byte range and etag, so entries for a changed archive are never read again
+// and can be left to expire.
+type SharedDirectoryCache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+// sharedKey is the key of a header or directory in a SharedDirectoryCache.
+// The root request is keyed without an etag, so the etag of the cached bytes is stored in the value.
+func (k cacheKey) sharedKey() string {
+	return fmt.Sprintf("pmtiles:%d:%d:%s:%s", k.offset, k.length, k.etag, k.name)
+}
+
+// encodeSharedDirectory stores the etag and modification time of an archive with the bytes of a header or directory.
+// The modification time is in Unix seconds, zero when unknown.
+func encodeSharedDirectory(etag string, modTime time.Time, data []byte) []byte {
+	value := binary.AppendUvarint(nil, uint64(len(etag)))
+	value = append(value, etag...)
+	var seconds int64
+	if !modTime.IsZero() {
+		seconds = modTime.Unix()
+	}
+	value = binary.AppendVarint(value, seconds)
+	return append(value, data...)
+}
+
+func decodeSharedDirectory(value []byte) (string, time.Time, []byte, error) {
+	n, read := binary.Uvarint(value)
+	if read <= 0 || uint64(len(value)-read) < n {
+		return "", time.Time{}, nil, errors.New("malformed directory cache value")
+	}
+	etag := string(value[read : read+int(n)])
+	value = value[read+int(n):]
+	seconds, read := binary.Varint(value)
+	if read <= 0 {
+		return "", time.Time{}, nil, errors.New("malformed directory cache value")
+	}
+	var modTime time.Time
+	if seconds != 0 {
+		modTime = time.Unix(seconds, 0)
+	}
+	return etag, modTime, value[read:], nil
+}
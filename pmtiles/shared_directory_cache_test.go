@@ -0,0 +1,148 @@
+package pmtiles
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingBucket struct {
+	mockBucket
+	mu       sync.Mutex
+	requests int
+	lists    int
+}
+
+func (c *countingBucket) List(ctx context.Context) ([]string, error) {
+	c.mu.Lock()
+	c.lists++
+	c.mu.Unlock()
+	return c.mockBucket.List(ctx)
+}
+
+func (c *countingBucket) NewRangeReaderEtag(ctx context.Context, key string, offset int64, length int64, etag string) (io.ReadCloser, string, int, error) {
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+	return c.mockBucket.NewRangeReaderEtag(ctx, key, offset, length, etag)
+}
+
+// memorySharedDirectoryCache is an in-process SharedDirectoryCache counting its calls.
+type memorySharedDirectoryCache struct {
+	mu    sync.Mutex
+	items map[string][]byte
+	gets  int
+	sets  int
+}
+
+func newMemorySharedDirectoryCache() *memorySharedDirectoryCache {
+	return &memorySharedDirectoryCache{items: make(map[string][]byte)}
+}
+
+func (c *memorySharedDirectoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets++
+	value, ok := c.items[key]
+	return value, ok, nil
+}
+
+func (c *memorySharedDirectoryCache) Set(_ context.Context, key string, value []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sets++
+	c.items[key] = value
+	return nil
+}
+
+func newSharedDirectoryServer(t *testing.T, bucket Bucket, cache SharedDirectoryCache) *Server {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	server, err := NewServerWithBucket(bucket, "", log.Default(), 10, "tiles.example.com")
+	assert.Nil(t, err)
+	server.SetSharedDirectoryCache(cache)
+	server.Start()
+	return server
+}
+
+func TestSharedDirectoryCacheValue(t *testing.T) {
+	etag, modTime, data, err := decodeSharedDirectory(encodeSharedDirectory("abc", time.Unix(1000, 0), []byte{1, 2, 3}))
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", etag)
+	assert.True(t, time.Unix(1000, 0).Equal(modTime))
+	assert.Equal(t, []byte{1, 2, 3}, data)
+	_, modTime, _, err = decodeSharedDirectory(encodeSharedDirectory("abc", time.Time{}, []byte{1, 2, 3}))
+	assert.Nil(t, err)
+	assert.True(t, modTime.IsZero())
+	_, _, _, err = decodeSharedDirectory([]byte{5, 'a'})
+	assert.NotNil(t, err)
+}
+
+func TestSharedDirectoryCacheBetweenServers(t *testing.T) {
+	mock := mockBucket{make(map[string][]byte)}
+	header := HeaderV3{
+		TileType: Mvt,
+	}
+	mock.items["archive.pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {0, 1},
+		{1, 1, 1}: {2, 3},
+	}, true, Gzip)
+	cache := newMemorySharedDirectoryCache()
+
+	bucket1 := &countingBucket{mockBucket: mock}
+	server1 := newSharedDirectoryServer(t, bucket1, cache)
+	statusCode, _, data := server1.Get(context.Background(), "/archive/1/1/1.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{2, 3}, data)
+	// header and root directory, leaf directory and tile
+	assert.Equal(t, 3, bucket1.requests)
+	assert.Equal(t, 2, cache.sets)
+
+	// a second server only fetches the tile
+	bucket2 := &countingBucket{mockBucket: mock}
+	server2 := newSharedDirectoryServer(t, bucket2, cache)
+	statusCode, _, data = server2.Get(context.Background(), "/archive/1/1/1.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{2, 3}, data)
+	assert.Equal(t, 1, bucket2.requests)
+	assert.Equal(t, 2, cache.sets)
+
+	// a changed archive is re-fetched from the bucket instead of the stale shared root
+	mock.items["archive.pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {4, 5},
+		{1, 1, 1}: {6, 7},
+	}, true, Gzip)
+	bucket3 := &countingBucket{mockBucket: mock}
+	server3 := newSharedDirectoryServer(t, bucket3, cache)
+	statusCode, _, data = server3.Get(context.Background(), "/archive/1/1/1.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{6, 7}, data)
+
+	value, ok, _ := cache.Get(context.Background(), cacheKey{name: "archive"}.sharedKey())
+	assert.True(t, ok)
+	etag, _, _, _ := decodeSharedDirectory(value)
+	assert.Equal(t, generateEtag(mock.items["archive.pmtiles"]), etag)
+}
+
+func TestRootDirectoryFetchedWithHeader(t *testing.T) {
+	mock := mockBucket{make(map[string][]byte)}
+	mock.items["archive.pmtiles"] = fakeArchive(HeaderV3{TileType: Mvt}, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {0, 1},
+	}, false, Gzip)
+	bucket := &countingBucket{mockBucket: mock}
+	server := newSharedDirectoryServer(t, bucket, newMemorySharedDirectoryCache())
+
+	// the root directory is read from the header request, and cached under the archive etag
+	for i := 0; i < 2; i++ {
+		statusCode, _, data := server.Get(context.Background(), "/archive/0/0/0.mvt")
+		assert.Equal(t, 200, statusCode)
+		assert.Equal(t, []byte{0, 1}, data)
+	}
+	// one header request, then the tile for each Get
+	assert.Equal(t, 3, bucket.requests)
+}
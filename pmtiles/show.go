@@ -1,7 +1,6 @@
 package pmtiles
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -118,45 +117,19 @@ func Show(_ *log.Logger, output io.Writer, bucketURL string, key string, showHea
 		}
 	} else {
 		// write the tile to stdout
-
-		tileID := ZxyToID(uint8(z), uint32(x), uint32(y))
-
-		dirOffset := header.RootOffset
-		dirLength := header.RootLength
-
-		for depth := 0; depth <= 3; depth++ {
-			r, err := bucket.NewRangeReader(ctx, key, int64(dirOffset), int64(dirLength))
-			if err != nil {
-				return fmt.Errorf("Network error")
-			}
-			defer r.Close()
-			b, err := io.ReadAll(r)
-			if err != nil {
-				return fmt.Errorf("I/O Error")
-			}
-			directory := DeserializeEntries(bytes.NewBuffer(b), header.InternalCompression)
-			entry, ok := FindTile(directory, tileID)
-			if ok {
-				if entry.RunLength > 0 {
-					tileReader, err := bucket.NewRangeReader(ctx, key, int64(header.TileDataOffset+entry.Offset), int64(entry.Length))
-					if err != nil {
-						return fmt.Errorf("Network error")
-					}
-					defer tileReader.Close()
-					tileBytes, err := io.ReadAll(tileReader)
-					if err != nil {
-						return fmt.Errorf("I/O Error")
-					}
-					output.Write(tileBytes)
-					break
-				}
-				dirOffset = header.LeafDirectoryOffset + entry.Offset
-				dirLength = uint64(entry.Length)
-			} else {
-				fmt.Println("Tile not found in archive.")
-				return nil
-			}
+		reader, err := NewReader(ctx, bucket, key, nil)
+		if err != nil {
+			return err
+		}
+		tileBytes, ok, err := reader.GetTile(ctx, uint8(z), uint32(x), uint32(y))
+		if err != nil {
+			return fmt.Errorf("Failed to read tile, %w", err)
+		}
+		if !ok {
+			fmt.Println("Tile not found in archive.")
+			return nil
 		}
+		output.Write(tileBytes)
 	}
 	return nil
 }
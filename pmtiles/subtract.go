@@ -0,0 +1,149 @@
+package pmtiles
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/RoaringBitmap/roaring/roaring64"
+)
+
+// countInRange returns how many members of set are in [start, end).
+func countInRange(set *roaring64.Bitmap, start uint64, end uint64) uint64 {
+	count := set.Rank(end - 1)
+	if start > 0 {
+		count -= set.Rank(start - 1)
+	}
+	return count
+}
+
+// Subtract writes a copy of an archive without the tiles intersecting a region between minzoom and maxzoom.
+func Subtract(logger *log.Logger, bucketURL string, key string, output string, minzoom int8, maxzoom int8, regionFile string, bbox string) error {
+	start := time.Now()
+	ctx := context.Background()
+
+	if regionFile == "" && bbox == "" {
+		return fmt.Errorf("one of region or bbox must be specified")
+	}
+
+	bucketURL, key, err := NormalizeBucketKey(bucketURL, "", key)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := OpenBucket(ctx, bucketURL, "")
+	if err != nil {
+		return fmt.Errorf("Failed to open bucket for %s, %w", bucketURL, err)
+	}
+	defer bucket.Close()
+
+	reader, err := NewReader(ctx, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	header := reader.Header()
+
+	if minzoom == -1 || int8(header.MinZoom) > minzoom {
+		minzoom = int8(header.MinZoom)
+	}
+
+	if maxzoom == -1 || int8(header.MaxZoom) < maxzoom {
+		maxzoom = int8(header.MaxZoom)
+	}
+
+	if minzoom > maxzoom {
+		return fmt.Errorf("minzoom cannot be greater than maxzoom")
+	}
+
+	multipolygon, err := loadRegion(regionFile, bbox)
+	if err != nil {
+		return err
+	}
+	removedSet := regionBitmap(multipolygon, uint8(minzoom), uint8(maxzoom))
+
+	metadata, err := reader.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to read metadata, %w", err)
+	}
+
+	tmpfile, err := os.CreateTemp("", "pmtiles")
+	if err != nil {
+		return fmt.Errorf("Failed to create temp file, %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	resolve := newResolver(true, false)
+	bar := defaultProgressbar(logger, int64(header.TileEntriesCount))
+	var removed uint64
+	var writeErr error
+
+	add := func(tileID uint64, data []byte, runLength uint64) {
+		if isNew, newData := resolve.AddTileIsNew(tileID, data, uint32(runLength)); isNew {
+			if _, err := tmpfile.Write(newData); err != nil {
+				writeErr = fmt.Errorf("Failed to write to tempfile, %w", err)
+			}
+		}
+	}
+
+	err = reader.IterateEntries(ctx, func(e EntryV3) {
+		bar.Add(1)
+		if writeErr != nil {
+			return
+		}
+
+		end := e.TileID + uint64(e.RunLength)
+		removedInEntry := countInRange(removedSet, e.TileID, end)
+		if removedInEntry == uint64(e.RunLength) {
+			removed += removedInEntry
+			return
+		}
+		removed += removedInEntry
+
+		data, err := reader.readRange(ctx, header.TileDataOffset+e.Offset, uint64(e.Length))
+		if err != nil {
+			writeErr = err
+			return
+		}
+
+		if removedInEntry == 0 {
+			add(e.TileID, data, uint64(e.RunLength))
+			return
+		}
+
+		// split the run around removed tiles
+		runStart := e.TileID
+		for id := e.TileID; id < end; id++ {
+			if removedSet.Contains(id) {
+				if id > runStart {
+					add(runStart, data, id-runStart)
+				}
+				runStart = id + 1
+			}
+		}
+		if end > runStart {
+			add(runStart, data, end-runStart)
+		}
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to copy tiles, %w", err)
+	}
+
+	if len(resolve.Entries) == 0 {
+		return fmt.Errorf("no tiles remain after subtracting the region")
+	}
+
+	logger.Printf("Removed %d tiles", removed)
+
+	_, err = finalize(logger, resolve, header, tmpfile, output, metadata)
+	if err != nil {
+		return err
+	}
+	logger.Println("Finished in ", time.Since(start))
+	return nil
+}
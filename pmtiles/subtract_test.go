@@ -0,0 +1,54 @@
+package pmtiles
+
+import (
+	"context"
+	"github.com/RoaringBitmap/roaring/roaring64"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountInRange(t *testing.T) {
+	set := roaring64.New()
+	set.AddMany([]uint64{0, 5, 6, 10})
+	assert.Equal(t, uint64(1), countInRange(set, 0, 5))
+	assert.Equal(t, uint64(2), countInRange(set, 5, 10))
+	assert.Equal(t, uint64(3), countInRange(set, 5, 11))
+	assert.Equal(t, uint64(0), countInRange(set, 1, 5))
+}
+
+func TestSubtract(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.pmtiles")
+	w, err := NewWriter(logger, input, HeaderV3{TileType: Png, TileCompression: NoCompression}, true, t.TempDir())
+	assert.Nil(t, err)
+	for z := uint8(0); z <= 2; z++ {
+		for x := uint32(0); x < 1<<z; x++ {
+			for y := uint32(0); y < 1<<z; y++ {
+				assert.Nil(t, w.WriteTile(z, x, y, []byte{0x1}))
+			}
+		}
+	}
+	_, err = w.Finalize()
+	assert.Nil(t, err)
+
+	output := filepath.Join(t.TempDir(), "output.pmtiles")
+	err = Subtract(logger, "", input, output, 2, -1, "", "10,10,20,20")
+	assert.Nil(t, err)
+	assert.Nil(t, Verify(logger, output))
+
+	ctx := context.Background()
+	reader := readerForFile(t, output)
+	assert.Equal(t, uint64(20), reader.Header().AddressedTilesCount)
+	_, ok, _ := reader.GetTile(ctx, 2, 2, 1)
+	assert.False(t, ok)
+	_, ok, _ = reader.GetTile(ctx, 1, 1, 0)
+	assert.True(t, ok)
+	_, ok, _ = reader.GetTile(ctx, 2, 2, 2)
+	assert.True(t, ok)
+}
+
+func TestSubtractRequiresRegion(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output.pmtiles")
+	err := Subtract(logger, "", "fixtures/test_fixture_1.pmtiles", output, -1, -1, "", "")
+	assert.NotNil(t, err)
+}
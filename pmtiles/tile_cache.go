@@ -0,0 +1,201 @@
+package pmtiles
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TileCache stores the bytes of tiles fetched by a Server, keyed by archive name, etag and byte range.
+// Purge is called with the etag of an archive that has changed.
+type TileCache interface {
+	Get(name string, etag string, offset uint64, length uint64) ([]byte, bool)
+	Put(name string, etag string, offset uint64, length uint64, data []byte)
+	Purge(name string, etag string)
+}
+
+type tileCacheKey struct {
+	name   string
+	etag   string
+	offset uint64
+	length uint64
+}
+
+type memoryTileCache struct {
+	mu  sync.Mutex
+	lru *lru[tileCacheKey, []byte]
+}
+
+// NewMemoryTileCache returns an in-memory TileCache holding at most maxBytes of tile data.
+func NewMemoryTileCache(maxBytes int) TileCache {
+	return &memoryTileCache{lru: newLRU[tileCacheKey, []byte](int64(maxBytes), func(data []byte) int64 {
+		return int64(len(data))
+	})}
+}
+
+func (c *memoryTileCache) Get(name string, etag string, offset uint64, length uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.get(tileCacheKey{name, etag, offset, length})
+}
+
+func (c *memoryTileCache) Put(name string, etag string, offset uint64, length uint64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.add(tileCacheKey{name, etag, offset, length}, data)
+}
+
+func (c *memoryTileCache) Purge(name string, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.removeIf(func(key tileCacheKey) bool {
+		return key.name == name && key.etag == etag
+	})
+}
+
+// diskTileCache stores each tile in a file at dir/{name hash}/{etag hash}/{offset}-{length},
+// so that a purge removes a single directory. The LRU holds the size of each file by path.
+type diskTileCache struct {
+	mu  sync.Mutex
+	dir string
+	lru *lru[string, int64]
+}
+
+// purgeSuffix marks directories of purged tiles that are being deleted.
+const purgeSuffix = ".purge"
+
+func hashPathComponent(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewDiskTileCache returns a TileCache storing at most maxBytes of tile data as files in dir.
+// Tiles cached in dir by a previous process are reused, least recently modified evicted first.
+func NewDiskTileCache(dir string, maxBytes int64) (TileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &diskTileCache{
+		dir: dir,
+		lru: newLRU[string, int64](maxBytes, func(size int64) int64 { return size }),
+	}
+	c.lru.onEvict = func(path string, _ int64) {
+		os.Remove(path)
+	}
+
+	type existingFile struct {
+		path string
+		info fs.FileInfo
+	}
+	var existing []existingFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && strings.HasSuffix(path, purgeSuffix) {
+			// left behind by a purge that was interrupted
+			os.RemoveAll(path)
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			// left behind by a write that was interrupted
+			os.Remove(path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		existing = append(existing, existingFile{path, info})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].info.ModTime().Before(existing[j].info.ModTime())
+	})
+	for _, f := range existing {
+		c.lru.add(f.path, f.info.Size())
+	}
+	return c, nil
+}
+
+func (c *diskTileCache) path(name string, etag string, offset uint64, length uint64) string {
+	return filepath.Join(c.dir, hashPathComponent(name), hashPathComponent(etag), strconv.FormatUint(offset, 10)+"-"+strconv.FormatUint(length, 10))
+}
+
+func (c *diskTileCache) Get(name string, etag string, offset uint64, length uint64) ([]byte, bool) {
+	path := c.path(name, etag, offset, length)
+	c.mu.Lock()
+	_, ok := c.lru.get(path)
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *diskTileCache) Put(name string, etag string, offset uint64, length uint64, data []byte) {
+	if int64(len(data)) > c.lru.maxSize {
+		return
+	}
+	path := c.path(name, etag, offset, length)
+	// write to a temporary file first so readers never see a partial tile
+	tmp, err := os.CreateTemp(c.dir, "*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	// move it into place under the lock, so that a concurrent Purge cannot move
+	// the directory away or leave behind a file the LRU does not track
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	c.lru.add(path, int64(len(data)))
+}
+
+func (c *diskTileCache) Purge(name string, etag string) {
+	dir := filepath.Join(c.dir, hashPathComponent(name), hashPathComponent(etag))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.removeIf(func(path string) bool {
+		return filepath.Dir(path) == dir
+	})
+	// move the directory out of the way and delete it in the background,
+	// as removing many files can take a while
+	trash, err := os.MkdirTemp(c.dir, "*"+purgeSuffix)
+	if err != nil {
+		os.RemoveAll(dir)
+		return
+	}
+	if err := os.Rename(dir, filepath.Join(trash, "tiles")); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(dir)
+	}
+	go os.RemoveAll(trash)
+}
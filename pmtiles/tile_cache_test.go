@@ -0,0 +1,180 @@
+package pmtiles
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTileCache(t *testing.T) {
+	cache := NewMemoryTileCache(10)
+	cache.Put("a", "etag1", 0, 4, []byte{1, 2, 3, 4})
+	cache.Put("a", "etag1", 4, 4, []byte{5, 6, 7, 8})
+	data, ok := cache.Get("a", "etag1", 0, 4)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1, 2, 3, 4}, data)
+	_, ok = cache.Get("a", "etag2", 0, 4)
+	assert.False(t, ok)
+
+	// over budget evicts the least recently used tile
+	cache.Put("b", "etag1", 0, 4, []byte{9, 9, 9, 9})
+	_, ok = cache.Get("a", "etag1", 4, 4)
+	assert.False(t, ok)
+	_, ok = cache.Get("a", "etag1", 0, 4)
+	assert.True(t, ok)
+
+	// larger than the whole budget is not cached
+	cache.Put("c", "etag1", 0, 11, make([]byte, 11))
+	_, ok = cache.Get("c", "etag1", 0, 11)
+	assert.False(t, ok)
+
+	cache.Purge("a", "etag1")
+	_, ok = cache.Get("a", "etag1", 0, 4)
+	assert.False(t, ok)
+	_, ok = cache.Get("b", "etag1", 0, 4)
+	assert.True(t, ok)
+}
+
+func TestDiskTileCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskTileCache(dir, 10)
+	assert.Nil(t, err)
+	cache.Put("a", "etag1", 0, 4, []byte{1, 2, 3, 4})
+	cache.Put("a", "etag2", 0, 4, []byte{5, 6, 7, 8})
+	data, ok := cache.Get("a", "etag1", 0, 4)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1, 2, 3, 4}, data)
+
+	// a new cache reuses the files in the directory
+	cache, err = NewDiskTileCache(dir, 10)
+	assert.Nil(t, err)
+	data, ok = cache.Get("a", "etag2", 0, 4)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{5, 6, 7, 8}, data)
+
+	cache.Put("b", "etag1", 0, 4, []byte{9, 9, 9, 9})
+	_, ok = cache.Get("a", "etag1", 0, 4)
+	assert.False(t, ok)
+
+	cache.Purge("a", "etag2")
+	_, ok = cache.Get("a", "etag2", 0, 4)
+	assert.False(t, ok)
+	_, err = os.Stat(filepath.Join(dir, hashPathComponent("a"), hashPathComponent("etag2")))
+	assert.True(t, os.IsNotExist(err))
+	_, ok = cache.Get("b", "etag1", 0, 4)
+	assert.True(t, ok)
+
+	// purged files are deleted in the background
+	assert.Eventually(t, func() bool {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*"+purgeSuffix))
+		return len(matches) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDiskTileCacheInterruptedPurge(t *testing.T) {
+	dir := t.TempDir()
+	trash := filepath.Join(dir, "123"+purgeSuffix)
+	writeDirTile(t, trash, "tiles/0-4", []byte{1, 2, 3, 4})
+
+	_, err := NewDiskTileCache(dir, 10)
+	assert.Nil(t, err)
+	_, err = os.Stat(trash)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskTileCacheConcurrentPurge(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskTileCache(dir, 1000)
+	assert.Nil(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cache.Put("a", "etag1", uint64(j), 1, []byte{1})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cache.Purge("a", "etag1")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every tile file is tracked by the LRU, and every tracked file exists
+	var files []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if d.IsDir() && strings.HasSuffix(path, purgeSuffix) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	var tracked []string
+	for path := range cache.(*diskTileCache).lru.items {
+		tracked = append(tracked, path)
+	}
+	assert.ElementsMatch(t, tracked, files)
+}
+
+func TestDiskTileCacheInterruptedPut(t *testing.T) {
+	dir := t.TempDir()
+	writeDirTile(t, dir, "123.tmp", []byte{1, 2, 3, 4})
+
+	_, err := NewDiskTileCache(dir, 10)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "123.tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServerTileCache(t *testing.T) {
+	mockBucket, server := newServer(t)
+	cache := NewMemoryTileCache(1000)
+	server.SetTileCache(cache)
+	header := HeaderV3{
+		TileType: Mvt,
+	}
+	mockBucket.items["archive.pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {0, 1, 2, 3},
+		{1, 0, 0}: {4, 5},
+	}, false, Gzip)
+
+	statusCode, _, data := server.Get(context.Background(), "/archive/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{0, 1, 2, 3}, data)
+	etag := generateEtag(mockBucket.items["archive.pmtiles"])
+	_, ok := cache.Get("archive", etag, 0, 4)
+	assert.True(t, ok)
+
+	mockBucket.items["archive.pmtiles"] = fakeArchive(header, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: {6, 7, 8, 9},
+		{1, 0, 0}: {4, 5},
+	}, false, Gzip)
+
+	// served from the cache until a bucket request detects the change
+	statusCode, _, data = server.Get(context.Background(), "/archive/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{0, 1, 2, 3}, data)
+
+	statusCode, _, data = server.Get(context.Background(), "/archive/1/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{4, 5}, data)
+	_, ok = cache.Get("archive", etag, 0, 4)
+	assert.False(t, ok)
+
+	statusCode, _, data = server.Get(context.Background(), "/archive/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{6, 7, 8, 9}, data)
+}
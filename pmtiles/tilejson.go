@@ -6,6 +6,11 @@ import (
 
 // CreateTileJSON returns TileJSON from an archive header+metadata and a given public tileURL.
 func CreateTileJSON(header HeaderV3, metadataBytes []byte, tileURL string) ([]byte, error) {
+	return createTileJSON(header, metadataBytes, tileURL, "")
+}
+
+// createTileJSON returns TileJSON with tileQuery, if any, added to the tile URL.
+func createTileJSON(header HeaderV3, metadataBytes []byte, tileURL string, tileQuery string) ([]byte, error) {
 	var metadataMap map[string]interface{}
 	json.Unmarshal(metadataBytes, &metadataMap)
 
@@ -18,7 +23,7 @@ func CreateTileJSON(header HeaderV3, metadataBytes []byte, tileURL string) ([]by
 		tileURL = "https://example.com"
 	}
 
-	tilejson["tiles"] = []string{tileURL + "/{z}/{x}/{y}" + headerExt(header)}
+	tilejson["tiles"] = []string{withQuery(tileURL+"/{z}/{x}/{y}"+headerExt(header), tileQuery)}
 	if ok := header.TileType == Mvt; ok {
 		tilejson["vector_layers"] = metadataMap["vector_layers"]
 	}
@@ -0,0 +1,51 @@
+package pmtiles
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+)
+
+type transcodeKey struct {
+	sourceEtag string
+	tileType   TileType
+}
+
+// transcodeCache is an LRU of transcoded raster tiles, keyed by the ETag of the source tile.
+type transcodeCache struct {
+	mu  sync.Mutex
+	lru *lru[transcodeKey, []byte]
+}
+
+func newTranscodeCache(maxEntries int) *transcodeCache {
+	return &transcodeCache{lru: newLRU[transcodeKey, []byte](int64(maxEntries), nil)}
+}
+
+func (c *transcodeCache) get(key transcodeKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.get(key)
+}
+
+func (c *transcodeCache) put(key transcodeKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.add(key, data)
+}
+
+// transcodeRaster re-encodes a PNG, JPEG or WebP tile as a PNG or JPEG tile.
+// Transparent pixels are drawn over white when encoding JPEG.
+func transcodeRaster(data []byte, tileType TileType) ([]byte, error) {
+	img, err := decodeRaster(data)
+	if err != nil {
+		return nil, err
+	}
+	if opaque, ok := img.(interface{ Opaque() bool }); tileType == Jpeg && (!ok || !opaque.Opaque()) {
+		flattened := image.NewRGBA(img.Bounds())
+		draw.Draw(flattened, flattened.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flattened, flattened.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = flattened
+	}
+	return encodeRaster(img, tileType)
+}
@@ -0,0 +1,92 @@
+package pmtiles
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPng(t *testing.T, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var b bytes.Buffer
+	assert.Nil(t, png.Encode(&b, img))
+	return b.Bytes()
+}
+
+func TestTranscodeRaster(t *testing.T) {
+	transparent := testPng(t, color.RGBA{0, 0, 0, 0})
+	data, err := transcodeRaster(transparent, Jpeg)
+	assert.Nil(t, err)
+	assert.Equal(t, TileType(Jpeg), magicToTileType(data))
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	assert.Nil(t, err)
+	r, g, b, _ := img.At(4, 4).RGBA()
+	assert.True(t, r > 0xf000 && g > 0xf000 && b > 0xf000)
+
+	data, err = transcodeRaster(data, Png)
+	assert.Nil(t, err)
+	assert.Equal(t, TileType(Png), magicToTileType(data))
+
+	_, err = transcodeRaster(transparent, Webp)
+	assert.NotNil(t, err)
+}
+
+func TestTranscodeCache(t *testing.T) {
+	cache := newTranscodeCache(2)
+	cache.put(transcodeKey{"a", Png}, []byte{1})
+	cache.put(transcodeKey{"b", Png}, []byte{2})
+	_, ok := cache.get(transcodeKey{"a", Png})
+	assert.True(t, ok)
+	cache.put(transcodeKey{"c", Png}, []byte{3})
+	_, ok = cache.get(transcodeKey{"b", Png})
+	assert.False(t, ok)
+	data, ok := cache.get(transcodeKey{"a", Png})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{1}, data)
+	_, ok = cache.get(transcodeKey{"a", Jpeg})
+	assert.False(t, ok)
+}
+
+func TestServerTranscodeRaster(t *testing.T) {
+	mockBucket, server := newServer(t)
+	stored := testPng(t, color.RGBA{255, 0, 0, 255})
+	mockBucket.items["archive.pmtiles"] = fakeArchive(HeaderV3{TileType: Png, TileCompression: NoCompression}, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: stored,
+	}, false, Gzip)
+
+	statusCode, _, _ := server.Get(context.Background(), "/archive/0/0/0.jpg")
+	assert.Equal(t, 400, statusCode)
+
+	server.SetRasterTranscoding(16)
+	statusCode, headers, data := server.Get(context.Background(), "/archive/0/0/0.jpg")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "image/jpeg", headers["Content-Type"])
+	assert.Equal(t, TileType(Jpeg), magicToTileType(data))
+	_, ok := server.transcoder.get(transcodeKey{generateEtag(stored), Jpeg})
+	assert.True(t, ok)
+
+	statusCode, _, data2 := server.Get(context.Background(), "/archive/0/0/0.jpg")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, data, data2)
+
+	statusCode, headers, data = server.Get(context.Background(), "/archive/0/0/0.png")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, "image/png", headers["Content-Type"])
+	assert.Equal(t, stored, data)
+
+	statusCode, _, _ = server.Get(context.Background(), "/archive/0/0/0.webp")
+	assert.Equal(t, 406, statusCode)
+	statusCode, _, _ = server.Get(context.Background(), "/archive/0/0/0.mvt")
+	assert.Equal(t, 400, statusCode)
+}
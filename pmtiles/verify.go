@@ -2,90 +2,213 @@ package pmtiles
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/RoaringBitmap/roaring/roaring64"
 	"io"
 	"log"
 	"math"
-	"os"
+	"slices"
 	"time"
 )
 
+// Severities of a VerifyIssue.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Categories of a VerifyIssue.
+const (
+	CategoryHeader     = "header"
+	CategoryOffsets    = "offsets"
+	CategoryDirectory  = "directory"
+	CategoryCounts     = "counts"
+	CategoryClustering = "clustering"
+	CategoryZoom       = "zoom"
+	CategoryBounds     = "bounds"
+	CategoryContents   = "contents"
+)
+
+// maxReportedIssues limits how many issues are kept in a VerifyReport; all issues are still counted.
+const maxReportedIssues = 1000
+
+// VerifyEntry is the directory entry an issue refers to.
+type VerifyEntry struct {
+	TileID    uint64 `json:"tile_id"`
+	Z         uint8  `json:"z"`
+	X         uint32 `json:"x"`
+	Y         uint32 `json:"y"`
+	Offset    uint64 `json:"offset"`
+	Length    uint32 `json:"length"`
+	RunLength uint32 `json:"run_length"`
+}
+
+// VerifyIssue is a single problem found in an archive.
+type VerifyIssue struct {
+	Severity string       `json:"severity"`
+	Category string       `json:"category"`
+	Message  string       `json:"message"`
+	Entry    *VerifyEntry `json:"entry,omitempty"`
+	err      error
+}
+
+// VerifyReport lists every issue found in an archive.
+type VerifyReport struct {
+	File      string        `json:"file"`
+	Errors    int           `json:"errors"`
+	Warnings  int           `json:"warnings"`
+	Truncated bool          `json:"truncated"`
+	Issues    []VerifyIssue `json:"issues"`
+}
+
+func (r *VerifyReport) add(logger *log.Logger, severity string, category string, e *EntryV3, err error) {
+	if severity == SeverityError {
+		r.Errors++
+		logger.Printf("Invalid: %v", err)
+	} else {
+		r.Warnings++
+		logger.Printf("Warning: %v", err)
+	}
+
+	if len(r.Issues) >= maxReportedIssues {
+		r.Truncated = true
+		return
+	}
+	issue := VerifyIssue{Severity: severity, Category: category, Message: err.Error(), err: err}
+	if e != nil {
+		z, x, y := IDToZxy(e.TileID)
+		issue.Entry = &VerifyEntry{e.TileID, z, x, y, e.Offset, e.Length, e.RunLength}
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// Failed reports whether the archive fails the policy failOn, one of error, warning or none.
+func (r VerifyReport) Failed(failOn string) bool {
+	switch failOn {
+	case "none":
+		return false
+	case SeverityWarning:
+		return r.Errors+r.Warnings > 0
+	default:
+		return r.Errors > 0
+	}
+}
+
+// Err joins the errors in the report, or returns nil if there are none.
+func (r VerifyReport) Err() error {
+	var errs []error
+	for _, issue := range r.Issues {
+		if issue.Severity == SeverityError {
+			errs = append(errs, issue.err)
+		}
+	}
+	if r.Errors > len(errs) {
+		errs = append(errs, fmt.Errorf("%d more errors not reported", r.Errors-len(errs)))
+	}
+	return errors.Join(errs...)
+}
+
 // Verify that an archive's header statistics are correct,
 // and that tiles are propertly ordered if clustered=true.
 func Verify(logger *log.Logger, file string) error {
+	report, err := VerifyArchive(logger, "", file, false)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// VerifyDeep checks an archive like Verify, and also fetches every distinct tile content
+// to check it against the header TileCompression and TileType; failures are returned as TileErrors.
+func VerifyDeep(logger *log.Logger, file string) error {
+	report, err := VerifyArchive(logger, "", file, true)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// VerifyArchive checks an archive like Verify, but collects every issue found into a report.
+// The archive may be a local file, an HTTP URL or a key in bucketURL.
+// An error is only returned if the archive could not be read at all.
+func VerifyArchive(logger *log.Logger, bucketURL string, file string, deep bool) (VerifyReport, error) {
 	start := time.Now()
 	ctx := context.Background()
+	report := VerifyReport{File: file, Issues: []VerifyIssue{}}
 
-	bucketURL, key, err := NormalizeBucketKey("", "", file)
+	bucketURL, key, err := NormalizeBucketKey(bucketURL, "", file)
 
 	if err != nil {
-		return err
+		return report, err
 	}
 
 	bucket, err := OpenBucket(ctx, bucketURL, "")
 
 	if err != nil {
-		return fmt.Errorf("failed to open bucket for %s, %w", bucketURL, err)
+		return report, fmt.Errorf("failed to open bucket for %s, %w", bucketURL, err)
 	}
 	defer bucket.Close()
 
 	r, err := bucket.NewRangeReader(ctx, key, 0, 16384)
 
 	if err != nil {
-		return fmt.Errorf("failed to create range reader for %s, %w", key, err)
+		return report, fmt.Errorf("failed to create range reader for %s, %w", key, err)
 	}
+	defer r.Close()
 	b, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("failed to read %s, %w", key, err)
-	}
-	r.Close()
-
-	header, err := DeserializeHeader(b[0:HeaderV3LenBytes])
-
-	if err != nil {
-		return fmt.Errorf("failed to read %s, %w", key, err)
+		return report, fmt.Errorf("failed to read %s, %w", key, err)
 	}
 
-	if header.RootOffset == 0 {
-		return fmt.Errorf("Root directory offset=%v must not be 0", header.RootOffset)
+	if len(b) < HeaderV3LenBytes {
+		report.add(logger, SeverityError, CategoryHeader, nil, fmt.Errorf("archive length %v is shorter than the header", len(b)))
+		return report, nil
 	}
 
-	if header.MetadataOffset == 0 {
-		return fmt.Errorf("Metadata offset=%v must not be 0", header.MetadataOffset)
-	}
+	header, err := DeserializeHeader(b[0:HeaderV3LenBytes])
 
-	if header.LeafDirectoryOffset == 0 {
-		return fmt.Errorf("Leaf directories offset=%v must not be 0", header.LeafDirectoryOffset)
+	if err != nil {
+		report.add(logger, SeverityError, CategoryHeader, nil, fmt.Errorf("failed to read header, %w", err))
+		return report, nil
 	}
 
-	if header.TileDataOffset == 0 {
-		return fmt.Errorf("Tile data offset=%v must not be 0", header.TileDataOffset)
+	size, err := bucket.Size(ctx, key)
+	if err != nil {
+		return report, fmt.Errorf("failed to get size of %s, %w", key, err)
 	}
-
-	fileInfo, _ := os.Stat(file)
-
-	if header.RootLength > uint64(fileInfo.Size()) {
-		return fmt.Errorf("Root directory offset=%v length=%v out of bounds", header.RootOffset, header.RootLength)
+	fileSize := uint64(size)
+
+	sections := []struct {
+		name   string
+		offset uint64
+		length uint64
+	}{
+		{"Root directory", header.RootOffset, header.RootLength},
+		{"Metadata", header.MetadataOffset, header.MetadataLength},
+		{"Leaf directories", header.LeafDirectoryOffset, header.LeafDirectoryLength},
+		{"Tile data", header.TileDataOffset, header.TileDataLength},
 	}
 
-	if header.MetadataLength > uint64(fileInfo.Size()) {
-		return fmt.Errorf("Metadata offset=%v length=%v out of bounds", header.MetadataOffset, header.MetadataLength)
+	for _, s := range sections {
+		if s.offset == 0 {
+			report.add(logger, SeverityError, CategoryOffsets, nil, fmt.Errorf("%s offset=%v must not be 0", s.name, s.offset))
+		}
+		if s.offset+s.length > fileSize {
+			report.add(logger, SeverityError, CategoryOffsets, nil, fmt.Errorf("%s offset=%v length=%v out of bounds", s.name, s.offset, s.length))
+		}
 	}
 
-	if header.LeafDirectoryLength > uint64(fileInfo.Size()) {
-		return fmt.Errorf("Leaf directories offset=%v length=%v out of bounds", header.LeafDirectoryOffset, header.LeafDirectoryLength)
-	}
+	lengthFromHeader := HeaderV3LenBytes + header.RootLength + header.MetadataLength + header.LeafDirectoryLength + header.TileDataLength
+	lengthFromHeaderWithPadding := 16384 + header.MetadataLength + header.LeafDirectoryLength + header.TileDataLength
 
-	if header.TileDataLength > uint64(fileInfo.Size()) {
-		return fmt.Errorf("Tile data offset=%v length=%v out of bounds", header.TileDataOffset, header.TileDataLength)
+	if !(fileSize == lengthFromHeader || fileSize == lengthFromHeaderWithPadding) {
+		report.add(logger, SeverityError, CategoryOffsets, nil, fmt.Errorf("total length of archive %v does not match header %v or %v (padded)", fileSize, lengthFromHeader, lengthFromHeaderWithPadding))
 	}
 
-	lengthFromHeader := int64(HeaderV3LenBytes + header.RootLength + header.MetadataLength + header.LeafDirectoryLength + header.TileDataLength)
-	lengthFromHeaderWithPadding := int64(16384 + header.MetadataLength + header.LeafDirectoryLength + header.TileDataLength)
-
-	if !(fileInfo.Size() == lengthFromHeader || fileInfo.Size() == lengthFromHeaderWithPadding) {
-		return fmt.Errorf("total length of archive %v does not match header %v or %v (padded)", fileInfo.Size(), lengthFromHeader, lengthFromHeaderWithPadding)
+	if header.RootOffset+header.RootLength > fileSize || header.LeafDirectoryOffset+header.LeafDirectoryLength > fileSize {
+		// the directories cannot be read
+		return report, nil
 	}
 
 	var minTileID uint64
@@ -97,6 +220,7 @@ func Verify(logger *log.Logger, file string) error {
 	tileEntries := 0
 	offsets := roaring64.New()
 	var currentOffset uint64
+	contents := make(map[uint64]EntryV3)
 
 	err = IterateEntries(header,
 		func(offset uint64, length uint64) ([]byte, error) {
@@ -108,64 +232,112 @@ func Verify(logger *log.Logger, file string) error {
 			return io.ReadAll(reader)
 		},
 		func(e EntryV3) {
-			offsets.Add(e.Offset)
+			if deep {
+				if _, ok := contents[e.Offset]; !ok {
+					contents[e.Offset] = e
+				}
+			}
 			addressedTiles += int(e.RunLength)
 			tileEntries++
 
 			if e.TileID < minTileID {
 				minTileID = e.TileID
 			}
-			if e.TileID > maxTileID {
-				maxTileID = e.TileID
+			if last := e.TileID + uint64(e.RunLength) - 1; last > maxTileID {
+				maxTileID = last
 			}
 
 			if e.Offset+uint64(e.Length) > header.TileDataLength {
-				logger.Printf("Invalid: %v outside of tile data section", e)
+				report.add(logger, SeverityError, CategoryOffsets, &e, fmt.Errorf("entry %v outside of tile data section", e))
 			}
 
-			if header.Clustered {
-				if !offsets.Contains(e.Offset) {
-					if e.Offset != currentOffset {
-						logger.Printf("Invalid: out-of-order entry %v in clustered archive", e)
-					}
-					currentOffset += uint64(e.Length)
+			if header.Clustered && !offsets.Contains(e.Offset) {
+				if e.Offset != currentOffset {
+					report.add(logger, SeverityError, CategoryClustering, &e, fmt.Errorf("out-of-order entry %v in clustered archive", e))
 				}
+				currentOffset = e.Offset + uint64(e.Length)
 			}
+			offsets.Add(e.Offset)
 		})
 
 	if err != nil {
-		return err
+		report.add(logger, SeverityError, CategoryDirectory, nil, fmt.Errorf("failed to read directories, %w", err))
+		return report, nil
+	}
+
+	if !header.Clustered {
+		report.add(logger, SeverityWarning, CategoryClustering, nil, fmt.Errorf("archive is not clustered; reads may be slower"))
 	}
 
 	if uint64(addressedTiles) != header.AddressedTilesCount {
-		return fmt.Errorf("invalid: header AddressedTilesCount=%v but %v tiles addressed", header.AddressedTilesCount, addressedTiles)
+		report.add(logger, SeverityError, CategoryCounts, nil, fmt.Errorf("header AddressedTilesCount=%v but %v tiles addressed", header.AddressedTilesCount, addressedTiles))
 	}
 
 	if uint64(tileEntries) != header.TileEntriesCount {
-		return fmt.Errorf("invalid: header TileEntriesCount=%v but %v tile entries", header.TileEntriesCount, tileEntries)
+		report.add(logger, SeverityError, CategoryCounts, nil, fmt.Errorf("header TileEntriesCount=%v but %v tile entries", header.TileEntriesCount, tileEntries))
 	}
 
 	if offsets.GetCardinality() != header.TileContentsCount {
-		return fmt.Errorf("invalid: header TileContentsCount=%v but %v tile contents", header.TileContentsCount, offsets.GetCardinality())
+		report.add(logger, SeverityError, CategoryCounts, nil, fmt.Errorf("header TileContentsCount=%v but %v tile contents", header.TileContentsCount, offsets.GetCardinality()))
 	}
 
-	if z, _, _ := IDToZxy(minTileID); z != header.MinZoom {
-		return fmt.Errorf("invalid: header MinZoom=%v does not match min tile z %v", header.MinZoom, z)
-	}
+	if tileEntries > 0 {
+		if z, _, _ := IDToZxy(minTileID); z != header.MinZoom {
+			report.add(logger, SeverityError, CategoryZoom, nil, fmt.Errorf("header MinZoom=%v does not match min tile z %v", header.MinZoom, z))
+		}
 
-	// TODO this is technically kind of wrong
-	if z, _, _ := IDToZxy(maxTileID); z != header.MaxZoom {
-		return fmt.Errorf("invalid: header MaxZoom=%v does not match max tile z %v", header.MaxZoom, z)
+		if z, _, _ := IDToZxy(maxTileID); z != header.MaxZoom {
+			report.add(logger, SeverityError, CategoryZoom, nil, fmt.Errorf("header MaxZoom=%v does not match max tile z %v", header.MaxZoom, z))
+		}
 	}
 
 	if !(header.CenterZoom >= header.MinZoom && header.CenterZoom <= header.MaxZoom) {
-		return fmt.Errorf("invalid: header CenterZoom=%v not within MinZoom/MaxZoom", header.CenterZoom)
+		report.add(logger, SeverityError, CategoryZoom, nil, fmt.Errorf("header CenterZoom=%v not within MinZoom/MaxZoom", header.CenterZoom))
 	}
 
 	if header.MinLonE7 >= header.MaxLonE7 || header.MinLatE7 >= header.MaxLatE7 {
-		return fmt.Errorf("Invalid: bounds has area <= 0: clients may not display tiles correctly")
+		report.add(logger, SeverityError, CategoryBounds, nil, fmt.Errorf("bounds has area <= 0: clients may not display tiles correctly"))
+	} else if header.CenterLonE7 < header.MinLonE7 || header.CenterLonE7 > header.MaxLonE7 || header.CenterLatE7 < header.MinLatE7 || header.CenterLatE7 > header.MaxLatE7 {
+		report.add(logger, SeverityWarning, CategoryBounds, nil, fmt.Errorf("center is outside of bounds"))
 	}
 
-	logger.Printf("Completed verify in %v.\n", time.Since(start))
-	return nil
+	if header.MinLonE7 < -1800000000 || header.MaxLonE7 > 1800000000 || header.MinLatE7 < -900000000 || header.MaxLatE7 > 900000000 {
+		report.add(logger, SeverityWarning, CategoryBounds, nil, fmt.Errorf("bounds exceed valid longitude/latitude range"))
+	}
+
+	if deep {
+		verifyContents(ctx, logger, bucket, key, header, contents, &report)
+	}
+
+	logger.Printf("Completed verify in %v with %d errors and %d warnings.\n", time.Since(start), report.Errors, report.Warnings)
+	return report, nil
+}
+
+func verifyContents(ctx context.Context, logger *log.Logger, bucket Bucket, key string, header HeaderV3, contents map[uint64]EntryV3, report *VerifyReport) {
+	sortedOffsets := make([]uint64, 0, len(contents))
+	for offset := range contents {
+		sortedOffsets = append(sortedOffsets, offset)
+	}
+	slices.Sort(sortedOffsets)
+
+	bar := defaultProgressbar(logger, int64(len(sortedOffsets)))
+
+	for _, offset := range sortedOffsets {
+		e := contents[offset]
+		z, x, y := IDToZxy(e.TileID)
+
+		var data []byte
+		reader, err := bucket.NewRangeReader(ctx, key, int64(header.TileDataOffset+e.Offset), int64(e.Length))
+		if err == nil {
+			data, err = io.ReadAll(reader)
+			reader.Close()
+		}
+		if err == nil {
+			err = verifyTileContents(header.TileType, header.TileCompression, data)
+		}
+		if err != nil {
+			report.add(logger, SeverityError, CategoryContents, &e, TileError{z, x, y, err})
+		}
+		bar.Add(1)
+	}
 }
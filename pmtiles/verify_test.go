@@ -0,0 +1,164 @@
+package pmtiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyDeep(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "rewritten.pmtiles")
+	assert.Nil(t, Recompress(logger, "fixtures/test_fixture_1.pmtiles", output, "", "", 1))
+	assert.Nil(t, VerifyDeep(logger, output))
+}
+
+func TestVerifyMvt(t *testing.T) {
+	var feature []byte
+	feature = protowire.AppendTag(feature, 3, protowire.VarintType)
+	feature = protowire.AppendVarint(feature, 1)
+	feature = protowire.AppendTag(feature, 4, protowire.BytesType)
+	feature = protowire.AppendBytes(feature, []byte{9, 0, 0})
+
+	var layer []byte
+	layer = protowire.AppendTag(layer, 15, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, 2)
+	layer = protowire.AppendTag(layer, 1, protowire.BytesType)
+	layer = protowire.AppendString(layer, "layer")
+	layer = protowire.AppendTag(layer, 2, protowire.BytesType)
+	layer = protowire.AppendBytes(layer, feature)
+
+	var tile []byte
+	tile = protowire.AppendTag(tile, 3, protowire.BytesType)
+	tile = protowire.AppendBytes(tile, layer)
+	assert.Nil(t, verifyMvt(tile))
+
+	var unnamed []byte
+	unnamed = protowire.AppendTag(unnamed, 3, protowire.BytesType)
+	unnamed = protowire.AppendBytes(unnamed, []byte{})
+	assert.NotNil(t, verifyMvt(unnamed))
+
+	assert.NotNil(t, verifyMvt([]byte{0x1a, 0xff}))
+}
+
+func TestVerifyTileContents(t *testing.T) {
+	assert.Nil(t, verifyTileContents(Png, NoCompression, []byte{0x89, 'P', 'N', 'G'}))
+	assert.NotNil(t, verifyTileContents(Png, NoCompression, []byte{0xff, 0xd8, 0xff}))
+	assert.NotNil(t, verifyTileContents(Mvt, Gzip, []byte{0x1a, 0x00}))
+}
+
+func TestVerifyDeepReportsTile(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "bad.pmtiles")
+	w, err := NewWriter(logger, output, HeaderV3{TileType: Png, TileCompression: NoCompression}, true, t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteTile(0, 0, 0, []byte{0x89, 'P', 'N', 'G'}))
+	assert.Nil(t, w.WriteTile(1, 1, 0, []byte{0xff, 0xd8, 0xff}))
+	_, err = w.Finalize()
+	assert.Nil(t, err)
+
+	assert.Nil(t, Verify(logger, output))
+
+	err = VerifyDeep(logger, output)
+	var tileErr TileError
+	assert.True(t, errors.As(err, &tileErr))
+	assert.Equal(t, uint8(1), tileErr.Z)
+	assert.Equal(t, uint32(1), tileErr.X)
+	assert.Equal(t, uint32(0), tileErr.Y)
+}
+
+func TestVerifyArchiveReport(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "tampered.pmtiles")
+	w, err := NewWriter(logger, output, HeaderV3{TileType: Png, TileCompression: NoCompression}, true, t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteTile(0, 0, 0, []byte{0x89, 'P', 'N', 'G'}))
+	assert.Nil(t, w.WriteTile(1, 1, 0, []byte{0x89, 'P', 'N', 'G', 0}))
+	header, err := w.Finalize()
+	assert.Nil(t, err)
+
+	report, err := VerifyArchive(logger, "", output, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Errors)
+	assert.False(t, report.Failed(SeverityWarning))
+
+	header.TileEntriesCount++
+	header.MaxZoom = 2
+	header.Clustered = false
+	file, err := os.OpenFile(output, os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = file.WriteAt(SerializeHeader(header), 0)
+	assert.Nil(t, err)
+	file.Close()
+
+	report, err = VerifyArchive(logger, "", output, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Errors)
+	assert.Equal(t, 1, report.Warnings)
+	categories := make(map[string]string)
+	for _, issue := range report.Issues {
+		categories[issue.Category] = issue.Severity
+	}
+	assert.Equal(t, map[string]string{CategoryCounts: SeverityError, CategoryZoom: SeverityError, CategoryClustering: SeverityWarning}, categories)
+
+	assert.True(t, report.Failed(SeverityError))
+	assert.False(t, report.Failed("none"))
+	assert.NotNil(t, report.Err())
+	assert.NotNil(t, Verify(logger, output))
+
+	b, err := json.Marshal(report)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"category":"counts"`)
+}
+
+func TestVerifyArchiveClusteringOrder(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "unordered.pmtiles")
+	w, err := NewWriter(logger, output, HeaderV3{TileType: Png, TileCompression: NoCompression}, true, t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteTile(0, 0, 0, []byte{0x89, 'P', 'N', 'G'}))
+	assert.Nil(t, w.WriteTile(1, 0, 0, []byte{0x89, 'P', 'N', 'G', 0}))
+	header, err := w.Finalize()
+	assert.Nil(t, err)
+
+	// swap the two tile contents so entries no longer follow the tile data order
+	b, err := os.ReadFile(output)
+	assert.Nil(t, err)
+	directory := DeserializeEntries(bytes.NewBuffer(b[header.RootOffset:header.RootOffset+header.RootLength]), header.InternalCompression)
+	directory[0].Offset, directory[1].Offset = 5, 0
+	directory[0].Length, directory[1].Length = 4, 5
+	root := SerializeEntries(directory, header.InternalCompression)
+	assert.Equal(t, int(header.RootLength), len(root))
+	copy(b[header.RootOffset:], root)
+	assert.Nil(t, os.WriteFile(output, b, 0644))
+
+	report, err := VerifyArchive(logger, "", output, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Errors)
+	for _, issue := range report.Issues {
+		assert.Equal(t, CategoryClustering, issue.Category)
+	}
+	assert.Equal(t, uint64(5), report.Issues[0].Entry.Offset)
+	assert.Equal(t, uint8(1), report.Issues[1].Entry.Z)
+}
+
+func TestVerifyHttp(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "remote.pmtiles")
+	assert.Nil(t, Recompress(logger, "fixtures/test_fixture_1.pmtiles", output, "", "", 1))
+
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer server.Close()
+
+	assert.Nil(t, VerifyDeep(logger, server.URL+"/remote.pmtiles"))
+
+	report, err := VerifyArchive(logger, server.URL, "remote.pmtiles", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Errors)
+
+	_, err = VerifyArchive(logger, server.URL, "missing.pmtiles", false)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,144 @@
+package pmtiles
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// TileError describes a tile whose contents failed verification.
+type TileError struct {
+	Z   uint8
+	X   uint32
+	Y   uint32
+	Err error
+}
+
+func (e TileError) Error() string {
+	return fmt.Sprintf("tile %d/%d/%d: %v", e.Z, e.X, e.Y, e.Err)
+}
+
+func (e TileError) Unwrap() error {
+	return e.Err
+}
+
+// verifyTileContents checks that stored tile data decompresses and decodes as the declared type.
+func verifyTileContents(tileType TileType, compression Compression, data []byte) error {
+	if compression == UnknownCompression && isGzip(data) {
+		compression = Gzip
+	}
+	if compression != UnknownCompression {
+		decompressed, err := decompressBytes(data, compression)
+		if err != nil {
+			compressionString, _ := compressionToString(compression)
+			return fmt.Errorf("failed to decompress as %s, %w", compressionString, err)
+		}
+		data = decompressed
+	}
+
+	switch tileType {
+	case Mvt:
+		return verifyMvt(data)
+	case Png, Jpeg, Webp, Avif:
+		if detected := magicToTileType(data); detected != tileType {
+			return fmt.Errorf("contents are not %s", tileTypeToString(tileType))
+		}
+	}
+	return nil
+}
+
+// consumeFields calls handle for each field of a protobuf message.
+// The value is the contents of a length-delimited field, or the encoded value of other fields.
+func consumeFields(b []byte, handle func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		value := b[:m]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(b)
+		}
+		if err := handle(num, typ, value); err != nil {
+			return err
+		}
+		b = b[m:]
+	}
+	return nil
+}
+
+func consumePackedVarints(b []byte) error {
+	for len(b) > 0 {
+		_, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func expectWireType(name string, typ protowire.Type, expected protowire.Type) error {
+	if typ != expected {
+		return fmt.Errorf("%s has wire type %d, expected %d", name, typ, expected)
+	}
+	return nil
+}
+
+// verifyMvt decodes the protobuf structure of a Mapbox Vector Tile, without interpreting geometry.
+func verifyMvt(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, typ protowire.Type, layer []byte) error {
+		if num != 3 {
+			return nil
+		}
+		if err := expectWireType("layer", typ, protowire.BytesType); err != nil {
+			return err
+		}
+		hasName := false
+		err := consumeFields(layer, func(num protowire.Number, typ protowire.Type, value []byte) error {
+			switch num {
+			case 1:
+				hasName = true
+				return expectWireType("layer name", typ, protowire.BytesType)
+			case 2:
+				if err := expectWireType("feature", typ, protowire.BytesType); err != nil {
+					return err
+				}
+				return verifyMvtFeature(value)
+			case 3, 4:
+				return expectWireType("layer keys/values", typ, protowire.BytesType)
+			case 5, 15:
+				return expectWireType("layer extent/version", typ, protowire.VarintType)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !hasName {
+			return errors.New("layer has no name")
+		}
+		return nil
+	})
+}
+
+func verifyMvtFeature(feature []byte) error {
+	return consumeFields(feature, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case 1, 3:
+			return expectWireType("feature id/type", typ, protowire.VarintType)
+		case 2, 4:
+			if err := expectWireType("feature tags/geometry", typ, protowire.BytesType); err != nil {
+				return err
+			}
+			return consumePackedVarints(value)
+		}
+		return nil
+	})
+}
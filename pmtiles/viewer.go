@@ -0,0 +1,41 @@
+package pmtiles
+
+import (
+	"context"
+	_ "embed"
+	"regexp"
+)
+
+// viewerHTML is a self-contained map viewer that loads the TileJSON of the archive it is served for.
+//
+//go:embed viewer.html
+var viewerHTML []byte
+
+var viewerPattern = regexp.MustCompile(`^\/([-A-Za-z0-9_\/!-_\.\*'\(\)']+)\/viewer$`)
+
+func parseViewerPath(path string) (bool, string) {
+	if res := viewerPattern.FindStringSubmatch(path); res != nil {
+		return true, res[1]
+	}
+	return false, ""
+}
+
+// SetViewer serves a map viewer page for each archive at /{name}/viewer,
+// rendering raster or vector tiles with per-layer colors and tile boundaries.
+func (server *Server) SetViewer(enabled bool) {
+	server.viewer = enabled
+}
+
+func (server *Server) getViewer(ctx context.Context, httpHeaders map[string]string, name string) (int, map[string]string, []byte) {
+	found, _, _, modTime, err := server.getArchiveHeaderMetadata(ctx, name)
+	if err != nil {
+		return 500, httpHeaders, []byte("I/O Error")
+	}
+	if !found {
+		return 404, httpHeaders, []byte("Archive not found")
+	}
+	httpHeaders["Content-Type"] = "text/html; charset=utf-8"
+	httpHeaders["ETag"] = generateEtag(viewerHTML)
+	setLastModified(httpHeaders, modTime)
+	return 200, httpHeaders, viewerHTML
+}
@@ -0,0 +1,109 @@
+package pmtiles
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scanArchiveFiles returns the etag of each archive in dir by archive name.
+func scanArchiveFiles(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".pmtiles") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[strings.TrimSuffix(filepath.ToSlash(rel), ".pmtiles")] = fileEtag(info)
+		return nil
+	})
+	return files, err
+}
+
+// Watch polls the archives of a local directory every interval until ctx is done,
+// purging the cached headers and directories of archives that changed or were removed.
+// With prewarm, the root directories of existing, new and changed archives are fetched ahead of requests.
+// Start must be called first.
+func (server *Server) Watch(ctx context.Context, interval time.Duration, prewarm bool) error {
+	var dir string
+	switch b := server.bucket.(type) {
+	case *FileBucket:
+		dir = b.path
+	case FileBucket:
+		dir = b.path
+	default:
+		return errors.New("Watching for changes requires a local directory")
+	}
+	files, err := scanArchiveFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if prewarm {
+			for name := range files {
+				server.prewarm(name)
+			}
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				files = server.reloadChangedFiles(dir, files, prewarm)
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadChangedFiles purges archives whose etag differs from the previous scan and returns the current scan.
+// Added or removed archives clear the cached listing of the bucket.
+func (server *Server) reloadChangedFiles(dir string, previous map[string]string, prewarm bool) map[string]string {
+	current, err := scanArchiveFiles(dir)
+	if err != nil {
+		server.logger.Printf("failed to scan %s for changes, %v", dir, err)
+		return previous
+	}
+	addedOrRemoved := len(current) != len(previous)
+	for name := range current {
+		if _, ok := previous[name]; !ok {
+			addedOrRemoved = true
+		}
+	}
+	if addedOrRemoved {
+		server.clearListing()
+	}
+	for name, etag := range previous {
+		if current[name] != etag {
+			server.reqs <- request{key: cacheKey{name: name, offset: 0, length: 0}, purgeEtag: etag}
+		}
+	}
+	if prewarm {
+		for name, etag := range current {
+			if previous[name] != etag {
+				server.prewarm(name)
+			}
+		}
+	}
+	return current
+}
+
+// prewarm fetches the header and root directory of an archive into the cache.
+func (server *Server) prewarm(name string) {
+	req := request{key: cacheKey{name: name, offset: 0, length: 0}, value: make(chan cachedValue, 1), compression: UnknownCompression}
+	server.reqs <- req
+	<-req.value
+}
@@ -0,0 +1,136 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeWatchedArchive(t *testing.T, dir string, name string, tile []byte, modTime time.Time) {
+	path := filepath.Join(dir, name+".pmtiles")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, os.WriteFile(path, fakeArchive(HeaderV3{TileType: Mvt}, map[string]interface{}{}, map[Zxy][]byte{
+		{0, 0, 0}: tile,
+	}, false, Gzip), 0644))
+	assert.Nil(t, os.Chtimes(path, modTime, modTime))
+}
+
+func newWatchedServer(t *testing.T, dir string) *Server {
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	server, err := NewServerWithBucket(NewFileBucket(dir), "", log.Default(), 10, "tiles.example.com")
+	assert.Nil(t, err)
+	server.Start()
+	return server
+}
+
+func TestScanArchiveFiles(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Unix(1000, 0)
+	writeWatchedArchive(t, dir, "a", []byte{0, 1}, modTime)
+	writeWatchedArchive(t, dir, "nested/b", []byte{0, 1}, modTime)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "readme.txt"), []byte("hello"), 0644))
+
+	files, err := scanArchiveFiles(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+	info, _ := os.Stat(filepath.Join(dir, "a.pmtiles"))
+	assert.Equal(t, fileEtag(info), files["a"])
+	assert.Contains(t, files, "nested/b")
+}
+
+func TestReloadChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeWatchedArchive(t, dir, "a", []byte{0, 1}, time.Unix(1000, 0))
+	server := newWatchedServer(t, dir)
+	cache := NewMemoryTileCache(1000)
+	server.SetTileCache(cache)
+
+	files, err := scanArchiveFiles(dir)
+	assert.Nil(t, err)
+	statusCode, _, data := server.Get(context.Background(), "/a/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{0, 1}, data)
+	_, ok := cache.Get("a", files["a"], 0, 2)
+	assert.True(t, ok)
+
+	// an unchanged directory purges nothing
+	files = server.reloadChangedFiles(dir, files, false)
+	_, ok = cache.Get("a", files["a"], 0, 2)
+	assert.True(t, ok)
+
+	oldEtag := files["a"]
+	writeWatchedArchive(t, dir, "a", []byte{2, 3}, time.Unix(2000, 0))
+	files = server.reloadChangedFiles(dir, files, false)
+	assert.NotEqual(t, oldEtag, files["a"])
+
+	// the purge is processed before the next request, so the stale tile is no longer cached
+	statusCode, _, data = server.Get(context.Background(), "/a/0/0/0.mvt")
+	assert.Equal(t, 200, statusCode)
+	assert.Equal(t, []byte{2, 3}, data)
+	_, ok = cache.Get("a", oldEtag, 0, 2)
+	assert.False(t, ok)
+}
+
+func TestReloadPrewarm(t *testing.T) {
+	dir := t.TempDir()
+	server := newWatchedServer(t, dir)
+	shared := newMemorySharedDirectoryCache()
+	server.SetSharedDirectoryCache(shared)
+
+	files := server.reloadChangedFiles(dir, map[string]string{}, true)
+	assert.Len(t, files, 0)
+	assert.Equal(t, 0, shared.sets)
+
+	writeWatchedArchive(t, dir, "a", []byte{0, 1}, time.Unix(1000, 0))
+	files = server.reloadChangedFiles(dir, files, true)
+	assert.Len(t, files, 1)
+	assert.Equal(t, 1, shared.sets)
+}
+
+func TestReloadClearsListing(t *testing.T) {
+	dir := t.TempDir()
+	writeWatchedArchive(t, dir, "a", []byte{0, 1}, time.Unix(1000, 0))
+	server := newWatchedServer(t, dir)
+	files, err := scanArchiveFiles(dir)
+	assert.Nil(t, err)
+
+	catalogNames := func() []string {
+		statusCode, _, data := server.Get(context.Background(), "/catalog")
+		assert.Equal(t, 200, statusCode)
+		var catalog Catalog
+		assert.Nil(t, json.Unmarshal(data, &catalog))
+		var names []string
+		for _, archive := range catalog.Archives {
+			names = append(names, archive.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"a"}, catalogNames())
+
+	// the listing is reused until the directory is scanned again
+	writeWatchedArchive(t, dir, "b", []byte{0, 1}, time.Unix(1000, 0))
+	assert.Equal(t, []string{"a"}, catalogNames())
+	files = server.reloadChangedFiles(dir, files, false)
+	assert.Equal(t, []string{"a", "b"}, catalogNames())
+
+	assert.Nil(t, os.Remove(filepath.Join(dir, "a.pmtiles")))
+	server.reloadChangedFiles(dir, files, false)
+	assert.Equal(t, []string{"b"}, catalogNames())
+}
+
+func TestWatchRequiresFileBucket(t *testing.T) {
+	_, server := newServer(t)
+	assert.NotNil(t, server.Watch(context.Background(), time.Second, false))
+
+	server = newWatchedServer(t, t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, server.Watch(ctx, time.Second, false))
+}
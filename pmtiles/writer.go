@@ -0,0 +1,152 @@
+package pmtiles
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+type spooledTile struct {
+	TileID uint64
+	Offset uint64
+	Length uint32
+}
+
+// Writer builds a clustered PMTiles archive from tiles supplied in any order.
+//
+// Tiles are spooled to a temporary file as they are written.
+// Finalize sorts them by TileID, deduplicates and run-length encodes
+// identical contents, and assembles the output archive.
+type Writer struct {
+	logger      *log.Logger
+	output      string
+	tmpdir      string
+	header      HeaderV3
+	metadata    map[string]interface{}
+	deduplicate bool
+	spool       *os.File
+	spoolOffset uint64
+	tiles       []spooledTile
+	finalized   bool
+}
+
+// NewWriter creates a Writer for the archive at output.
+// The header supplies the TileType, TileCompression, bounds and center of the archive;
+// directory offsets, zoom levels and tile counts are computed by Finalize.
+// Temporary files are created in tmpdir, or the default temporary directory if empty.
+func NewWriter(logger *log.Logger, output string, header HeaderV3, deduplicate bool, tmpdir string) (*Writer, error) {
+	spool, err := os.CreateTemp(tmpdir, "pmtiles-spool")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temp file, %w", err)
+	}
+
+	return &Writer{
+		logger:      logger,
+		output:      output,
+		tmpdir:      tmpdir,
+		header:      header,
+		metadata:    make(map[string]interface{}),
+		deduplicate: deduplicate,
+		spool:       spool,
+	}, nil
+}
+
+// SetMetadata replaces the JSON metadata stored in the archive.
+func (w *Writer) SetMetadata(metadata map[string]interface{}) {
+	w.metadata = metadata
+}
+
+// WriteTile adds the contents of a single tile. Empty tiles are skipped.
+// Each tile may only be written once.
+func (w *Writer) WriteTile(z uint8, x uint32, y uint32, data []byte) error {
+	if w.finalized {
+		return fmt.Errorf("writer is already finalized")
+	}
+	if z > 31 || x >= (1<<z) || y >= (1<<z) {
+		return fmt.Errorf("tile %d %d %d is out of range", z, x, y)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if uint64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("tile %d %d %d is too large", z, x, y)
+	}
+
+	_, err := w.spool.Write(data)
+	if err != nil {
+		return fmt.Errorf("Failed to write to tempfile, %w", err)
+	}
+	w.tiles = append(w.tiles, spooledTile{ZxyToID(z, x, y), w.spoolOffset, uint32(len(data))})
+	w.spoolOffset += uint64(len(data))
+	return nil
+}
+
+// Finalize writes the archive to the output path and removes temporary files.
+// The Writer cannot be used after Finalize.
+func (w *Writer) Finalize() (HeaderV3, error) {
+	if w.finalized {
+		return w.header, fmt.Errorf("writer is already finalized")
+	}
+	w.finalized = true
+	defer os.Remove(w.spool.Name())
+	defer w.spool.Close()
+
+	if len(w.tiles) == 0 {
+		return w.header, fmt.Errorf("no tiles written")
+	}
+
+	sort.Slice(w.tiles, func(i, j int) bool {
+		return w.tiles[i].TileID < w.tiles[j].TileID
+	})
+
+	tmpfile, err := os.CreateTemp(w.tmpdir, "pmtiles")
+	if err != nil {
+		return w.header, fmt.Errorf("Failed to create temp file, %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	header := w.header
+	header.SpecVersion = 3
+	if header.MinLonE7 == 0 && header.MinLatE7 == 0 && header.MaxLonE7 == 0 && header.MaxLatE7 == 0 {
+		E7 := 10000000.0
+		header.MinLonE7 = int32(-180 * E7)
+		header.MinLatE7 = int32(-85 * E7)
+		header.MaxLonE7 = int32(180 * E7)
+		header.MaxLatE7 = int32(85 * E7)
+	}
+
+	compress := header.TileType == Mvt && (header.TileCompression == UnknownCompression || header.TileCompression == Gzip)
+	resolve := newResolver(w.deduplicate, compress)
+	buf := make([]byte, 0)
+
+	for i, t := range w.tiles {
+		if i > 0 && t.TileID == w.tiles[i-1].TileID {
+			z, x, y := IDToZxy(t.TileID)
+			return header, fmt.Errorf("tile %d %d %d was written more than once", z, x, y)
+		}
+		if cap(buf) < int(t.Length) {
+			buf = make([]byte, t.Length)
+		}
+		data := buf[:t.Length]
+		_, err := w.spool.ReadAt(data, int64(t.Offset))
+		if err != nil {
+			return header, fmt.Errorf("Failed to read from tempfile, %w", err)
+		}
+		if isNew, newData := resolve.AddTileIsNew(t.TileID, data, 1); isNew {
+			_, err := tmpfile.Write(newData)
+			if err != nil {
+				return header, fmt.Errorf("Failed to write to tempfile, %w", err)
+			}
+		}
+	}
+
+	header, err = finalize(w.logger, resolve, header, tmpfile, w.output, w.metadata)
+	if err != nil {
+		return header, err
+	}
+	w.header = header
+	return header, nil
+}
@@ -0,0 +1,83 @@
+package pmtiles
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriterOutOfOrder(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "written.pmtiles")
+	w, err := NewWriter(logger, output, HeaderV3{TileType: Png}, true, t.TempDir())
+	assert.Nil(t, err)
+	w.SetMetadata(map[string]interface{}{"name": "test"})
+
+	assert.Nil(t, w.WriteTile(2, 3, 3, []byte{0x1, 0x2}))
+	assert.Nil(t, w.WriteTile(0, 0, 0, []byte{0x3}))
+	assert.Nil(t, w.WriteTile(1, 1, 1, []byte{0x1, 0x2}))
+	assert.Nil(t, w.WriteTile(1, 0, 0, []byte{0x1, 0x2}))
+	assert.Nil(t, w.WriteTile(1, 0, 1, []byte{}))
+
+	header, err := w.Finalize()
+	assert.Nil(t, err)
+	assert.True(t, header.Clustered)
+	assert.Equal(t, uint8(0), header.MinZoom)
+	assert.Equal(t, uint8(2), header.MaxZoom)
+	assert.Equal(t, uint64(4), header.AddressedTilesCount)
+	assert.Equal(t, uint64(2), header.TileContentsCount)
+
+	assert.Nil(t, Verify(logger, output))
+
+	file, _ := os.Open(output)
+	defer file.Close()
+	buf := make([]byte, HeaderV3LenBytes)
+	_, _ = file.Read(buf)
+	onDisk, err := DeserializeHeader(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, header, onDisk)
+}
+
+func TestWriterDuplicateTile(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "duplicate.pmtiles")
+	w, err := NewWriter(logger, output, HeaderV3{TileType: Png}, true, t.TempDir())
+	assert.Nil(t, err)
+	assert.Nil(t, w.WriteTile(0, 0, 0, []byte{0x1}))
+	assert.Nil(t, w.WriteTile(0, 0, 0, []byte{0x2}))
+	_, err = w.Finalize()
+	assert.NotNil(t, err)
+}
+
+func TestWriterEmpty(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "empty.pmtiles")
+	w, err := NewWriter(logger, output, HeaderV3{TileType: Png}, true, t.TempDir())
+	assert.Nil(t, err)
+	_, err = w.Finalize()
+	assert.NotNil(t, err)
+	assert.NotNil(t, w.WriteTile(0, 0, 0, []byte{0x1}))
+}
+
+func TestWriterOutOfRange(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "range.pmtiles")
+	w, err := NewWriter(logger, output, HeaderV3{TileType: Png}, true, t.TempDir())
+	assert.Nil(t, err)
+	assert.NotNil(t, w.WriteTile(1, 2, 0, []byte{0x1}))
+}
+
+func TestWriterMvtCompression(t *testing.T) {
+	for _, compression := range []Compression{NoCompression, Zstd} {
+		output := filepath.Join(t.TempDir(), "mvt.pmtiles")
+		w, err := NewWriter(logger, output, HeaderV3{TileType: Mvt, TileCompression: compression}, true, t.TempDir())
+		assert.Nil(t, err)
+		assert.Nil(t, w.WriteTile(0, 0, 0, []byte{0x1, 0x2, 0x3}))
+		header, err := w.Finalize()
+		assert.Nil(t, err)
+		assert.Equal(t, compression, header.TileCompression)
+
+		// tiles are stored as written, not gzipped
+		data, err := os.ReadFile(output)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(3), header.TileDataLength)
+		assert.Equal(t, []byte{0x1, 0x2, 0x3}, data[header.TileDataOffset:header.TileDataOffset+header.TileDataLength])
+	}
+}